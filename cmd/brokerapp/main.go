@@ -9,12 +9,18 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
+	"brokerapp/internal/calendar"
 	"brokerapp/internal/config"
 	"brokerapp/internal/db"
 	"brokerapp/internal/holdings"
+	"brokerapp/internal/matching"
 	"brokerapp/internal/orderbook"
+	"brokerapp/internal/performance"
 	"brokerapp/internal/positions"
+	"brokerapp/internal/pricefeed"
+	"brokerapp/internal/risk"
 	"brokerapp/internal/user"
 	"brokerapp/pkg/authmiddleware"
 
@@ -53,16 +59,88 @@ func main() {
 	defer mysqlDB.Close()
 
 	// Initialize repositories
-	userRepo := user.NewMySQLRepository(mysqlDB)
+	userRepo := user.NewMySQLRepository(mysqlDB, cfg.OpeningBalance)
+	costBasis, err := positions.ParseMethod(cfg.CostBasisMethod)
+	if err != nil {
+		log.Fatalf("Invalid COST_BASIS_METHOD: %v", err)
+	}
+	orderRepo := orderbook.NewMySQLRepository(mysqlDB, positions.NewLedger(costBasis))
+	riskRepo := risk.NewMySQLRepository(mysqlDB)
+	positionsRepo := positions.NewMySQLRepository(mysqlDB)
+	performanceRepo := performance.NewMySQLRepository(mysqlDB, riskRepo)
+
+	// Initialize matching engine
+	engine := matching.NewEngine()
+
+	// Initialize pre-trade risk checks
+	riskChecks := []risk.Check{risk.BuyingPower(riskRepo), risk.SellQuantity(riskRepo)}
+	if cfg.MaxOrderNotional > 0 {
+		riskChecks = append(riskChecks, risk.MaxNotional(cfg.MaxOrderNotional))
+	}
+	if cfg.PriceBandPercent > 0 {
+		riskChecks = append(riskChecks, risk.PriceBand(cfg.PriceBandPercent))
+	}
+
+	// Load the trading calendar; without one every symbol trades around the clock
+	var tradingCalendar *calendar.Calendar
+	if cfg.TradingCalendarFile != "" {
+		tradingCalendar, err = calendar.Load(cfg.TradingCalendarFile)
+		if err != nil {
+			log.Fatalf("Failed to load trading calendar: %v", err)
+		}
+	}
+
+	// Initialize call auction windows
+	var auctionWindows []orderbook.AuctionWindow
+	if w := cfg.OpeningAuction; w.End > 0 {
+		auctionWindows = append(auctionWindows, orderbook.AuctionWindow{Name: "opening", Start: w.Start, End: w.End})
+	}
+	if w := cfg.ClosingAuction; w.End > 0 {
+		auctionWindows = append(auctionWindows, orderbook.AuctionWindow{Name: "closing", Start: w.Start, End: w.End})
+	}
+
+	// Initialize the price feed positions and holdings are marked to market with
+	var priceFeed pricefeed.PriceFeed
+	switch cfg.PriceFeed {
+	case "":
+	case "replay":
+		replay, err := pricefeed.LoadReplay(cfg.PriceFeedFile)
+		if err != nil {
+			log.Fatalf("Failed to load price feed: %v", err)
+		}
+		priceFeed = replay
+	case "random_walk":
+		priceFeed = pricefeed.NewRandomWalk(cfg.PriceFeedVolatility, time.Now().UnixNano())
+	default:
+		log.Fatalf("Invalid PRICE_FEED: %q, expected replay or random_walk", cfg.PriceFeed)
+	}
 
 	// Initialize services
 	userService := user.NewService(userRepo, cfg.JWTSecret)
+	positionsService := positions.NewService(positionsRepo,
+		positions.WithPriceFeed(priceFeed),
+		positions.WithEndOfDay(cfg.MarketTimezone, cfg.PNLSnapshotTime),
+	)
+	orderService := orderbook.NewService(orderRepo, engine,
+		orderbook.WithMarketClose(cfg.MarketTimezone, cfg.MarketCloseTime),
+		orderbook.WithDepthLevels(cfg.DepthLevels, cfg.MaxDepthLevels),
+		orderbook.WithRiskChecks(risk.NewPipeline(riskChecks...)),
+		orderbook.WithAuctions(cfg.AuctionSymbols, auctionWindows...),
+		orderbook.WithCalendar(tradingCalendar),
+		orderbook.WithPositions(positionsService),
+	)
+	performanceService := performance.NewService(performanceRepo,
+		performance.WithRiskFreeRate(cfg.RiskFreeRate),
+		performance.WithLocation(cfg.MarketTimezone),
+		performance.WithUserLock(orderService.LockUser),
+	)
 
 	// Initialize handlers
 	userHandler := user.NewHandler(userService)
 	holdingsHandler := holdings.NewHandler(mysqlDB)
-	orderbookHandler := orderbook.NewHandler(mysqlDB)
-	positionsHandler := positions.NewHandler(mysqlDB)
+	orderbookHandler := orderbook.NewHandler(orderService)
+	positionsHandler := positions.NewHandler(positionsService)
+	performanceHandler := performance.NewHandler(performanceService)
 
 	// Initialize router
 	r := chi.NewRouter()
@@ -90,8 +168,9 @@ func main() {
 			r.Use(authmiddleware.AuthMiddleware(cfg.JWTSecret))
 			r.Get("/profile", userHandler.GetProfile)
 			holdingsHandler.RegisterRoutes(r)
-			r.Get("/orderbook", orderbookHandler.GetOrderbook)
-			r.Get("/positions", positionsHandler.GetPositions)
+			positionsHandler.RegisterRoutes(r)
+			performanceHandler.RegisterRoutes(r)
+			r.Group(orderbookHandler.RegisterRoutes)
 		})
 	})
 
@@ -109,11 +188,43 @@ func main() {
 		}
 	}()
 
+	// Rebuild the order book; order routes answer 503 until this is done
+	if err := orderService.Recover(context.Background()); err != nil {
+		log.Fatalf("Failed to recover order book: %v", err)
+	}
+
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go orderService.RunExpirySweeper(workerCtx, cfg.OrderExpiryInterval)
+	if cfg.SnapshotInterval > 0 {
+		go orderService.RunSnapshotter(workerCtx, cfg.SnapshotInterval)
+	}
+	if cfg.MarkPriceInterval > 0 {
+		go orderService.RunMarkPricer(workerCtx, cfg.MarkPriceInterval)
+	}
+	if priceFeed != nil && cfg.MarkToMarketInterval > 0 {
+		go positionsService.RunMarkToMarket(workerCtx, cfg.MarkToMarketInterval)
+	}
+	if cfg.PNLSnapshotInterval > 0 {
+		go positionsService.RunSnapshots(workerCtx, cfg.PNLSnapshotInterval)
+	}
+	if tradingCalendar != nil && cfg.QueueReleaseInterval > 0 {
+		go orderService.RunQueueReleaser(workerCtx, cfg.QueueReleaseInterval)
+	}
+	if cfg.AuctionInterval > 0 {
+		// also uncrosses books that come back crossed from a restart
+		go orderService.RunAuctioneer(workerCtx, cfg.AuctionInterval)
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Stop background workers
+	stopWorkers()
+
 	// Create shutdown context
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -123,5 +234,12 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Snapshot the book so the next start has less to read back
+	if cfg.SnapshotInterval > 0 {
+		if err := orderService.TakeSnapshot(ctx); err != nil {
+			log.Printf("Failed to snapshot order book: %v", err)
+		}
+	}
+
 	log.Println("Server exiting")
 }
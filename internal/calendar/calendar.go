@@ -0,0 +1,360 @@
+package calendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Session is the part of the trading day a point in time falls in
+type Session string
+
+const (
+	Closed     Session = "closed"
+	PreMarket  Session = "pre_market"
+	Regular    Session = "regular"
+	PostMarket Session = "post_market"
+)
+
+// Extended reports whether the session is outside regular trading hours
+func (s Session) Extended() bool {
+	return s == PreMarket || s == PostMarket
+}
+
+// dateLayout is how holidays and half-days are written in the file
+const dateLayout = "2006-01-02"
+
+// Calendar knows the trading hours of every configured exchange and which
+// exchange each symbol trades on
+type Calendar struct {
+	exchanges map[string]*Exchange
+	symbols   map[string]*Exchange
+	fallback  *Exchange
+}
+
+// Exchange holds the sessions, trading days, holidays and half-days of one
+// exchange. Session times are in the exchange's own time zone and a session
+// may not run past midnight.
+type Exchange struct {
+	Name string
+
+	loc      *time.Location
+	pre      window
+	regular  window
+	post     window
+	weekdays map[time.Weekday]bool
+	holidays map[string]bool
+	// halfDays map a date to its early regular close; there is no
+	// post-market session on those days
+	halfDays map[string]time.Duration
+}
+
+// window is a daily period given as offsets from midnight; the zero window
+// means there is no such session
+type window struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// span is a session on a particular day
+type span struct {
+	session    Session
+	start, end time.Time
+}
+
+// file is the layout of the calendar config file
+type file struct {
+	// DefaultExchange applies to symbols no exchange lists; empty leaves
+	// them unrestricted
+	DefaultExchange string         `json:"default_exchange"`
+	Exchanges       []exchangeFile `json:"exchanges"`
+}
+
+type exchangeFile struct {
+	Name       string            `json:"name"`
+	Timezone   string            `json:"timezone"`
+	Symbols    []string          `json:"symbols"`
+	Weekdays   []string          `json:"weekdays"`    // defaults to Monday to Friday
+	PreMarket  string            `json:"pre_market"`  // HH:MM-HH:MM, optional
+	Regular    string            `json:"regular"`     // HH:MM-HH:MM
+	PostMarket string            `json:"post_market"` // HH:MM-HH:MM, optional
+	Holidays   []string          `json:"holidays"`    // YYYY-MM-DD
+	HalfDays   map[string]string `json:"half_days"`   // YYYY-MM-DD to the HH:MM early close
+}
+
+// Load reads a calendar from a JSON config file
+func Load(path string) (*Calendar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads a calendar from the contents of a JSON config file
+func Parse(data []byte) (*Calendar, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	c := &Calendar{
+		exchanges: make(map[string]*Exchange),
+		symbols:   make(map[string]*Exchange),
+	}
+	for _, ef := range f.Exchanges {
+		x, err := parseExchange(ef)
+		if err != nil {
+			return nil, fmt.Errorf("exchange %q: %w", ef.Name, err)
+		}
+		if _, ok := c.exchanges[x.Name]; ok {
+			return nil, fmt.Errorf("exchange %q is listed twice", x.Name)
+		}
+		c.exchanges[x.Name] = x
+
+		for _, symbol := range ef.Symbols {
+			symbol = strings.ToUpper(strings.TrimSpace(symbol))
+			if other, ok := c.symbols[symbol]; ok {
+				return nil, fmt.Errorf("symbol %s is listed on both %s and %s", symbol, other.Name, x.Name)
+			}
+			c.symbols[symbol] = x
+		}
+	}
+
+	if f.DefaultExchange != "" {
+		x, ok := c.exchanges[f.DefaultExchange]
+		if !ok {
+			return nil, fmt.Errorf("default exchange %q is not configured", f.DefaultExchange)
+		}
+		c.fallback = x
+	}
+	return c, nil
+}
+
+func parseExchange(ef exchangeFile) (*Exchange, error) {
+	if ef.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	loc, err := time.LoadLocation(ef.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Exchange{
+		Name:     ef.Name,
+		loc:      loc,
+		weekdays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+		halfDays: make(map[string]time.Duration),
+	}
+
+	if x.regular, err = parseWindow(ef.Regular); err != nil {
+		return nil, fmt.Errorf("regular: %w", err)
+	}
+	if x.regular.end == 0 {
+		return nil, fmt.Errorf("regular session is required")
+	}
+	if x.pre, err = parseWindow(ef.PreMarket); err != nil {
+		return nil, fmt.Errorf("pre_market: %w", err)
+	}
+	if x.pre.end != 0 && x.pre.end > x.regular.start {
+		return nil, fmt.Errorf("pre_market must end by the regular open")
+	}
+	if x.post, err = parseWindow(ef.PostMarket); err != nil {
+		return nil, fmt.Errorf("post_market: %w", err)
+	}
+	if x.post.end != 0 && x.post.start < x.regular.end {
+		return nil, fmt.Errorf("post_market must start at or after the regular close")
+	}
+
+	weekdays := ef.Weekdays
+	if len(weekdays) == 0 {
+		weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+	}
+	for _, name := range weekdays {
+		day, ok := weekdayNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+		x.weekdays[day] = true
+	}
+
+	for _, date := range ef.Holidays {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return nil, fmt.Errorf("holiday: %w", err)
+		}
+		x.holidays[date] = true
+	}
+	for date, close := range ef.HalfDays {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return nil, fmt.Errorf("half day: %w", err)
+		}
+		offset, err := parseTime(close)
+		if err != nil {
+			return nil, fmt.Errorf("half day %s: %w", date, err)
+		}
+		if offset <= x.regular.start || offset >= x.regular.end {
+			return nil, fmt.Errorf("half day %s must close during regular hours", date)
+		}
+		x.halfDays[date] = offset
+	}
+	return x, nil
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parseWindow parses a session written as HH:MM-HH:MM. An empty value gives
+// the zero window.
+func parseWindow(value string) (window, error) {
+	if strings.TrimSpace(value) == "" {
+		return window{}, nil
+	}
+
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return window{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", value)
+	}
+	start, err := parseTime(from)
+	if err != nil {
+		return window{}, err
+	}
+	end, err := parseTime(to)
+	if err != nil {
+		return window{}, err
+	}
+	if end <= start {
+		return window{}, fmt.Errorf("session must end after it starts, got %q", value)
+	}
+	return window{start: start, end: end}, nil
+}
+
+// parseTime parses HH:MM into an offset from midnight. 24:00 is allowed for
+// sessions that run to the end of the day.
+func parseTime(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Exchange returns the exchange symbol trades on, or nil if the calendar does
+// not restrict it. It is safe to call on a nil Calendar.
+func (c *Calendar) Exchange(symbol string) *Exchange {
+	if c == nil {
+		return nil
+	}
+	if x, ok := c.symbols[strings.ToUpper(symbol)]; ok {
+		return x
+	}
+	return c.fallback
+}
+
+// Location returns the exchange's time zone
+func (x *Exchange) Location() *time.Location {
+	return x.loc
+}
+
+// Session returns the session the exchange is in at t
+func (x *Exchange) Session(t time.Time) Session {
+	for _, s := range x.day(t.In(x.loc)) {
+		if !t.Before(s.start) && t.Before(s.end) {
+			return s.session
+		}
+	}
+	return Closed
+}
+
+// IsHoliday reports whether the exchange is closed all day on t's date
+func (x *Exchange) IsHoliday(t time.Time) bool {
+	local := t.In(x.loc)
+	return !x.weekdays[local.Weekday()] || x.holidays[local.Format(dateLayout)]
+}
+
+// NextOpen returns the first time at or after t an order can trade: during
+// regular hours, or in any session when extended is set. It returns the zero
+// time if the exchange does not open within a year.
+func (x *Exchange) NextOpen(t time.Time, extended bool) time.Time {
+	local := t.In(x.loc)
+	for i := 0; i <= 366; i++ {
+		for _, s := range x.day(local.AddDate(0, 0, i)) {
+			if !allowed(s.session, extended) || !s.end.After(t) {
+				continue
+			}
+			if s.start.After(t) {
+				return s.start
+			}
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// NextClose returns the first close strictly after t of the hours an order
+// trades in: the end of regular hours, or when extended is set the end of the
+// last session of the day. It returns the zero time if the exchange does not
+// trade within a year.
+func (x *Exchange) NextClose(t time.Time, extended bool) time.Time {
+	local := t.In(x.loc)
+	for i := 0; i <= 366; i++ {
+		var close time.Time
+		for _, s := range x.day(local.AddDate(0, 0, i)) {
+			if allowed(s.session, extended) {
+				close = s.end
+			}
+		}
+		if close.After(t) {
+			return close
+		}
+	}
+	return time.Time{}
+}
+
+func allowed(s Session, extended bool) bool {
+	return s == Regular || (extended && s.Extended())
+}
+
+// day returns the sessions on the date of local, in order
+func (x *Exchange) day(local time.Time) []span {
+	if x.IsHoliday(local) {
+		return nil
+	}
+
+	year, month, day := local.Date()
+	at := func(offset time.Duration) time.Time {
+		// built from the wall clock so that sessions keep their hours on
+		// days the clocks change
+		return time.Date(year, month, day, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, x.loc)
+	}
+
+	regular := x.regular
+	post := x.post
+	if close, ok := x.halfDays[local.Format(dateLayout)]; ok {
+		regular.end = close
+		post = window{}
+	}
+
+	var spans []span
+	if x.pre.end != 0 {
+		spans = append(spans, span{session: PreMarket, start: at(x.pre.start), end: at(x.pre.end)})
+	}
+	spans = append(spans, span{session: Regular, start: at(regular.start), end: at(regular.end)})
+	if post.end != 0 {
+		spans = append(spans, span{session: PostMarket, start: at(post.start), end: at(post.end)})
+	}
+	return spans
+}
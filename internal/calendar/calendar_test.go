@@ -0,0 +1,120 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCalendar = `{
+	"default_exchange": "XNYS",
+	"exchanges": [
+		{
+			"name": "XNYS",
+			"timezone": "America/New_York",
+			"symbols": ["aapl", "MSFT"],
+			"pre_market": "04:00-09:30",
+			"regular": "09:30-16:00",
+			"post_market": "16:00-20:00",
+			"holidays": ["2024-12-25"],
+			"half_days": {"2024-12-24": "13:00"}
+		},
+		{
+			"name": "XLON",
+			"timezone": "Europe/London",
+			"symbols": ["VOD"],
+			"regular": "08:00-16:30"
+		}
+	]
+}`
+
+func mustParse(t *testing.T) *Calendar {
+	c, err := Parse([]byte(testCalendar))
+	assert.NoError(t, err)
+	return c
+}
+
+func TestExchangeForSymbol(t *testing.T) {
+	c := mustParse(t)
+	assert.Equal(t, "XNYS", c.Exchange("AAPL").Name)
+	assert.Equal(t, "XLON", c.Exchange("VOD").Name)
+	assert.Equal(t, "XNYS", c.Exchange("TSLA").Name)
+
+	var none *Calendar
+	assert.Nil(t, none.Exchange("AAPL"))
+}
+
+func TestSession(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	x := mustParse(t).Exchange("AAPL")
+
+	tests := []struct {
+		at   time.Time
+		want Session
+	}{
+		{time.Date(2024, 12, 23, 3, 59, 0, 0, ny), Closed},
+		{time.Date(2024, 12, 23, 4, 0, 0, 0, ny), PreMarket},
+		{time.Date(2024, 12, 23, 9, 30, 0, 0, ny), Regular},
+		{time.Date(2024, 12, 23, 16, 0, 0, 0, ny), PostMarket},
+		{time.Date(2024, 12, 23, 20, 0, 0, 0, ny), Closed},
+		// half-day: early close and no post-market
+		{time.Date(2024, 12, 24, 12, 59, 0, 0, ny), Regular},
+		{time.Date(2024, 12, 24, 13, 0, 0, 0, ny), Closed},
+		{time.Date(2024, 12, 24, 16, 30, 0, 0, ny), Closed},
+		// holiday and weekend
+		{time.Date(2024, 12, 25, 10, 0, 0, 0, ny), Closed},
+		{time.Date(2024, 12, 28, 10, 0, 0, 0, ny), Closed},
+		// the same instant seen from another zone
+		{time.Date(2024, 12, 23, 14, 30, 0, 0, time.UTC), Regular},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, x.Session(tt.at), tt.at.String())
+	}
+}
+
+func TestSessionKeepsHoursAcrossClockChange(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	x := mustParse(t).Exchange("AAPL")
+
+	// clocks went forward on 2024-03-10
+	assert.Equal(t, Regular, x.Session(time.Date(2024, 3, 11, 9, 30, 0, 0, ny)))
+	assert.Equal(t, PreMarket, x.Session(time.Date(2024, 3, 11, 9, 29, 0, 0, ny)))
+}
+
+func TestNextOpenAndClose(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	x := mustParse(t).Exchange("AAPL")
+
+	// Tuesday evening before Christmas: the half-day has no post-market and
+	// Christmas is a holiday
+	at := time.Date(2024, 12, 24, 18, 0, 0, 0, ny)
+	assert.Equal(t, time.Date(2024, 12, 26, 9, 30, 0, 0, ny), x.NextOpen(at, false))
+	assert.Equal(t, time.Date(2024, 12, 26, 4, 0, 0, 0, ny), x.NextOpen(at, true))
+	assert.Equal(t, time.Date(2024, 12, 26, 16, 0, 0, 0, ny), x.NextClose(at, false))
+	assert.Equal(t, time.Date(2024, 12, 26, 20, 0, 0, 0, ny), x.NextClose(at, true))
+
+	// already open
+	at = time.Date(2024, 12, 24, 10, 0, 0, 0, ny)
+	assert.Equal(t, at, x.NextOpen(at, false))
+	assert.Equal(t, time.Date(2024, 12, 24, 13, 0, 0, 0, ny), x.NextClose(at, false))
+	assert.Equal(t, time.Date(2024, 12, 24, 13, 0, 0, 0, ny), x.NextClose(at, true))
+}
+
+func TestParseRejectsInvalidCalendars(t *testing.T) {
+	tests := map[string]string{
+		"bad time zone":        `{"exchanges": [{"name": "X", "timezone": "Mars/Base", "regular": "09:30-16:00"}]}`,
+		"no regular session":   `{"exchanges": [{"name": "X", "timezone": "UTC"}]}`,
+		"overlapping pre":      `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00", "pre_market": "04:00-10:00"}]}`,
+		"backwards session":    `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "16:00-09:30"}]}`,
+		"unknown weekday":      `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00", "weekdays": ["Funday"]}]}`,
+		"bad holiday":          `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00", "holidays": ["25/12/2024"]}]}`,
+		"half day after close": `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00", "half_days": {"2024-12-24": "17:00"}}]}`,
+		"symbol listed twice":  `{"exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00", "symbols": ["A"]}, {"name": "Y", "timezone": "UTC", "regular": "09:30-16:00", "symbols": ["a"]}]}`,
+		"unknown default":      `{"default_exchange": "Z", "exchanges": [{"name": "X", "timezone": "UTC", "regular": "09:30-16:00"}]}`,
+	}
+	for name, data := range tests {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,6 +28,50 @@ type Config struct {
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
+
+	// Order Configuration
+	MarketTimezone      *time.Location
+	MarketCloseTime     time.Duration // offset from midnight in MarketTimezone
+	OrderExpiryInterval time.Duration
+	SnapshotInterval    time.Duration // zero disables order book snapshots
+	MarkPriceInterval   time.Duration // zero disables trailing stop updates
+
+	// Trading Calendar Configuration
+	TradingCalendarFile  string        // empty trades around the clock
+	QueueReleaseInterval time.Duration // zero disables releasing queued orders
+
+	// Auction Configuration
+	AuctionSymbols  []string      // empty disables call auctions
+	OpeningAuction  TimeWindow    // zero disables the opening auction
+	ClosingAuction  TimeWindow    // zero disables the closing auction
+	AuctionInterval time.Duration // zero disables call auctions
+
+	// Market Data Configuration
+	DepthLevels    int
+	MaxDepthLevels int
+
+	// Positions Configuration
+	CostBasisMethod      string        // average, fifo or lifo
+	PriceFeed            string        // replay or random_walk, empty for none
+	PriceFeedFile        string        // prices the replay feed plays back
+	PriceFeedVolatility  float64       // percent per step of the random walk feed
+	MarkToMarketInterval time.Duration // zero disables marking to market
+	PNLSnapshotTime      time.Duration // offset from midnight in MarketTimezone
+	PNLSnapshotInterval  time.Duration // zero disables the end of day snapshots
+	RiskFreeRate         float64       // yearly percent the Sharpe ratio is measured against
+
+	// Account Configuration
+	OpeningBalance float64 // cash a new user's account starts with
+
+	// Risk Configuration
+	MaxOrderNotional float64 // zero disables the check
+	PriceBandPercent float64 // zero disables the check
+}
+
+// TimeWindow is a daily period given as offsets from midnight in MarketTimezone
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
 }
 
 func Load() (*Config, error) {
@@ -57,6 +102,110 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("Invalid DB_MAX_IDLE_CONNS: %v", err)
 	}
 
+	marketTimezone, err := time.LoadLocation(getEnv("MARKET_TIMEZONE", "America/New_York"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARKET_TIMEZONE: %v", err)
+	}
+
+	marketClose, err := time.Parse("15:04", getEnv("MARKET_CLOSE_TIME", "16:00"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARKET_CLOSE_TIME: %v", err)
+	}
+	marketCloseTime := time.Duration(marketClose.Hour())*time.Hour + time.Duration(marketClose.Minute())*time.Minute
+
+	orderExpiryInterval, err := time.ParseDuration(getEnv("ORDER_EXPIRY_INTERVAL", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ORDER_EXPIRY_INTERVAL: %v", err)
+	}
+
+	snapshotInterval, err := time.ParseDuration(getEnv("BOOK_SNAPSHOT_INTERVAL", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid BOOK_SNAPSHOT_INTERVAL: %v", err)
+	}
+
+	markPriceInterval, err := time.ParseDuration(getEnv("MARK_PRICE_INTERVAL", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARK_PRICE_INTERVAL: %v", err)
+	}
+
+	queueReleaseInterval, err := time.ParseDuration(getEnv("QUEUE_RELEASE_INTERVAL", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid QUEUE_RELEASE_INTERVAL: %v", err)
+	}
+
+	openingAuction, err := parseWindow(getEnv("OPENING_AUCTION", "09:25-09:30"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OPENING_AUCTION: %v", err)
+	}
+
+	closingAuction, err := parseWindow(getEnv("CLOSING_AUCTION", "15:50-16:00"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CLOSING_AUCTION: %v", err)
+	}
+
+	auctionInterval, err := time.ParseDuration(getEnv("AUCTION_INTERVAL", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid AUCTION_INTERVAL: %v", err)
+	}
+
+	var auctionSymbols []string
+	for _, symbol := range strings.Split(getEnv("AUCTION_SYMBOLS"), ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			auctionSymbols = append(auctionSymbols, symbol)
+		}
+	}
+
+	depthLevels, err := strconv.Atoi(getEnv("MARKET_DEPTH_LEVELS", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARKET_DEPTH_LEVELS: %v", err)
+	}
+
+	maxDepthLevels, err := strconv.Atoi(getEnv("MARKET_DEPTH_MAX_LEVELS", "50"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARKET_DEPTH_MAX_LEVELS: %v", err)
+	}
+
+	priceFeedVolatility, err := strconv.ParseFloat(getEnv("PRICE_FEED_VOLATILITY", "0.5"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PRICE_FEED_VOLATILITY: %v", err)
+	}
+
+	markToMarketInterval, err := time.ParseDuration(getEnv("MARK_TO_MARKET_INTERVAL", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid MARK_TO_MARKET_INTERVAL: %v", err)
+	}
+
+	pnlSnapshot, err := time.Parse("15:04", getEnv("PNL_SNAPSHOT_TIME", "16:30"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PNL_SNAPSHOT_TIME: %v", err)
+	}
+	pnlSnapshotTime := time.Duration(pnlSnapshot.Hour())*time.Hour + time.Duration(pnlSnapshot.Minute())*time.Minute
+
+	pnlSnapshotInterval, err := time.ParseDuration(getEnv("PNL_SNAPSHOT_INTERVAL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PNL_SNAPSHOT_INTERVAL: %v", err)
+	}
+
+	riskFreeRate, err := strconv.ParseFloat(getEnv("RISK_FREE_RATE", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RISK_FREE_RATE: %v", err)
+	}
+
+	openingBalance, err := strconv.ParseFloat(getEnv("ACCOUNT_OPENING_BALANCE", "100000"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ACCOUNT_OPENING_BALANCE: %v", err)
+	}
+
+	maxOrderNotional, err := strconv.ParseFloat(getEnv("RISK_MAX_ORDER_NOTIONAL", "1000000"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RISK_MAX_ORDER_NOTIONAL: %v", err)
+	}
+
+	priceBandPercent, err := strconv.ParseFloat(getEnv("RISK_PRICE_BAND_PERCENT", "10"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RISK_PRICE_BAND_PERCENT: %v", err)
+	}
+
 	cfg := &Config{
 		// Database Configuration
 		DBHost:     getEnv("DB_HOST", "localhost"),
@@ -77,6 +226,44 @@ func Load() (*Config, error) {
 		MaxOpenConns:    maxOpenConns,
 		MaxIdleConns:    maxIdleConns,
 		ConnMaxLifetime: connMaxLifetime,
+
+		// Order Configuration
+		MarketTimezone:      marketTimezone,
+		MarketCloseTime:     marketCloseTime,
+		OrderExpiryInterval: orderExpiryInterval,
+		SnapshotInterval:    snapshotInterval,
+		MarkPriceInterval:   markPriceInterval,
+
+		// Trading Calendar Configuration
+		TradingCalendarFile:  getEnv("TRADING_CALENDAR_FILE"),
+		QueueReleaseInterval: queueReleaseInterval,
+
+		// Auction Configuration
+		AuctionSymbols:  auctionSymbols,
+		OpeningAuction:  openingAuction,
+		ClosingAuction:  closingAuction,
+		AuctionInterval: auctionInterval,
+
+		// Market Data Configuration
+		DepthLevels:    depthLevels,
+		MaxDepthLevels: maxDepthLevels,
+
+		// Positions Configuration
+		CostBasisMethod:      getEnv("COST_BASIS_METHOD", "fifo"),
+		PriceFeed:            getEnv("PRICE_FEED"),
+		PriceFeedFile:        getEnv("PRICE_FEED_FILE"),
+		PriceFeedVolatility:  priceFeedVolatility,
+		MarkToMarketInterval: markToMarketInterval,
+		PNLSnapshotTime:      pnlSnapshotTime,
+		PNLSnapshotInterval:  pnlSnapshotInterval,
+		RiskFreeRate:         riskFreeRate,
+
+		// Account Configuration
+		OpeningBalance: openingBalance,
+
+		// Risk Configuration
+		MaxOrderNotional: maxOrderNotional,
+		PriceBandPercent: priceBandPercent,
 	}
 
 	// Validate required environment variables
@@ -100,10 +287,63 @@ func Load() (*Config, error) {
 	fmt.Printf("DB_MAX_OPEN_CONNS: %d\n", cfg.MaxOpenConns)
 	fmt.Printf("DB_MAX_IDLE_CONNS: %d\n", cfg.MaxIdleConns)
 	fmt.Printf("DB_CONN_MAX_LIFETIME: %v\n", cfg.ConnMaxLifetime)
+	fmt.Printf("MARKET_TIMEZONE: %s\n", cfg.MarketTimezone)
+	fmt.Printf("MARKET_CLOSE_TIME: %v\n", cfg.MarketCloseTime)
+	fmt.Printf("ORDER_EXPIRY_INTERVAL: %v\n", cfg.OrderExpiryInterval)
+	fmt.Printf("BOOK_SNAPSHOT_INTERVAL: %v\n", cfg.SnapshotInterval)
+	fmt.Printf("MARK_PRICE_INTERVAL: %v\n", cfg.MarkPriceInterval)
+	fmt.Printf("TRADING_CALENDAR_FILE: %s\n", cfg.TradingCalendarFile)
+	fmt.Printf("QUEUE_RELEASE_INTERVAL: %v\n", cfg.QueueReleaseInterval)
+	fmt.Printf("AUCTION_SYMBOLS: %s\n", strings.Join(cfg.AuctionSymbols, ","))
+	fmt.Printf("OPENING_AUCTION: %v-%v\n", cfg.OpeningAuction.Start, cfg.OpeningAuction.End)
+	fmt.Printf("CLOSING_AUCTION: %v-%v\n", cfg.ClosingAuction.Start, cfg.ClosingAuction.End)
+	fmt.Printf("AUCTION_INTERVAL: %v\n", cfg.AuctionInterval)
+	fmt.Printf("MARKET_DEPTH_LEVELS: %d\n", cfg.DepthLevels)
+	fmt.Printf("MARKET_DEPTH_MAX_LEVELS: %d\n", cfg.MaxDepthLevels)
+	fmt.Printf("COST_BASIS_METHOD: %s\n", cfg.CostBasisMethod)
+	fmt.Printf("PRICE_FEED: %s\n", cfg.PriceFeed)
+	fmt.Printf("PRICE_FEED_FILE: %s\n", cfg.PriceFeedFile)
+	fmt.Printf("PRICE_FEED_VOLATILITY: %.2f\n", cfg.PriceFeedVolatility)
+	fmt.Printf("MARK_TO_MARKET_INTERVAL: %v\n", cfg.MarkToMarketInterval)
+	fmt.Printf("PNL_SNAPSHOT_TIME: %v\n", cfg.PNLSnapshotTime)
+	fmt.Printf("PNL_SNAPSHOT_INTERVAL: %v\n", cfg.PNLSnapshotInterval)
+	fmt.Printf("RISK_FREE_RATE: %.2f\n", cfg.RiskFreeRate)
+	fmt.Printf("ACCOUNT_OPENING_BALANCE: %.2f\n", cfg.OpeningBalance)
+	fmt.Printf("RISK_MAX_ORDER_NOTIONAL: %.2f\n", cfg.MaxOrderNotional)
+	fmt.Printf("RISK_PRICE_BAND_PERCENT: %.2f\n", cfg.PriceBandPercent)
 
 	return cfg, nil
 }
 
+// parseWindow parses a daily window written as HH:MM-HH:MM. An empty value
+// gives the zero window.
+func parseWindow(value string) (TimeWindow, error) {
+	if strings.TrimSpace(value) == "" {
+		return TimeWindow{}, nil
+	}
+
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", value)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	if !end.After(start) {
+		return TimeWindow{}, fmt.Errorf("window must end after it starts, got %q", value)
+	}
+
+	offset := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return TimeWindow{Start: offset(start), End: offset(end)}, nil
+}
+
 func getEnv(key string, defaultValue ...string) string {
 	value, exists := os.LookupEnv(key)
 	if !exists {
@@ -3,11 +3,15 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"brokerapp/pkg/circuitbreaker"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/sony/gobreaker"
 )
 
@@ -27,8 +31,15 @@ func NewMySQL(dsn string) (*MySQL, error) {
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	// Create circuit breaker for database operations
-	cb := circuitbreaker.New("mysql-db",
+	return &MySQL{
+		db: db,
+		cb: newBreaker(),
+	}, nil
+}
+
+// newBreaker creates the circuit breaker for database operations
+func newBreaker() *circuitbreaker.CircuitBreaker {
+	return circuitbreaker.New("mysql-db",
 		circuitbreaker.WithMaxRequests(3),
 		circuitbreaker.WithInterval(30*time.Second),
 		circuitbreaker.WithTimeout(10*time.Second),
@@ -38,12 +49,23 @@ func NewMySQL(dsn string) (*MySQL, error) {
 		circuitbreaker.WithOnStateChange(func(name string, from gobreaker.State, to gobreaker.State) {
 			fmt.Printf("Circuit breaker %s state changed from %s to %s\n", name, from, to)
 		}),
+		circuitbreaker.WithIsSuccessful(func(err error) bool {
+			return err == nil || !unavailable(err)
+		}),
 	)
+}
 
-	return &MySQL{
-		db: db,
-		cb: cb,
-	}, nil
+// unavailable reports whether err means the database could not be reached or
+// did not answer in time. Errors the database answered with, such as a
+// duplicate key, and errors a transaction returns on purpose, such as a
+// rejected withdrawal, do not count against the circuit breaker.
+func unavailable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
 }
 
 // Query executes a query that returns rows
@@ -97,6 +119,32 @@ func (m *MySQL) Exec(ctx context.Context, query string, args ...interface{}) (sq
 	return result, nil
 }
 
+// WithTx runs fn inside a transaction, committing if fn succeeds and rolling
+// back otherwise. It waits for the transaction to finish even if ctx is
+// cancelled, so a nil error always means it was committed and any other error
+// that it was not.
+func (m *MySQL) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	_, err := m.cb.ExecuteSync(func() (interface{}, error) {
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		return nil, tx.Commit()
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to execute transaction: %w", err)
+	}
+
+	return nil
+}
+
 // Close closes the database connection
 func (m *MySQL) Close() error {
 	return m.db.Close()
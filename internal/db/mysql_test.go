@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"brokerapp/pkg/circuitbreaker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerIgnoresRejections(t *testing.T) {
+	cb := newBreaker()
+	ctx := context.Background()
+	fail := func(err error) error {
+		_, err = cb.ExecuteWithBreaker(ctx, func() (interface{}, error) { return nil, err })
+		return err
+	}
+
+	// a transaction turning down a short sale or a withdrawal again and
+	// again says nothing about the database
+	rejected := errors.New("not enough shares left to borrow to sell short")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, rejected, fail(rejected))
+	}
+
+	// losing the connection does
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, driver.ErrBadConn, fail(driver.ErrBadConn))
+	}
+	assert.Equal(t, circuitbreaker.ErrCircuitOpen, fail(nil))
+}
@@ -1,10 +1,13 @@
 package holdings
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"brokerapp/internal/db"
+	"brokerapp/internal/performance"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -82,19 +85,23 @@ func (h *Handler) CreateHolding(w http.ResponseWriter, r *http.Request) {
 	// Calculate value
 	value := float64(req.Quantity) * req.Price
 
-	query := `
-		INSERT INTO holdings (user_id, symbol, quantity, price, value)
-		VALUES (?, ?, ?, ?, ?)
-	`
-
-	_, err := h.db.Exec(r.Context(), query,
-		userID,
-		req.Symbol,
-		req.Quantity,
-		req.Price,
-		value,
-	)
+	// Record the holding as a transfer into the account, so performance
+	// analytics do not count its value as a return when it is part of it
+	err := h.db.WithTx(r.Context(), func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(r.Context(), `
+			INSERT INTO holdings (user_id, symbol, quantity, price, value)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, req.Symbol, req.Quantity, req.Price, value)
+		if err != nil {
+			return err
+		}
+		return performance.RecordTransfer(r.Context(), tx, userID, req.Symbol, value)
+	})
 	if err != nil {
+		if errors.Is(err, performance.ErrPositionHeld) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to create holding", http.StatusInternalServerError)
 		return
 	}
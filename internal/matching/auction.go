@@ -0,0 +1,167 @@
+package matching
+
+import "math"
+
+// StartAuction puts symbol's book into a call phase. Orders collect on the
+// book without matching until Uncross is called. Market, IOC and FOK orders
+// cannot wait for the uncross and are refused while the call lasts.
+func (e *Engine) StartAuction(symbol string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.book(symbol).auction = true
+}
+
+// InAuction reports whether symbol's book is in a call phase
+func (e *Engine) InAuction(symbol string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	return ok && b.auction
+}
+
+// Indicative returns the price symbol's book would uncross at right now and
+// the quantity that would trade there, or zeros if nothing would trade. It is
+// only meaningful while the book is in a call phase, since a continuous book
+// is never crossed.
+func (e *Engine) Indicative(symbol string) (float64, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return 0, 0
+	}
+	return b.clearing()
+}
+
+// Uncross ends the call phase of symbol's book. Every order that crosses the
+// clearing price trades at that price, the book goes back to continuous
+// matching and any stops the auction price reaches are triggered. The side
+// that arrived last in each pair is reported as the taker. Result.Order is
+// left empty; every order that changed is in Result.Updated.
+func (e *Engine) Uncross(symbol string) *Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := &Result{}
+	b, ok := e.books[symbol]
+	if !ok || !b.auction {
+		return result
+	}
+
+	price, _ := b.clearing()
+	b.auction = false
+	if price > 0 {
+		e.cross(b, price, result)
+	}
+	e.triggerStops(b, result)
+	return result
+}
+
+// cross trades the best bid against the best ask at price until one of them
+// no longer reaches it. Whole orders take part, including the reserve of
+// icebergs.
+func (e *Engine) cross(b *Book, price float64, result *Result) {
+	for {
+		bid, ask := b.best(Buy), b.best(Sell)
+		if bid == nil || ask == nil || bid.Price < price || ask.Price > price {
+			return
+		}
+
+		newer, older := bid, ask
+		if ask.Seq > bid.Seq {
+			newer, older = ask, bid
+		}
+		if bid.UserID == ask.UserID && newer.SelfTradePrevention.Prevents() {
+			e.preventSelfTrade(b, newer, older, result)
+			continue
+		}
+
+		qty := min(bid.Remaining, ask.Remaining)
+		b.touch(bid)
+		b.touch(ask)
+		bid.fill(qty, price)
+		ask.fill(qty, price)
+		b.lastPrice = price
+
+		result.Trades = append(result.Trades, Trade{
+			Symbol:      b.symbol,
+			Price:       price,
+			Quantity:    qty,
+			BuyOrderID:  bid.ID,
+			SellOrderID: ask.ID,
+			BuyUserID:   bid.UserID,
+			SellUserID:  ask.UserID,
+			TakerSide:   newer.Side,
+			ExecutedAt:  e.now(),
+		})
+
+		for _, o := range []*Order{bid, ask} {
+			switch {
+			case o.IsFilled():
+				b.remove(o)
+			case o.Shown() == 0:
+				b.remove(o)
+				o.replenish()
+				o.Seq = e.nextSeq()
+				b.add(o)
+			}
+			result.Updated = appendUpdated(result.Updated, *o)
+		}
+	}
+}
+
+// clearing finds the price at which the most quantity would trade if the book
+// uncrossed now. Ties go to the price that leaves the least unmatched at it,
+// then to the one nearest the last traded price.
+func (b *Book) clearing() (float64, int) {
+	var price float64
+	var volume, surplus int
+	for _, levels := range [][]*priceLevel{b.bids, b.asks} {
+		for _, lvl := range levels {
+			p := lvl.price
+			demand, supply := b.quantityAt(Buy, p), b.quantityAt(Sell, p)
+			v := min(demand, supply)
+			if v == 0 {
+				continue
+			}
+			s := max(demand-supply, supply-demand)
+			if v > volume || (v == volume && (s < surplus ||
+				(s == surplus && math.Abs(p-b.lastPrice) < math.Abs(price-b.lastPrice)))) {
+				price, volume, surplus = p, v, s
+			}
+		}
+	}
+	return price, volume
+}
+
+// quantityAt returns the total remaining quantity on side willing to trade at price
+func (b *Book) quantityAt(side Side, price float64) int {
+	total := 0
+	for _, lvl := range *b.levels(side) {
+		if !crosses(side, lvl.price, price) {
+			break
+		}
+		for _, o := range lvl.orders {
+			total += o.Remaining
+		}
+	}
+	return total
+}
+
+// callable reports whether o can wait on the book for an uncross
+func callable(o *Order) bool {
+	if o.Type == Market || (o.Type == Stop && o.Triggered) {
+		return false
+	}
+	return o.TimeInForce != ImmediateOrCancel && o.TimeInForce != FillOrKill
+}
+
+// crossed reports whether the best bid reaches the best ask, which only a
+// call phase leaves behind
+func (b *Book) crossed() bool {
+	bid, ask := b.best(Buy), b.best(Sell)
+	return bid != nil && ask != nil && bid.Price >= ask.Price
+}
@@ -0,0 +1,213 @@
+package matching
+
+import "sort"
+
+// priceLevel is a FIFO queue of resting orders at a single price
+type priceLevel struct {
+	price  float64
+	orders []*Order
+}
+
+// Book holds the resting orders for one symbol. Bids are sorted best (highest)
+// first and asks best (lowest) first, so the top of book is always index 0.
+// Stop orders that have not triggered yet are kept aside in arrival order.
+// While auction is set the book is in a call phase and may be crossed. undo
+// records the orders changed since the last checkpoint, if one is kept.
+type Book struct {
+	symbol    string
+	bids      []*priceLevel
+	asks      []*priceLevel
+	orders    map[int64]*Order
+	stops     []*Order
+	lastPrice float64
+	auction   bool
+	undo      *Checkpoint
+}
+
+func newBook(symbol string) *Book {
+	return &Book{
+		symbol: symbol,
+		orders: make(map[int64]*Order),
+	}
+}
+
+// has reports whether an order with this ID is resting or waiting to trigger
+func (b *Book) has(id int64) bool {
+	if _, ok := b.orders[id]; ok {
+		return true
+	}
+	return b.stop(id) != nil
+}
+
+func (b *Book) levels(side Side) *[]*priceLevel {
+	if side == Buy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// better reports whether price a has priority over price b on the given side
+func better(side Side, a, b float64) bool {
+	if side == Buy {
+		return a > b
+	}
+	return a < b
+}
+
+// crosses reports whether an order on side at price can trade against a resting price
+func crosses(side Side, price, resting float64) bool {
+	if side == Buy {
+		return price >= resting
+	}
+	return price <= resting
+}
+
+// add places an order at the back of its price level
+func (b *Book) add(o *Order) {
+	b.touch(o)
+	levels := b.levels(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool {
+		return !better(o.Side, (*levels)[i].price, o.Price)
+	})
+
+	if i < len(*levels) && (*levels)[i].price == o.Price {
+		(*levels)[i].orders = append((*levels)[i].orders, o)
+	} else {
+		*levels = append(*levels, nil)
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = &priceLevel{price: o.Price, orders: []*Order{o}}
+	}
+
+	b.orders[o.ID] = o
+}
+
+// remove takes an order off the book, dropping its price level if it becomes empty
+func (b *Book) remove(o *Order) {
+	b.touch(o)
+	levels := b.levels(o.Side)
+	for i, lvl := range *levels {
+		if lvl.price != o.Price {
+			continue
+		}
+		for j, resting := range lvl.orders {
+			if resting.ID == o.ID {
+				lvl.orders = append(lvl.orders[:j], lvl.orders[j+1:]...)
+				break
+			}
+		}
+		if len(lvl.orders) == 0 {
+			*levels = append((*levels)[:i], (*levels)[i+1:]...)
+		}
+		break
+	}
+
+	delete(b.orders, o.ID)
+}
+
+// best returns the resting order with the highest priority on side, or nil
+func (b *Book) best(side Side) *Order {
+	levels := *b.levels(side)
+	if len(levels) == 0 {
+		return nil
+	}
+	return levels[0].orders[0]
+}
+
+// available returns how much of the opposite side o could trade against right
+// now, stopping early once o's remaining quantity is covered. The hidden
+// reserve of icebergs counts, since it refills as o trades through the level.
+// Orders of o's own user never count when self-trade prevention applies, and
+// unless they are simply cancelled out of the way nothing behind them does
+// either.
+func (b *Book) available(o *Order) int {
+	total := 0
+	for _, lvl := range *b.levels(o.Side.Opposite()) {
+		if !marketable(o, lvl.price) {
+			break
+		}
+		for _, resting := range lvl.orders {
+			if resting.UserID == o.UserID && o.SelfTradePrevention.Prevents() {
+				if o.SelfTradePrevention != CancelOldest {
+					return total
+				}
+				continue
+			}
+			total += resting.Remaining
+		}
+		if total >= o.Remaining {
+			break
+		}
+	}
+	return total
+}
+
+// depth aggregates up to n price levels of one side of the book
+func (b *Book) depth(side Side, n int) []Level {
+	levels := *b.levels(side)
+	if n > len(levels) {
+		n = len(levels)
+	}
+
+	result := make([]Level, 0, n)
+	for _, lvl := range levels[:n] {
+		l := Level{Price: lvl.price, Orders: len(lvl.orders)}
+		for _, o := range lvl.orders {
+			l.Quantity += o.Shown()
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+// stop returns the untriggered stop order with the given ID, or nil
+func (b *Book) stop(id int64) *Order {
+	for _, o := range b.stops {
+		if o.ID == id {
+			return o
+		}
+	}
+	return nil
+}
+
+func (b *Book) addStop(o *Order) {
+	b.touch(o)
+	b.stops = append(b.stops, o)
+}
+
+func (b *Book) removeStop(o *Order) {
+	b.touch(o)
+	for i, s := range b.stops {
+		if s.ID == o.ID {
+			b.stops = append(b.stops[:i], b.stops[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopReached reports whether price has reached the trigger of a stop order.
+// Buy stops trigger when the market trades at or above the stop price and sell
+// stops when it trades at or below it.
+func stopReached(o *Order, price float64) bool {
+	if price <= 0 {
+		return false
+	}
+	if o.Side == Buy {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+// nextTriggered returns the oldest stop order triggered by the last traded price, or nil
+func (b *Book) nextTriggered() *Order {
+	for _, o := range b.stops {
+		if stopReached(o, b.lastPrice) {
+			return o
+		}
+	}
+	return nil
+}
+
+// LastPrice returns the price of the most recent trade on this book
+func (b *Book) LastPrice() float64 {
+	return b.lastPrice
+}
@@ -0,0 +1,154 @@
+package matching
+
+import "sort"
+
+// Checkpoint records what one symbol's book was before a change, so the change
+// can be taken back with Rollback. Only the orders the change touches are
+// copied, the first time they are touched.
+type Checkpoint struct {
+	symbol    string
+	existed   bool
+	lastPrice float64
+	auction   bool
+	saved     map[int64]savedOrder
+}
+
+// savedOrder is an order as it was when a change first touched it
+type savedOrder struct {
+	order Order
+	place place
+}
+
+// place is where an order was kept on its book
+type place int
+
+const (
+	offBook place = iota
+	resting
+	waiting
+)
+
+// Checkpoint starts recording the changes made to symbol's book. It lasts
+// until it is released or rolled back, or until the next checkpoint of the
+// same symbol replaces it, so the book must not be changed by anyone else in
+// the meantime.
+func (e *Engine) Checkpoint(symbol string) *Checkpoint {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cp := &Checkpoint{symbol: symbol, saved: make(map[int64]savedOrder)}
+	if b, ok := e.books[symbol]; ok {
+		cp.existed = true
+		cp.lastPrice = b.lastPrice
+		cp.auction = b.auction
+		b.undo = cp
+	}
+	return cp
+}
+
+// Release stops recording changes for cp and keeps them
+func (e *Engine) Release(cp *Checkpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if b, ok := e.books[cp.symbol]; ok && b.undo == cp {
+		b.undo = nil
+	}
+}
+
+// Rollback puts every order cp recorded back the way it was when cp was
+// taken, at its old place in the queue, together with the book's last price
+// and phase. A book the change created is dropped. It returns
+// ErrStaleCheckpoint if the book has been replaced since.
+func (e *Engine) Rollback(cp *Checkpoint) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[cp.symbol]
+	if !ok {
+		if cp.existed {
+			return ErrStaleCheckpoint
+		}
+		return nil
+	}
+	if !cp.existed {
+		delete(e.books, cp.symbol)
+		return nil
+	}
+	if b.undo != cp {
+		return ErrStaleCheckpoint
+	}
+	b.undo = nil
+
+	for id := range cp.saved {
+		if o, ok := b.orders[id]; ok {
+			b.remove(o)
+		} else if o := b.stop(id); o != nil {
+			b.removeStop(o)
+		}
+	}
+	for _, s := range cp.saved {
+		o := &Order{}
+		*o = s.order
+		switch s.place {
+		case resting:
+			b.insert(o)
+		case waiting:
+			b.insertStop(o)
+		}
+	}
+	b.lastPrice = cp.lastPrice
+	b.auction = cp.auction
+	return nil
+}
+
+// touch records o in the checkpoint kept for b, unless there is none or o is
+// already in it. It must be called before o changes.
+func (b *Book) touch(o *Order) {
+	if b.undo == nil {
+		return
+	}
+	if _, ok := b.undo.saved[o.ID]; ok {
+		return
+	}
+
+	s := savedOrder{order: *o}
+	switch {
+	case b.orders[o.ID] == o:
+		s.place = resting
+	case b.stop(o.ID) == o:
+		s.place = waiting
+	}
+	b.undo.saved[o.ID] = s
+}
+
+// insert puts an order back at its place in the queue of its price level,
+// going by its sequence number
+func (b *Book) insert(o *Order) {
+	levels := b.levels(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool {
+		return !better(o.Side, (*levels)[i].price, o.Price)
+	})
+
+	if i < len(*levels) && (*levels)[i].price == o.Price {
+		lvl := (*levels)[i]
+		j := sort.Search(len(lvl.orders), func(j int) bool { return lvl.orders[j].Seq > o.Seq })
+		lvl.orders = append(lvl.orders, nil)
+		copy(lvl.orders[j+1:], lvl.orders[j:])
+		lvl.orders[j] = o
+	} else {
+		*levels = append(*levels, nil)
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = &priceLevel{price: o.Price, orders: []*Order{o}}
+	}
+
+	b.orders[o.ID] = o
+}
+
+// insertStop puts an untriggered stop order back at its place in arrival order
+func (b *Book) insertStop(o *Order) {
+	i := sort.Search(len(b.stops), func(i int) bool { return b.stops[i].Seq > o.Seq })
+	b.stops = append(b.stops, nil)
+	copy(b.stops[i+1:], b.stops[i:])
+	b.stops[i] = o
+}
@@ -0,0 +1,78 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackUndoesATrade(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 1, Symbol: "MSFT", Side: Sell, Price: 300, Quantity: 5})
+	cp := e.Checkpoint("AAPL")
+
+	e.Submit(Order{ID: 4, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 12})
+	e.Submit(Order{ID: 5, UserID: 2, Symbol: "MSFT", Side: Buy, Price: 300, Quantity: 5})
+	assert.NoError(t, e.Rollback(cp))
+
+	o, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, o.Remaining)
+	o, ok = e.Order("AAPL", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 5, o.Remaining)
+	assert.Equal(t, 0.0, e.LastPrice("AAPL"))
+	// other books keep what happened to them
+	assert.Equal(t, 300.0, e.LastPrice("MSFT"))
+
+	// both orders are back at their old place in the queue
+	res, err := e.Submit(Order{ID: 6, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 11})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, int64(1), res.Trades[0].SellOrderID)
+	assert.Equal(t, int64(2), res.Trades[1].SellOrderID)
+}
+
+func TestRollbackDropsANewBook(t *testing.T) {
+	e := NewEngine()
+	cp := e.Checkpoint("AAPL")
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+
+	assert.NoError(t, e.Rollback(cp))
+	_, ok := e.Order("AAPL", 1)
+	assert.False(t, ok)
+}
+
+func TestReleaseKeepsChanges(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10})
+	cp := e.Checkpoint("AAPL")
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 4})
+	e.Release(cp)
+
+	assert.ErrorIs(t, e.Rollback(cp), ErrStaleCheckpoint)
+	o, _ := e.Order("AAPL", 1)
+	assert.Equal(t, 6, o.Remaining)
+}
+
+func TestRollbackPutsTriggeredStopsBack(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 95, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 98, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 90, Quantity: 5})
+	cp := e.Checkpoint("AAPL")
+
+	res, err := e.Submit(Order{ID: 4, UserID: 3, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 95, Quantity: 2})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.NoError(t, e.Rollback(cp))
+
+	stop, ok := e.Order("AAPL", 2)
+	assert.True(t, ok)
+	assert.False(t, stop.Triggered)
+	assert.Equal(t, 5, stop.Remaining)
+	bid, _ := e.Order("AAPL", 1)
+	assert.Equal(t, 10, bid.Remaining)
+}
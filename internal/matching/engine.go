@@ -0,0 +1,535 @@
+package matching
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Engine is an in-process, price-time priority matching engine. It keeps one
+// book per symbol and is safe for concurrent use.
+type Engine struct {
+	mu    sync.Mutex
+	books map[string]*Book
+	seq   uint64
+	now   func() time.Time
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		books: make(map[string]*Book),
+		now:   time.Now,
+	}
+}
+
+func (e *Engine) book(symbol string) *Book {
+	b, ok := e.books[symbol]
+	if !ok {
+		b = newBook(symbol)
+		e.books[symbol] = b
+	}
+	return b
+}
+
+func (e *Engine) nextSeq() uint64 {
+	e.seq++
+	return e.seq
+}
+
+// Submit processes an incoming order. Limit and market orders are matched
+// against the opposite side of the book straight away; whatever is left of a
+// limit order rests on the book while the rest of a market order is cancelled.
+// Stop orders wait aside until the last traded price reaches their stop price.
+// A stop order submitted with Triggered set is executed straight away, as if
+// its stop price had just been reached. During a call auction orders only
+// rest on the book.
+func (e *Engine) Submit(o Order) (*Result, error) {
+	if o.Type == "" {
+		o.Type = Limit
+	}
+	if o.TimeInForce == "" {
+		o.TimeInForce = GoodTillCancel
+	}
+	if err := validate(&o); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b := e.book(o.Symbol)
+	if b.has(o.ID) {
+		return nil, ErrDuplicateOrder
+	}
+	if o.Type.IsStop() && !o.Triggered && stopReached(&o, b.lastPrice) {
+		return nil, ErrStopTriggered
+	}
+	if b.auction && !callable(&o) {
+		return nil, ErrAuctionOrder
+	}
+
+	incoming := &Order{}
+	*incoming = o
+	incoming.Remaining = o.Quantity
+	incoming.AvgFillPrice = 0
+	incoming.Triggered = o.Type.IsStop() && o.Triggered
+	incoming.Cancelled = false
+	incoming.Expired = false
+	incoming.Visible = 0
+	incoming.Seq = e.nextSeq()
+
+	result := &Result{}
+	if incoming.Type.IsStop() && !incoming.Triggered {
+		b.addStop(incoming)
+	} else {
+		e.execute(b, incoming, result)
+	}
+	e.triggerStops(b, result)
+
+	// the incoming order may itself have been hit by a triggered stop; it is
+	// reported once, through Result.Order
+	result.Updated = removeUpdated(result.Updated, incoming.ID)
+	result.Order = *incoming
+	return result, nil
+}
+
+// execute matches an order that is live on the book and then either rests or
+// cancels what is left of it. Fill-or-kill orders are cancelled without trading
+// unless the book can fill them completely. During a call auction nothing is
+// matched and orders that cannot wait for the uncross are cancelled.
+func (e *Engine) execute(b *Book, o *Order, result *Result) {
+	if b.auction {
+		if !callable(o) {
+			o.Cancelled = true
+			return
+		}
+		o.replenish()
+		b.add(o)
+		return
+	}
+
+	if o.TimeInForce == FillOrKill && b.available(o) < o.Remaining {
+		o.Cancelled = true
+		return
+	}
+
+	e.match(b, o, result)
+
+	if o.Remaining == 0 || o.Cancelled {
+		return
+	}
+	if o.Type == Market || o.Type == Stop || o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill {
+		o.Cancelled = true
+		return
+	}
+	o.replenish()
+	b.add(o)
+}
+
+// triggerStops releases stop orders whose stop price has been reached. Stop
+// orders become market orders and stop-limit orders become limit orders.
+// Trades made by a triggered order move the last price, so this keeps going
+// until no further stop is triggered.
+func (e *Engine) triggerStops(b *Book, result *Result) {
+	for {
+		o := b.nextTriggered()
+		if o == nil {
+			return
+		}
+
+		b.removeStop(o)
+		o.Triggered = true
+		o.Seq = e.nextSeq()
+		e.execute(b, o, result)
+		result.Updated = appendUpdated(result.Updated, *o)
+	}
+}
+
+// match crosses the incoming order against resting orders until it is filled
+// or the best opposite price no longer crosses
+func (e *Engine) match(b *Book, incoming *Order, result *Result) {
+	opposite := incoming.Side.Opposite()
+	for incoming.Remaining > 0 {
+		maker := b.best(opposite)
+		if maker == nil || !marketable(incoming, maker.Price) {
+			return
+		}
+		if maker.UserID == incoming.UserID && incoming.SelfTradePrevention.Prevents() {
+			if !e.preventSelfTrade(b, incoming, maker, result) {
+				return
+			}
+			continue
+		}
+
+		qty := min(incoming.Remaining, maker.Shown())
+		b.touch(maker)
+		incoming.fill(qty, maker.Price)
+		maker.fill(qty, maker.Price)
+		b.lastPrice = maker.Price
+
+		trade := Trade{
+			Symbol:     b.symbol,
+			Price:      maker.Price,
+			Quantity:   qty,
+			TakerSide:  incoming.Side,
+			ExecutedAt: e.now(),
+		}
+		if incoming.Side == Buy {
+			trade.BuyOrderID, trade.BuyUserID = incoming.ID, incoming.UserID
+			trade.SellOrderID, trade.SellUserID = maker.ID, maker.UserID
+		} else {
+			trade.BuyOrderID, trade.BuyUserID = maker.ID, maker.UserID
+			trade.SellOrderID, trade.SellUserID = incoming.ID, incoming.UserID
+		}
+		result.Trades = append(result.Trades, trade)
+
+		switch {
+		case maker.IsFilled():
+			b.remove(maker)
+		case maker.Shown() == 0:
+			// the iceberg's slice is used up; the next one goes to the
+			// back of the queue
+			b.remove(maker)
+			maker.replenish()
+			maker.Seq = e.nextSeq()
+			b.add(maker)
+		}
+		result.Updated = appendUpdated(result.Updated, *maker)
+	}
+}
+
+// marketable reports whether an order is willing to trade at the resting price.
+// Market orders (and triggered stops) take any price.
+func marketable(o *Order, resting float64) bool {
+	if o.Type == Market || o.Type == Stop {
+		return true
+	}
+	return crosses(o.Side, o.Price, resting)
+}
+
+// appendUpdated records the latest state of an order, replacing an earlier
+// snapshot of the same order if there is one
+func appendUpdated(updated []Order, o Order) []Order {
+	for i := range updated {
+		if updated[i].ID == o.ID {
+			updated[i] = o
+			return updated
+		}
+	}
+	return append(updated, o)
+}
+
+func removeUpdated(updated []Order, id int64) []Order {
+	for i := range updated {
+		if updated[i].ID == id {
+			return append(updated[:i], updated[i+1:]...)
+		}
+	}
+	return updated
+}
+
+// Cancel takes a resting or untriggered stop order off the book
+func (e *Engine) Cancel(symbol string, id int64) (Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+
+	if o, ok := b.orders[id]; ok {
+		b.remove(o)
+		o.Cancelled = true
+		return *o, nil
+	}
+	if o := b.stop(id); o != nil {
+		b.removeStop(o)
+		o.Cancelled = true
+		return *o, nil
+	}
+	return Order{}, ErrOrderNotFound
+}
+
+// Amend changes the price and total quantity of a working order. Reducing the
+// quantity at the same price keeps the order's place in the queue; any other
+// change sends it to the back of its (new) price level, where it may trade
+// straight away if the new price crosses the book. The new quantity must be
+// greater than what has already been filled.
+func (e *Engine) Amend(symbol string, id int64, price float64, quantity int) (*Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	result := &Result{}
+	if o := b.stop(id); o != nil {
+		// an untriggered stop is not in the queue yet, so it can change in place
+		if quantity <= o.Filled() {
+			return nil, ErrInvalidQuantity
+		}
+		if err := checkAmendPrice(o, price); err != nil {
+			return nil, err
+		}
+		b.touch(o)
+		o.Remaining = quantity - o.Filled()
+		o.Quantity = quantity
+		o.Price = price
+		result.Order = *o
+		return result, nil
+	}
+
+	o, ok := b.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if quantity <= o.Filled() {
+		return nil, ErrInvalidQuantity
+	}
+	if err := checkAmendPrice(o, price); err != nil {
+		return nil, err
+	}
+
+	if price == o.Price && quantity <= o.Quantity {
+		b.touch(o)
+		o.Remaining = quantity - o.Filled()
+		o.Quantity = quantity
+		o.Visible = min(o.Visible, o.Remaining)
+		result.Order = *o
+		return result, nil
+	}
+
+	b.remove(o)
+	o.Remaining = quantity - o.Filled()
+	o.Quantity = quantity
+	o.Price = price
+	o.Seq = e.nextSeq()
+
+	e.execute(b, o, result)
+	e.triggerStops(b, result)
+
+	result.Updated = removeUpdated(result.Updated, o.ID)
+	result.Order = *o
+	return result, nil
+}
+
+func checkAmendPrice(o *Order, price float64) error {
+	if o.Type == Limit || o.Type == StopLimit {
+		if price <= 0 {
+			return ErrInvalidPrice
+		}
+		return nil
+	}
+	if price != 0 {
+		return ErrInvalidPrice
+	}
+	return nil
+}
+
+// Expire takes every order on symbol's book whose expiry time is at or before
+// now off the book and returns their final state
+func (e *Engine) Expire(symbol string, now time.Time) []Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return nil
+	}
+
+	var expired []*Order
+	for _, o := range b.orders {
+		if expiredAt(o, now) {
+			expired = append(expired, o)
+		}
+	}
+	for _, o := range b.stops {
+		if expiredAt(o, now) {
+			expired = append(expired, o)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Seq < expired[j].Seq })
+
+	result := make([]Order, 0, len(expired))
+	for _, o := range expired {
+		if o.Type.IsStop() && !o.Triggered {
+			b.removeStop(o)
+		} else {
+			b.remove(o)
+		}
+		o.Expired = true
+		result = append(result, *o)
+	}
+	return result
+}
+
+func expiredAt(o *Order, now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(now)
+}
+
+// Symbols returns the symbols that currently have a book
+func (e *Engine) Symbols() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbols := make([]string, 0, len(e.books))
+	for symbol := range e.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Order returns a copy of a resting or untriggered stop order
+func (e *Engine) Order(symbol string, id int64) (Order, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return Order{}, false
+	}
+	if o, ok := b.orders[id]; ok {
+		return *o, true
+	}
+	if o := b.stop(id); o != nil {
+		return *o, true
+	}
+	return Order{}, false
+}
+
+// MarketPrice returns the average price a market order on side for quantity
+// would trade at against symbol's book as it stands, the reserve of icebergs
+// included. What the book cannot fill is valued at the furthest price it
+// reaches. Zero if there is nothing on the other side of the book.
+func (e *Engine) MarketPrice(symbol string, side Side, quantity int) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok || quantity <= 0 {
+		return 0
+	}
+
+	var cost, price float64
+	left := quantity
+	for _, lvl := range *b.levels(side.Opposite()) {
+		price = lvl.price
+		for _, o := range lvl.orders {
+			qty := min(left, o.Remaining)
+			cost += float64(qty) * price
+			left -= qty
+			if left == 0 {
+				return cost / float64(quantity)
+			}
+		}
+	}
+	return (cost + float64(left)*price) / float64(quantity)
+}
+
+// Depth returns up to levels aggregated price levels on each side of symbol's book
+func (e *Engine) Depth(symbol string, levels int) Depth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	d := Depth{Symbol: symbol, Bids: []Level{}, Asks: []Level{}}
+	b, ok := e.books[symbol]
+	if !ok {
+		return d
+	}
+
+	d.Bids = b.depth(Buy, levels)
+	d.Asks = b.depth(Sell, levels)
+	d.LastPrice = b.lastPrice
+	return d
+}
+
+// LastPrice returns the last traded price for symbol, or 0 if it has not traded
+func (e *Engine) LastPrice(symbol string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if b, ok := e.books[symbol]; ok {
+		return b.lastPrice
+	}
+	return 0
+}
+
+func validate(o *Order) error {
+	if o.Side != Buy && o.Side != Sell {
+		return ErrInvalidSide
+	}
+	if strings.TrimSpace(o.Symbol) == "" {
+		return ErrInvalidSymbol
+	}
+	if o.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	if o.DisplayQuantity < 0 || o.DisplayQuantity > o.Quantity {
+		return ErrInvalidDisplay
+	}
+
+	switch o.TimeInForce {
+	case Day, GoodTillCancel, ImmediateOrCancel, FillOrKill, GoodTillDate:
+	default:
+		return ErrInvalidTIF
+	}
+
+	switch o.Type {
+	case Market:
+		if o.Price != 0 {
+			return ErrInvalidPrice
+		}
+		if o.StopPrice != 0 {
+			return ErrInvalidStopPrice
+		}
+	case Limit:
+		if o.Price <= 0 {
+			return ErrInvalidPrice
+		}
+		if o.StopPrice != 0 {
+			return ErrInvalidStopPrice
+		}
+	case Stop:
+		if o.Price != 0 {
+			return ErrInvalidPrice
+		}
+		if o.StopPrice <= 0 {
+			return ErrInvalidStopPrice
+		}
+	case StopLimit:
+		if o.Price <= 0 {
+			return ErrInvalidPrice
+		}
+		if o.StopPrice <= 0 {
+			return ErrInvalidStopPrice
+		}
+	default:
+		return ErrInvalidOrderType
+	}
+
+	switch o.SelfTradePrevention {
+	case "", AllowSelfTrade, CancelNewest, CancelOldest, CancelBoth, Decrement:
+	default:
+		return ErrInvalidSelfTrade
+	}
+
+	if o.IsTrailing() && (!o.Type.IsStop() || o.TrailAmount < 0 || o.TrailPercent < 0 || o.TrailPercent >= 100 || (o.TrailAmount > 0 && o.TrailPercent > 0)) {
+		return ErrInvalidTrail
+	}
+
+	// only orders that can rest on the book can hide part of their size
+	if o.IsIceberg() && (o.Type == Market || o.Type == Stop || o.TimeInForce == ImmediateOrCancel || o.TimeInForce == FillOrKill) {
+		return ErrInvalidDisplay
+	}
+	return nil
+}
@@ -0,0 +1,785 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmitRestsWhenNothingCrosses(t *testing.T) {
+	e := NewEngine()
+
+	res, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Trades)
+	assert.Equal(t, 10, res.Order.Remaining)
+
+	resting, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, resting.Remaining)
+}
+
+func TestSubmitFullFillAtMakerPrice(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10})
+	assert.NoError(t, err)
+
+	res, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 1)
+
+	trade := res.Trades[0]
+	assert.Equal(t, 100.0, trade.Price)
+	assert.Equal(t, 10, trade.Quantity)
+	assert.Equal(t, int64(2), trade.BuyOrderID)
+	assert.Equal(t, int64(1), trade.SellOrderID)
+	assert.Equal(t, Buy, trade.TakerSide)
+
+	assert.True(t, res.Order.IsFilled())
+	assert.Len(t, res.Updated, 1)
+	assert.True(t, res.Updated[0].IsFilled())
+	assert.Equal(t, 100.0, e.LastPrice("AAPL"))
+
+	_, ok := e.Order("AAPL", 1)
+	assert.False(t, ok)
+}
+
+func TestSubmitPricePriority(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 102, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 103, Quantity: 5})
+
+	res, err := e.Submit(Order{ID: 4, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 102, Quantity: 8})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, int64(2), res.Trades[0].SellOrderID)
+	assert.Equal(t, 101.0, res.Trades[0].Price)
+	assert.Equal(t, int64(1), res.Trades[1].SellOrderID)
+	assert.Equal(t, 102.0, res.Trades[1].Price)
+	assert.Equal(t, 3, res.Trades[1].Quantity)
+
+	resting, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 2, resting.Remaining)
+}
+
+func TestSubmitTimePriority(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+
+	res, err := e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 7})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, int64(1), res.Trades[0].BuyOrderID)
+	assert.Equal(t, 5, res.Trades[0].Quantity)
+	assert.Equal(t, int64(2), res.Trades[1].BuyOrderID)
+	assert.Equal(t, 2, res.Trades[1].Quantity)
+}
+
+func TestSubmitPartialFillRests(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 4})
+
+	res, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Order.Filled())
+	assert.Equal(t, 6, res.Order.Remaining)
+
+	resting, ok := e.Order("AAPL", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 6, resting.Remaining)
+}
+
+func TestSubmitBooksAreIsolatedPerSymbol(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+
+	res, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "MSFT", Side: Buy, Price: 100, Quantity: 5})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Trades)
+}
+
+func TestSubmitValidation(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, Symbol: "AAPL", Side: "hold", Price: 100, Quantity: 5})
+	assert.Equal(t, ErrInvalidSide, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 0})
+	assert.Equal(t, ErrInvalidQuantity, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Price: 0, Quantity: 5})
+	assert.Equal(t, ErrInvalidPrice, err)
+
+	e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	assert.Equal(t, ErrDuplicateOrder, err)
+}
+
+func TestMarketOrderCancelsUnfilledRemainder(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 100, Quantity: 3})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 250, Quantity: 3})
+
+	res, err := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Buy, Type: Market, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, 250.0, res.Trades[1].Price)
+	assert.Equal(t, 6, res.Order.Filled())
+	assert.True(t, res.Order.Cancelled)
+
+	_, ok := e.Order("AAPL", 3)
+	assert.False(t, ok)
+}
+
+func TestStopOrderTriggersAsMarket(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 99, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 95, Quantity: 10})
+
+	res, err := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 98, Quantity: 5})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Trades)
+	assert.False(t, res.Order.Triggered)
+
+	// a trade at 99 does not reach the sell stop at 98
+	e.Submit(Order{ID: 4, UserID: 3, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 99, Quantity: 10})
+	_, ok := e.Order("AAPL", 3)
+	assert.True(t, ok)
+
+	// a trade at 95 triggers the stop, which sells into the remaining bid
+	res, err = e.Submit(Order{ID: 5, UserID: 3, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 95, Quantity: 2})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, int64(3), res.Trades[1].SellOrderID)
+	assert.Equal(t, 5, res.Trades[1].Quantity)
+
+	var stop Order
+	for _, o := range res.Updated {
+		if o.ID == 3 {
+			stop = o
+		}
+	}
+	assert.True(t, stop.Triggered)
+	assert.True(t, stop.IsFilled())
+}
+
+func TestStopLimitTriggersAsLimitAndRests(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: StopLimit, StopPrice: 105, Price: 106, Quantity: 5})
+	assert.NoError(t, err)
+
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 105, Quantity: 1})
+	res, err := e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 105, Quantity: 1})
+	assert.NoError(t, err)
+	assert.Len(t, res.Updated, 2)
+
+	resting, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.True(t, resting.Triggered)
+	assert.Equal(t, 5, resting.Remaining)
+}
+
+func TestStopAlreadyReachedIsRejected(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 100, Quantity: 1})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 100, Quantity: 1})
+
+	_, err := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Buy, Type: Stop, StopPrice: 99, Quantity: 1})
+	assert.Equal(t, ErrStopTriggered, err)
+}
+
+func TestSubmitTriggeredStop(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 100, Quantity: 1})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 100, Quantity: 1})
+	e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 99, Quantity: 2})
+
+	res, err := e.Submit(Order{ID: 4, UserID: 2, Symbol: "AAPL", Side: Sell, Type: StopLimit, Price: 98, StopPrice: 101, Quantity: 5, Triggered: true})
+	assert.NoError(t, err)
+	assert.True(t, res.Order.Triggered)
+	assert.Equal(t, 2, res.Order.Filled())
+
+	resting, ok := e.Order("AAPL", 4)
+	assert.True(t, ok)
+	assert.Equal(t, 3, resting.Remaining)
+}
+
+func TestOrderTypeValidation(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Type: Market, Price: 100, Quantity: 1})
+	assert.Equal(t, ErrInvalidPrice, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 100, StopPrice: 90, Quantity: 1})
+	assert.Equal(t, ErrInvalidStopPrice, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Type: Stop, Quantity: 1})
+	assert.Equal(t, ErrInvalidStopPrice, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Type: StopLimit, StopPrice: 100, Quantity: 1})
+	assert.Equal(t, ErrInvalidPrice, err)
+
+	_, err = e.Submit(Order{ID: 1, Symbol: "AAPL", Side: Buy, Type: "iceberg", Price: 100, Quantity: 1})
+	assert.Equal(t, ErrInvalidOrderType, err)
+}
+
+func TestImmediateOrCancelCancelsRemainder(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 4})
+
+	res, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10, TimeInForce: ImmediateOrCancel})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Order.Filled())
+	assert.True(t, res.Order.Cancelled)
+
+	_, ok := e.Order("AAPL", 2)
+	assert.False(t, ok)
+}
+
+func TestFillOrKill(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 4})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 102, Quantity: 4})
+
+	// only 4 are available at or below 101, so nothing trades
+	res, err := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 6, TimeInForce: FillOrKill})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Trades)
+	assert.True(t, res.Order.Cancelled)
+
+	res, err = e.Submit(Order{ID: 4, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 102, Quantity: 6, TimeInForce: FillOrKill})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.True(t, res.Order.IsFilled())
+}
+
+func TestExpire(t *testing.T) {
+	e := NewEngine()
+	now := time.Date(2024, 2, 20, 21, 0, 0, 0, time.UTC)
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 1, TimeInForce: Day, ExpiresAt: now})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 1, TimeInForce: GoodTillDate, ExpiresAt: now.Add(time.Hour)})
+	e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 1, TimeInForce: GoodTillCancel})
+	e.Submit(Order{ID: 4, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 90, Quantity: 1, TimeInForce: Day, ExpiresAt: now})
+
+	expired := e.Expire("AAPL", now)
+	assert.Len(t, expired, 2)
+	assert.Equal(t, int64(1), expired[0].ID)
+	assert.Equal(t, int64(4), expired[1].ID)
+	assert.True(t, expired[0].Expired)
+
+	_, ok := e.Order("AAPL", 1)
+	assert.False(t, ok)
+	_, ok = e.Order("AAPL", 4)
+	assert.False(t, ok)
+	_, ok = e.Order("AAPL", 2)
+	assert.True(t, ok)
+	_, ok = e.Order("AAPL", 3)
+	assert.True(t, ok)
+}
+
+func TestCancel(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 90, Quantity: 5})
+
+	o, err := e.Cancel("AAPL", 1)
+	assert.NoError(t, err)
+	assert.True(t, o.Cancelled)
+
+	_, err = e.Cancel("AAPL", 2)
+	assert.NoError(t, err)
+
+	_, err = e.Cancel("AAPL", 1)
+	assert.Equal(t, ErrOrderNotFound, err)
+
+	res, _ := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+	assert.Empty(t, res.Trades)
+}
+
+func TestAmendQuantityDownKeepsPriority(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+
+	res, err := e.Amend("AAPL", 1, 100, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, res.Order.Remaining)
+
+	res, _ = e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 6})
+	assert.Len(t, res.Trades, 1)
+	assert.Equal(t, int64(1), res.Trades[0].BuyOrderID)
+}
+
+func TestAmendQuantityUpLosesPriority(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+
+	_, err := e.Amend("AAPL", 1, 100, 8)
+	assert.NoError(t, err)
+
+	res, _ := e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+	assert.Len(t, res.Trades, 1)
+	assert.Equal(t, int64(2), res.Trades[0].BuyOrderID)
+}
+
+func TestAmendPriceCanCross(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+
+	res, err := e.Amend("AAPL", 2, 101, 5)
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 1)
+	assert.True(t, res.Order.IsFilled())
+	assert.Len(t, res.Updated, 1)
+	assert.Equal(t, int64(1), res.Updated[0].ID)
+}
+
+func TestAmendBelowFilledQuantity(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 4})
+
+	_, err := e.Amend("AAPL", 1, 100, 4)
+	assert.Equal(t, ErrInvalidQuantity, err)
+
+	res, err := e.Amend("AAPL", 1, 100, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Order.Remaining)
+}
+
+func TestAvgFillPrice(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 2})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 103, Quantity: 1})
+
+	res, err := e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 105, Quantity: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Order.Filled())
+	assert.InDelta(t, 101.0, res.Order.AvgFillPrice, 1e-9)
+	assert.Equal(t, 100.0, res.Updated[0].AvgFillPrice)
+}
+
+func TestDepthAggregatesLevels(t *testing.T) {
+	e := NewEngine()
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 3})
+	e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 99, Quantity: 4})
+	e.Submit(Order{ID: 4, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 102, Quantity: 6})
+	e.Submit(Order{ID: 5, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 2})
+	e.Submit(Order{ID: 6, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 103, Quantity: 1, Type: Stop, StopPrice: 90})
+
+	d := e.Depth("AAPL", 10)
+	assert.Equal(t, []Level{{Price: 100, Quantity: 8, Orders: 2}, {Price: 99, Quantity: 4, Orders: 1}}, d.Bids)
+	assert.Equal(t, []Level{{Price: 101, Quantity: 2, Orders: 1}, {Price: 102, Quantity: 6, Orders: 1}}, d.Asks)
+
+	d = e.Depth("AAPL", 1)
+	assert.Len(t, d.Bids, 1)
+	assert.Len(t, d.Asks, 1)
+
+	d = e.Depth("MSFT", 10)
+	assert.Empty(t, d.Bids)
+	assert.Empty(t, d.Asks)
+}
+
+func TestMarketPriceWalksTheBook(t *testing.T) {
+	e := NewEngine()
+	assert.Equal(t, 0.0, e.MarketPrice("AAPL", Buy, 10))
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10, DisplayQuantity: 2})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 110, Quantity: 10})
+
+	// the iceberg's reserve counts, then the next level
+	assert.Equal(t, 100.0, e.MarketPrice("AAPL", Buy, 10))
+	assert.Equal(t, 105.0, e.MarketPrice("AAPL", Buy, 20))
+	// beyond the book, the rest is valued at the furthest price
+	assert.Equal(t, 107.5, e.MarketPrice("AAPL", Buy, 40))
+	assert.Equal(t, 0.0, e.MarketPrice("AAPL", Sell, 10))
+}
+
+func TestIcebergShowsOnlyItsSlice(t *testing.T) {
+	e := NewEngine()
+
+	res, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10, DisplayQuantity: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Order.Visible)
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 2})
+
+	d := e.Depth("AAPL", 5)
+	assert.Equal(t, []Level{{Price: 100, Quantity: 5, Orders: 2}}, d.Asks)
+}
+
+func TestIcebergReplenishesAtBackOfQueue(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10, DisplayQuantity: 3})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 2})
+
+	// the first slice trades, then order 2 is ahead of the refilled iceberg
+	res, err := e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 4})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 2)
+	assert.Equal(t, int64(1), res.Trades[0].SellOrderID)
+	assert.Equal(t, 3, res.Trades[0].Quantity)
+	assert.Equal(t, int64(2), res.Trades[1].SellOrderID)
+	assert.Equal(t, 1, res.Trades[1].Quantity)
+
+	iceberg, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 7, iceberg.Remaining)
+	assert.Equal(t, 3, iceberg.Visible)
+	assert.Greater(t, iceberg.Seq, res.Order.Seq)
+	assert.Equal(t, []Level{{Price: 100, Quantity: 4, Orders: 2}}, e.Depth("AAPL", 5).Asks)
+
+	// a large order trades through the reserve slice by slice
+	res, err = e.Submit(Order{ID: 4, UserID: 3, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 8})
+	assert.NoError(t, err)
+	assert.True(t, res.Order.IsFilled())
+	var sizes []int
+	for _, tr := range res.Trades {
+		sizes = append(sizes, tr.Quantity)
+	}
+	assert.Equal(t, []int{1, 3, 3, 1}, sizes)
+	assert.Empty(t, e.Depth("AAPL", 5).Asks)
+}
+
+func TestIcebergFillOrKillCountsReserve(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 10, DisplayQuantity: 2})
+
+	res, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 9, TimeInForce: FillOrKill})
+	assert.NoError(t, err)
+	assert.True(t, res.Order.IsFilled())
+}
+
+func TestIcebergValidation(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5, DisplayQuantity: 6})
+	assert.ErrorIs(t, err, ErrInvalidDisplay)
+	_, err = e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Market, Quantity: 5, DisplayQuantity: 1})
+	assert.ErrorIs(t, err, ErrInvalidDisplay)
+	_, err = e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5, DisplayQuantity: 1, TimeInForce: ImmediateOrCancel})
+	assert.ErrorIs(t, err, ErrInvalidDisplay)
+}
+
+func TestTrailingStopFollowsMarket(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 96, Quantity: 10})
+	_, err := e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 95, TrailAmount: 5, Quantity: 4})
+	assert.NoError(t, err)
+
+	res := e.Mark("AAPL", 103)
+	assert.Len(t, res.Updated, 1)
+	assert.Equal(t, 98.0, res.Updated[0].StopPrice)
+
+	// the stop never moves against the holder
+	res = e.Mark("AAPL", 101)
+	assert.Empty(t, res.Updated)
+	o, _ := e.Order("AAPL", 2)
+	assert.Equal(t, 98.0, o.StopPrice)
+
+	res = e.Mark("AAPL", 97.5)
+	assert.Len(t, res.Trades, 1)
+	assert.Equal(t, int64(2), res.Trades[0].SellOrderID)
+	assert.Equal(t, 96.0, res.Trades[0].Price)
+	_, ok := e.Order("AAPL", 2)
+	assert.False(t, ok)
+}
+
+func TestTrailingStopLimitKeepsGap(t *testing.T) {
+	e := NewEngine()
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: StopLimit, StopPrice: 110, Price: 111, TrailPercent: 10, Quantity: 4})
+	assert.NoError(t, err)
+
+	res := e.Mark("AAPL", 90)
+	assert.Len(t, res.Updated, 1)
+	assert.Equal(t, 99.0, res.Updated[0].StopPrice)
+	assert.Equal(t, 100.0, res.Updated[0].Price)
+
+	res = e.Mark("AAPL", 99)
+	assert.True(t, res.Updated[0].Triggered)
+	o, ok := e.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, []Level{{Price: 100, Quantity: 4, Orders: 1}}, e.Depth("AAPL", 5).Bids)
+	assert.Equal(t, 100.0, o.Price)
+}
+
+func TestTrailingStopValidation(t *testing.T) {
+	e := NewEngine()
+
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, TrailAmount: 5, Quantity: 4})
+	assert.ErrorIs(t, err, ErrInvalidTrail)
+	_, err = e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 95, TrailAmount: 5, TrailPercent: 5, Quantity: 4})
+	assert.ErrorIs(t, err, ErrInvalidTrail)
+	_, err = e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 95, TrailPercent: 100, Quantity: 4})
+	assert.ErrorIs(t, err, ErrInvalidTrail)
+}
+
+func TestSelfTradeAllowedByDefault(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+
+	res, err := e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 1)
+	assert.Empty(t, res.SelfTrades)
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	// user 1 rests 5 at 100 ahead of user 2's 5 at 101; user 1 then buys 8 at 101
+	setup := func() *Engine {
+		e := NewEngine()
+		e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+		e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 5})
+		return e
+	}
+	buy := func(mode SelfTradePrevention, qty int) Order {
+		return Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: qty, SelfTradePrevention: mode}
+	}
+
+	t.Run("cancel newest", func(t *testing.T) {
+		e := setup()
+		res, err := e.Submit(buy(CancelNewest, 8))
+		assert.NoError(t, err)
+		assert.Empty(t, res.Trades)
+		assert.True(t, res.Order.Cancelled)
+		assert.Equal(t, []SelfTrade{{OrderID: 3, AgainstID: 1, Mode: CancelNewest}}, res.SelfTrades)
+		_, ok := e.Order("AAPL", 1)
+		assert.True(t, ok)
+	})
+
+	t.Run("cancel oldest", func(t *testing.T) {
+		e := setup()
+		res, err := e.Submit(buy(CancelOldest, 8))
+		assert.NoError(t, err)
+		assert.Len(t, res.Trades, 1)
+		assert.Equal(t, int64(2), res.Trades[0].SellOrderID)
+		assert.Equal(t, 5, res.Order.Filled())
+		assert.Equal(t, []SelfTrade{{OrderID: 1, AgainstID: 3, Mode: CancelOldest}}, res.SelfTrades)
+		assert.True(t, res.Updated[0].Cancelled)
+		// the rest of the buy order rests
+		_, ok := e.Order("AAPL", 3)
+		assert.True(t, ok)
+	})
+
+	t.Run("cancel both", func(t *testing.T) {
+		e := setup()
+		res, err := e.Submit(buy(CancelBoth, 8))
+		assert.NoError(t, err)
+		assert.Empty(t, res.Trades)
+		assert.True(t, res.Order.Cancelled)
+		assert.Len(t, res.SelfTrades, 2)
+		_, ok := e.Order("AAPL", 1)
+		assert.False(t, ok)
+	})
+
+	t.Run("decrement", func(t *testing.T) {
+		e := setup()
+		res, err := e.Submit(buy(Decrement, 8))
+		assert.NoError(t, err)
+		// the resting order is used up and cancelled, the buy order carries on with 3
+		assert.Equal(t, []SelfTrade{
+			{OrderID: 1, AgainstID: 3, Mode: Decrement},
+			{OrderID: 3, AgainstID: 1, Mode: Decrement, Quantity: 3},
+		}, res.SelfTrades)
+		assert.Len(t, res.Trades, 1)
+		assert.Equal(t, 3, res.Trades[0].Quantity)
+		assert.True(t, res.Order.IsFilled())
+		assert.Equal(t, 3, res.Order.Quantity)
+
+		e = setup()
+		res, err = e.Submit(buy(Decrement, 2))
+		assert.NoError(t, err)
+		assert.Empty(t, res.Trades)
+		assert.True(t, res.Order.Cancelled)
+		resting, _ := e.Order("AAPL", 1)
+		assert.Equal(t, 3, resting.Quantity)
+		assert.Equal(t, 3, resting.Remaining)
+		assert.Equal(t, 0, resting.Filled())
+	})
+
+	t.Run("fill or kill", func(t *testing.T) {
+		e := setup()
+		o := buy(CancelNewest, 5)
+		o.TimeInForce = FillOrKill
+		res, err := e.Submit(o)
+		assert.NoError(t, err)
+		assert.Empty(t, res.Trades)
+		assert.Empty(t, res.SelfTrades)
+		assert.True(t, res.Order.Cancelled)
+
+		o = buy(CancelOldest, 5)
+		o.TimeInForce = FillOrKill
+		res, err = e.Submit(o)
+		assert.NoError(t, err)
+		assert.True(t, res.Order.IsFilled())
+	})
+}
+
+func TestSnapshotRestoresPriority(t *testing.T) {
+	e := NewEngine()
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 4})
+	e.Submit(Order{ID: 4, UserID: 1, Symbol: "AAPL", Side: Sell, Type: Stop, StopPrice: 90, Quantity: 1})
+	// reprioritising order 1 puts it behind order 2
+	e.Amend("AAPL", 1, 100, 12)
+
+	books := e.Snapshot()
+	assert.Len(t, books, 1)
+	assert.Equal(t, 100.0, books[0].LastPrice)
+	assert.Len(t, books[0].Stops, 1)
+
+	restored := NewEngine()
+	assert.NoError(t, restored.Restore(books))
+	assert.Equal(t, e.Depth("AAPL", 5), restored.Depth("AAPL", 5))
+
+	o, ok := restored.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 8, o.Remaining)
+	assert.Equal(t, 100.0, o.AvgFillPrice)
+
+	res, err := restored.Submit(Order{ID: 5, UserID: 4, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), res.Trades[0].BuyOrderID)
+
+	// new orders queue behind everything that was restored
+	res, err = restored.Submit(Order{ID: 6, UserID: 4, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 1})
+	assert.NoError(t, err)
+	assert.Greater(t, res.Order.Seq, o.Seq)
+}
+
+func TestRestoreRejectsInvalidOrders(t *testing.T) {
+	e := NewEngine()
+	err := e.Restore([]BookState{{Symbol: "AAPL", Orders: []Order{
+		{ID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, TimeInForce: GoodTillCancel, Price: 100, Quantity: 10, Remaining: 0},
+	}}})
+	assert.ErrorIs(t, err, ErrInvalidQuantity)
+
+	err = e.Restore([]BookState{{Symbol: "AAPL", Orders: []Order{
+		{ID: 1, Symbol: "AAPL", Side: Buy, Type: Market, TimeInForce: Day, Quantity: 10, Remaining: 10},
+	}}})
+	assert.ErrorIs(t, err, ErrInvalidOrderType)
+}
+
+func TestAuctionCollectsOrdersWithoutMatching(t *testing.T) {
+	e := NewEngine()
+	e.StartAuction("AAPL")
+	assert.True(t, e.InAuction("AAPL"))
+
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 102, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 8})
+	res, err := e.Submit(Order{ID: 4, UserID: 4, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Trades)
+
+	depth := e.Depth("AAPL", 5)
+	assert.Len(t, depth.Bids, 2)
+	assert.Len(t, depth.Asks, 2)
+
+	// 15 trade at 101 against 10 at 102 and 8 at 100
+	price, volume := e.Indicative("AAPL")
+	assert.Equal(t, 101.0, price)
+	assert.Equal(t, 15, volume)
+}
+
+func TestAuctionUncross(t *testing.T) {
+	e := NewEngine()
+	e.StartAuction("AAPL")
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 102, Quantity: 10})
+	e.Submit(Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 5})
+	e.Submit(Order{ID: 3, UserID: 3, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 8})
+	e.Submit(Order{ID: 4, UserID: 4, Symbol: "AAPL", Side: Sell, Price: 101, Quantity: 10, DisplayQuantity: 2})
+	e.Submit(Order{ID: 5, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 105, StopPrice: 101.5, Type: StopLimit, Quantity: 1})
+
+	res := e.Uncross("AAPL")
+	assert.False(t, e.InAuction("AAPL"))
+	assert.Len(t, res.Trades, 3)
+	for _, trade := range res.Trades {
+		assert.Equal(t, 101.0, trade.Price)
+	}
+	assert.Equal(t, []int{8, 2, 5}, []int{res.Trades[0].Quantity, res.Trades[1].Quantity, res.Trades[2].Quantity})
+	assert.Equal(t, Sell, res.Trades[0].TakerSide)
+	assert.Equal(t, 101.0, e.LastPrice("AAPL"))
+
+	// the iceberg traded through its reserve and shows a fresh slice
+	depth := e.Depth("AAPL", 5)
+	assert.Empty(t, depth.Bids)
+	assert.Equal(t, []Level{{Price: 101, Quantity: 2, Orders: 1}, {Price: 105, Quantity: 1, Orders: 1}}, depth.Asks)
+	resting, _ := e.Order("AAPL", 4)
+	assert.Equal(t, 3, resting.Remaining)
+
+	// the stop was reached by the auction price and rests as a limit order
+	stop, _ := e.Order("AAPL", 5)
+	assert.True(t, stop.Triggered)
+
+	// back to continuous matching
+	res, err := e.Submit(Order{ID: 6, UserID: 2, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 1})
+	assert.NoError(t, err)
+	assert.Len(t, res.Trades, 1)
+}
+
+func TestAuctionRefusesOrdersThatCannotWait(t *testing.T) {
+	e := NewEngine()
+	e.StartAuction("AAPL")
+
+	_, err := e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Market, Quantity: 10})
+	assert.ErrorIs(t, err, ErrAuctionOrder)
+	_, err = e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10, TimeInForce: ImmediateOrCancel})
+	assert.ErrorIs(t, err, ErrAuctionOrder)
+	_, err = e.Submit(Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 100, Quantity: 10, TimeInForce: FillOrKill})
+	assert.ErrorIs(t, err, ErrAuctionOrder)
+	_, err = e.Submit(Order{ID: 4, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Stop, StopPrice: 100, Quantity: 10})
+	assert.NoError(t, err)
+}
+
+func TestAuctionSelfTradePrevention(t *testing.T) {
+	e := NewEngine()
+	e.StartAuction("AAPL")
+	e.Submit(Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Price: 101, Quantity: 10, SelfTradePrevention: CancelNewest})
+	e.Submit(Order{ID: 2, UserID: 1, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5, SelfTradePrevention: CancelNewest})
+	e.Submit(Order{ID: 3, UserID: 2, Symbol: "AAPL", Side: Sell, Price: 100, Quantity: 5})
+
+	res := e.Uncross("AAPL")
+	assert.Equal(t, []SelfTrade{{OrderID: 2, AgainstID: 1, Mode: CancelNewest}}, res.SelfTrades)
+	assert.Len(t, res.Trades, 1)
+	assert.Equal(t, int64(3), res.Trades[0].SellOrderID)
+	assert.Equal(t, 5, res.Trades[0].Quantity)
+}
+
+func TestRestoreCrossedBookIsInAuction(t *testing.T) {
+	e := NewEngine()
+	err := e.Restore([]BookState{{Symbol: "AAPL", Orders: []Order{
+		{ID: 1, UserID: 1, Symbol: "AAPL", Side: Buy, Type: Limit, Price: 101, Quantity: 5, Remaining: 5, TimeInForce: GoodTillCancel, Seq: 1},
+		{ID: 2, UserID: 2, Symbol: "AAPL", Side: Sell, Type: Limit, Price: 100, Quantity: 5, Remaining: 5, TimeInForce: GoodTillCancel, Seq: 2},
+	}}})
+	assert.NoError(t, err)
+	assert.True(t, e.InAuction("AAPL"))
+	assert.True(t, e.Snapshot()[0].Auction)
+}
@@ -0,0 +1,238 @@
+package matching
+
+import (
+	"errors"
+	"time"
+)
+
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+// Opposite returns the side an order of this side trades against
+func (s Side) Opposite() Side {
+	if s == Buy {
+		return Sell
+	}
+	return Buy
+}
+
+type OrderType string
+
+const (
+	Market    OrderType = "market"
+	Limit     OrderType = "limit"
+	Stop      OrderType = "stop"
+	StopLimit OrderType = "stop_limit"
+)
+
+// IsStop reports whether orders of this type wait for a trigger price before entering the book
+func (t OrderType) IsStop() bool {
+	return t == Stop || t == StopLimit
+}
+
+// SelfTradePrevention decides what happens when an order would trade against
+// a resting order of the same user. The incoming order's mode applies.
+type SelfTradePrevention string
+
+const (
+	// AllowSelfTrade lets orders of the same user trade with each other
+	AllowSelfTrade SelfTradePrevention = "none"
+	// CancelNewest cancels what is left of the incoming order
+	CancelNewest SelfTradePrevention = "cancel_newest"
+	// CancelOldest cancels the resting order and carries on matching
+	CancelOldest SelfTradePrevention = "cancel_oldest"
+	// CancelBoth cancels the resting order and what is left of the incoming one
+	CancelBoth SelfTradePrevention = "cancel_both"
+	// Decrement takes the smaller of the two quantities off both orders
+	// without trading, cancelling the order that has nothing left
+	Decrement SelfTradePrevention = "decrement"
+)
+
+// Prevents reports whether the mode stops orders of the same user trading
+func (m SelfTradePrevention) Prevents() bool {
+	return m != "" && m != AllowSelfTrade
+}
+
+type TimeInForce string
+
+const (
+	// Day orders are good until the end of the trading day
+	Day TimeInForce = "DAY"
+	// GoodTillCancel orders rest until they are filled or cancelled
+	GoodTillCancel TimeInForce = "GTC"
+	// ImmediateOrCancel orders fill what they can on arrival and cancel the rest
+	ImmediateOrCancel TimeInForce = "IOC"
+	// FillOrKill orders either fill completely on arrival or not at all
+	FillOrKill TimeInForce = "FOK"
+	// GoodTillDate orders rest until their expiry time
+	GoodTillDate TimeInForce = "GTD"
+)
+
+// Order is the engine's view of an order. Quantity is the original size and
+// Remaining is what is still open on the book.
+type Order struct {
+	ID        int64
+	UserID    int64
+	Symbol    string
+	Side      Side
+	Type      OrderType
+	Price     float64
+	StopPrice float64
+	Quantity  int
+	Remaining int
+	// AvgFillPrice is the volume weighted price of everything filled so far
+	AvgFillPrice float64
+	// DisplayQuantity makes the order an iceberg: only a slice of this size
+	// is shown on the book and the rest is held in reserve. Zero shows the
+	// whole order.
+	DisplayQuantity int
+	// Visible is what is left of an iceberg's current slice while it rests
+	Visible int
+	// TrailAmount or TrailPercent make a stop order trail the market: its stop
+	// price follows the market price at this distance, but only ever moves in
+	// the holder's favour. At most one of them is set.
+	TrailAmount  float64
+	TrailPercent float64
+
+	// SelfTradePrevention applies when the order meets a resting order of
+	// the same user; empty allows self-trades
+	SelfTradePrevention SelfTradePrevention
+
+	TimeInForce TimeInForce
+	// ExpiresAt is when a DAY or GTD order is taken off the book; zero means never
+	ExpiresAt time.Time
+
+	// Triggered is set once a stop order's trigger price has been reached
+	Triggered bool
+	// Cancelled is set when the engine dropped the unfilled remainder, e.g. a
+	// market order that ran out of liquidity
+	Cancelled bool
+	// Expired is set when the order was taken off the book because it reached ExpiresAt
+	Expired bool
+
+	// Seq orders resting orders at the same price level (time priority). It
+	// is assigned by the engine whenever the order joins the back of a queue.
+	Seq uint64
+}
+
+// Filled returns the quantity of the order that has been executed
+func (o *Order) Filled() int {
+	return o.Quantity - o.Remaining
+}
+
+// fill records an execution of qty at price against the order
+func (o *Order) fill(qty int, price float64) {
+	filled := o.Filled()
+	o.AvgFillPrice = (o.AvgFillPrice*float64(filled) + price*float64(qty)) / float64(filled+qty)
+	o.Remaining -= qty
+	o.Visible = max(o.Visible-qty, 0)
+}
+
+// IsIceberg reports whether only part of the order is shown on the book
+func (o *Order) IsIceberg() bool {
+	return o.DisplayQuantity > 0
+}
+
+// Shown returns the quantity of a resting order that others can see and trade against
+func (o *Order) Shown() int {
+	if o.IsIceberg() {
+		return o.Visible
+	}
+	return o.Remaining
+}
+
+// IsTrailing reports whether the order's stop price follows the market
+func (o *Order) IsTrailing() bool {
+	return o.TrailAmount > 0 || o.TrailPercent > 0
+}
+
+// replenish shows the next slice of an iceberg from its reserve
+func (o *Order) replenish() {
+	if o.IsIceberg() {
+		o.Visible = min(o.DisplayQuantity, o.Remaining)
+	}
+}
+
+// IsFilled reports whether nothing is left open on the order
+func (o *Order) IsFilled() bool {
+	return o.Remaining == 0
+}
+
+// IsActive reports whether the order is still working, either resting on the
+// book or waiting for its stop to trigger
+func (o *Order) IsActive() bool {
+	return o.Remaining > 0 && !o.Cancelled && !o.Expired
+}
+
+// Trade is a single execution between an incoming (taker) order and a resting (maker) order
+type Trade struct {
+	Symbol      string
+	Price       float64
+	Quantity    int
+	BuyOrderID  int64
+	SellOrderID int64
+	BuyUserID   int64
+	SellUserID  int64
+	TakerSide   Side
+	ExecutedAt  time.Time
+}
+
+// SelfTrade records an order that self-trade prevention cancelled or
+// decremented instead of letting it trade against another order of its user
+type SelfTrade struct {
+	OrderID   int64
+	AgainstID int64
+	Mode      SelfTradePrevention
+	// Quantity is the order's new total quantity after a decrement, zero if
+	// the order was cancelled
+	Quantity int
+}
+
+// Result describes everything that changed while processing a single request.
+// Order is the state of the incoming order after matching and Updated holds the
+// state of every other order that changed as a consequence, such as resting
+// orders that traded against it or stop orders that were triggered.
+// SelfTrades lists the orders self-trade prevention changed along the way.
+type Result struct {
+	Order      Order
+	Updated    []Order
+	Trades     []Trade
+	SelfTrades []SelfTrade
+}
+
+// Level is the aggregated size resting at one price
+type Level struct {
+	Price    float64
+	Quantity int
+	Orders   int
+}
+
+// Depth is an aggregated (L2) view of a book, best prices first
+type Depth struct {
+	Symbol    string
+	Bids      []Level
+	Asks      []Level
+	LastPrice float64
+}
+
+var (
+	ErrInvalidSide      = errors.New("invalid side")
+	ErrInvalidOrderType = errors.New("invalid order type")
+	ErrInvalidPrice     = errors.New("invalid price")
+	ErrInvalidStopPrice = errors.New("invalid stop price")
+	ErrStopTriggered    = errors.New("stop price has already been reached")
+	ErrAuctionOrder     = errors.New("order cannot wait for a call auction to uncross")
+	ErrInvalidTIF       = errors.New("invalid time in force")
+	ErrInvalidQuantity  = errors.New("invalid quantity")
+	ErrInvalidDisplay   = errors.New("invalid display quantity")
+	ErrInvalidTrail     = errors.New("invalid trailing stop")
+	ErrInvalidSelfTrade = errors.New("invalid self-trade prevention mode")
+	ErrInvalidSymbol    = errors.New("invalid symbol")
+	ErrDuplicateOrder   = errors.New("order already exists")
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrStaleCheckpoint  = errors.New("book has been replaced since the checkpoint")
+)
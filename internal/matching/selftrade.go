@@ -0,0 +1,48 @@
+package matching
+
+// preventSelfTrade applies the self-trade prevention mode of newer when it
+// meets older, an order of the same user that was on the book first, and
+// reports whether newer can carry on matching. Either order may be taken off
+// the book; both are added to result.Updated.
+func (e *Engine) preventSelfTrade(b *Book, newer, older *Order, result *Result) bool {
+	mode := newer.SelfTradePrevention
+	changed := func(o, against *Order, quantity int) {
+		result.SelfTrades = append(result.SelfTrades, SelfTrade{OrderID: o.ID, AgainstID: against.ID, Mode: mode, Quantity: quantity})
+		result.Updated = appendUpdated(result.Updated, *o)
+	}
+	cancel := func(o, against *Order) {
+		if b.orders[o.ID] == o {
+			b.remove(o)
+		}
+		o.Cancelled = true
+		changed(o, against, 0)
+	}
+
+	switch mode {
+	case CancelNewest:
+		cancel(newer, older)
+	case CancelOldest:
+		cancel(older, newer)
+	case CancelBoth:
+		cancel(older, newer)
+		cancel(newer, older)
+	default: // Decrement
+		qty := min(newer.Remaining, older.Remaining)
+		for _, pair := range [][2]*Order{{older, newer}, {newer, older}} {
+			o, against := pair[0], pair[1]
+			if o.Remaining == qty {
+				cancel(o, against)
+				continue
+			}
+			b.touch(o)
+			o.Quantity -= qty
+			o.Remaining -= qty
+			o.Visible = max(o.Visible-qty, 0)
+			if o.Shown() == 0 {
+				o.replenish()
+			}
+			changed(o, against, o.Quantity)
+		}
+	}
+	return !newer.Cancelled
+}
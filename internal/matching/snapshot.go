@@ -0,0 +1,131 @@
+package matching
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BookState is a copy of one symbol's book that can be handed back to Restore
+type BookState struct {
+	Symbol    string
+	LastPrice float64
+	// Auction is set while the book is in a call phase
+	Auction bool
+	// Orders are the resting orders, best price first and in queue order
+	// within a price
+	Orders []Order
+	// Stops are the untriggered stop orders in arrival order
+	Stops []Order
+}
+
+// Snapshot returns a copy of every book
+func (e *Engine) Snapshot() []BookState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	books := make([]BookState, 0, len(e.books))
+	for _, b := range e.books {
+		books = append(books, b.state())
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].Symbol < books[j].Symbol })
+	return books
+}
+
+// BookSnapshot returns a copy of the book of symbol
+func (e *Engine) BookSnapshot(symbol string) BookState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.books[symbol]
+	if !ok {
+		return BookState{Symbol: symbol}
+	}
+	return b.state()
+}
+
+func (b *Book) state() BookState {
+	state := BookState{Symbol: b.symbol, LastPrice: b.lastPrice, Auction: b.auction}
+	for _, levels := range [][]*priceLevel{b.bids, b.asks} {
+		for _, lvl := range levels {
+			for _, o := range lvl.orders {
+				state.Orders = append(state.Orders, *o)
+			}
+		}
+	}
+	for _, o := range b.stops {
+		state.Stops = append(state.Stops, *o)
+	}
+	return state
+}
+
+// Restore replaces every book with the given states. Orders keep their
+// remaining quantity, average fill price, visible slice and sequence number,
+// and are queued by sequence number within their price level; nothing is
+// matched. A book that comes back crossed is put in a call phase, since only
+// an auction leaves a book like that. The engine carries on numbering after
+// the highest sequence number restored.
+func (e *Engine) Restore(books []BookState) error {
+	restored := make(map[string]*Book, len(books))
+	var seq uint64
+	for _, state := range books {
+		b := newBook(state.Symbol)
+		b.lastPrice = state.LastPrice
+
+		orders := make([]*Order, 0, len(state.Orders)+len(state.Stops))
+		for i := range state.Orders {
+			orders = append(orders, &state.Orders[i])
+		}
+		for i := range state.Stops {
+			orders = append(orders, &state.Stops[i])
+		}
+		sort.SliceStable(orders, func(i, j int) bool { return orders[i].Seq < orders[j].Seq })
+
+		for _, src := range orders {
+			o := &Order{}
+			*o = *src
+			if err := restorable(b, o); err != nil {
+				return fmt.Errorf("restoring order %d: %w", o.ID, err)
+			}
+			if o.Visible <= 0 || o.Visible > o.Remaining {
+				// a stored iceberg does not know how much of its slice was
+				// left, so it comes back with a fresh one
+				o.replenish()
+			}
+			if o.Type.IsStop() && !o.Triggered {
+				b.addStop(o)
+			} else {
+				b.add(o)
+			}
+			seq = max(seq, o.Seq)
+		}
+		b.auction = state.Auction || b.crossed()
+		restored[state.Symbol] = b
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.books = restored
+	e.seq = max(e.seq, seq)
+	return nil
+}
+
+// restorable checks that o could have been left working on b by the engine
+func restorable(b *Book, o *Order) error {
+	if o.Symbol != b.symbol {
+		return ErrInvalidSymbol
+	}
+	if err := validate(o); err != nil {
+		return err
+	}
+	if o.Remaining <= 0 || o.Remaining > o.Quantity || o.Cancelled || o.Expired {
+		return ErrInvalidQuantity
+	}
+	if o.Type == Market || (o.Type == Stop && o.Triggered) {
+		// these never rest on the book
+		return ErrInvalidOrderType
+	}
+	if b.has(o.ID) {
+		return ErrDuplicateOrder
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package matching
+
+import "math"
+
+// TrailingStop returns the stop price a trailing stop on side keeps from the
+// market price: below it for sells and above it for buys. Stop prices are
+// rounded to the cent.
+func TrailingStop(side Side, price, amount, percent float64) float64 {
+	distance := amount
+	if percent > 0 {
+		distance = price * percent / 100
+	}
+	if side == Sell {
+		return roundCents(price - distance)
+	}
+	return roundCents(price + distance)
+}
+
+func roundCents(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+// trail returns a trailing stop order with its stop moved after the market
+// moved to price, and reports whether it moved at all. Sell stops only move up
+// and buy stops only down. A stop-limit order keeps the same gap between its
+// stop and limit price.
+func (o *Order) trail(price float64) (Order, bool) {
+	moved := *o
+	stop := TrailingStop(o.Side, price, o.TrailAmount, o.TrailPercent)
+	if stop <= 0 || !better(o.Side.Opposite(), stop, o.StopPrice) {
+		return moved, false
+	}
+	if o.Type == StopLimit {
+		moved.Price = roundCents(o.Price + stop - o.StopPrice)
+	}
+	moved.StopPrice = stop
+	return moved, true
+}
+
+// Mark moves the trailing stops on symbol's book after its market price moved
+// to price, then triggers the trailing stops that price has reached. Other
+// stop orders only trigger on trades. During a call auction stops move but
+// wait for the uncross to trigger. Result.Updated holds every stop that moved
+// or triggered and every order that traded as a result.
+func (e *Engine) Mark(symbol string, price float64) *Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := &Result{}
+	b, ok := e.books[symbol]
+	if !ok || price <= 0 {
+		return result
+	}
+
+	stops := append([]*Order(nil), b.stops...)
+	for _, o := range stops {
+		if !o.IsTrailing() {
+			continue
+		}
+		if moved, ok := o.trail(price); ok {
+			b.touch(o)
+			*o = moved
+			result.Updated = appendUpdated(result.Updated, *o)
+		}
+		if b.auction || !stopReached(o, price) {
+			continue
+		}
+
+		b.removeStop(o)
+		o.Triggered = true
+		o.Seq = e.nextSeq()
+		e.execute(b, o, result)
+		result.Updated = appendUpdated(result.Updated, *o)
+	}
+	e.triggerStops(b, result)
+	return result
+}
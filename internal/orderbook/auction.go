@@ -0,0 +1,179 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"brokerapp/internal/matching"
+)
+
+// AuctionWindow is a daily call phase in the market time zone, given as
+// offsets from midnight. Orders collect on the book from Start and it
+// uncrosses at End.
+type AuctionWindow struct {
+	Name  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// WithAuctions runs a call auction in each window for the given symbols.
+// Times are in the time zone set by WithMarketClose.
+func WithAuctions(symbols []string, windows ...AuctionWindow) Option {
+	return func(s *Service) {
+		s.auctionSymbols = make(map[string]bool, len(symbols))
+		for _, symbol := range symbols {
+			s.auctionSymbols[strings.ToUpper(strings.TrimSpace(symbol))] = true
+		}
+		s.auctionWindows = windows
+	}
+}
+
+// callable reports whether an order can wait on the book for an uncross
+func callable(req *CreateOrderRequest) bool {
+	return req.Type != TypeMarket && req.TimeInForce != TIFImmediateOrCancel && req.TimeInForce != TIFFillOrKill
+}
+
+// checkPhase refuses orders that cannot wait for the uncross while symbol is
+// in a call auction. The symbol must be locked.
+func (s *Service) checkPhase(symbol string, req *CreateOrderRequest) error {
+	if s.engine.InAuction(symbol) && !callable(req) {
+		return ErrAuctionOrder
+	}
+	return nil
+}
+
+// auctionWindow returns the scheduled window symbol is in at now and when it
+// ends, or false if it is not in one
+func (s *Service) auctionWindow(symbol string, now time.Time) (AuctionWindow, time.Time, bool) {
+	if !s.auctionSymbols[symbol] {
+		return AuctionWindow{}, time.Time{}, false
+	}
+
+	local := now.In(s.marketTZ)
+	year, month, day := local.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, s.marketTZ)
+	for _, w := range s.auctionWindows {
+		start, end := midnight.Add(w.Start), midnight.Add(w.End)
+		if !now.Before(start) && now.Before(end) {
+			return w, end.UTC(), true
+		}
+	}
+	return AuctionWindow{}, time.Time{}, false
+}
+
+// StartAuction puts symbol into a call phase. Orders collect on the book
+// without trading until Uncross is called.
+func (s *Service) StartAuction(symbol string) {
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	s.engine.StartAuction(symbol)
+}
+
+// Uncross ends the call phase of symbol: everything that crosses trades at
+// the single price that executes the most quantity, and the symbol goes back
+// to continuous trading
+func (s *Service) Uncross(ctx context.Context, symbol string) error {
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	if !s.engine.InAuction(symbol) {
+		return nil
+	}
+
+	var res *matching.Result
+	exec, err := s.change(ctx, symbol, nil, func() (*Execution, error) {
+		res = s.engine.Uncross(symbol)
+		if len(res.Updated) == 0 {
+			return nil, nil
+		}
+		exec := &Execution{}
+		addResult(exec, res)
+		s.runGroups(exec, res.Updated)
+		return exec, nil
+	})
+	if err != nil {
+		log.Printf("Error saving uncross for %s: %v", symbol, err)
+		return err
+	}
+	if exec == nil {
+		return nil
+	}
+
+	quantity := 0
+	for _, t := range res.Trades {
+		quantity += t.Quantity
+	}
+	if quantity > 0 {
+		log.Printf("Uncrossed %s at %g, %d traded", symbol, res.Trades[0].Price, quantity)
+	}
+	return nil
+}
+
+// GetAuction returns the trading phase of symbol and, during a call phase,
+// its indicative uncross price and volume
+func (s *Service) GetAuction(symbol string) (*AuctionResponse, error) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+
+	resp := &AuctionResponse{Symbol: symbol, Phase: PhaseContinuous}
+	if !s.engine.InAuction(symbol) {
+		return resp, nil
+	}
+
+	resp.Phase = PhaseCall
+	if _, end, ok := s.auctionWindow(symbol, s.now()); ok {
+		resp.UncrossAt = &end
+	}
+	resp.IndicativePrice, resp.IndicativeVolume = s.engine.Indicative(symbol)
+	return resp, nil
+}
+
+// CheckAuctions starts the call phase of the auction symbols as their windows
+// open and uncrosses books whose window has closed. Books that were restored
+// crossed and are outside any window are uncrossed straight away. A book that
+// cannot be uncrossed is left in its call phase for the next check, without
+// holding up the others.
+func (s *Service) CheckAuctions(ctx context.Context) error {
+	now := s.now()
+	for symbol := range s.auctionSymbols {
+		w, _, ok := s.auctionWindow(symbol, now)
+		if ok && !s.engine.InAuction(symbol) {
+			s.StartAuction(symbol)
+			log.Printf("Started %s auction for %s", w.Name, symbol)
+		}
+	}
+
+	var errs []error
+	for _, symbol := range s.engine.Symbols() {
+		if _, _, ok := s.auctionWindow(symbol, now); ok || !s.engine.InAuction(symbol) {
+			continue
+		}
+		if err := s.Uncross(ctx, symbol); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RunAuctioneer calls CheckAuctions every interval until ctx is cancelled
+func (s *Service) RunAuctioneer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.CheckAuctions(ctx); err != nil {
+				log.Printf("Error running call auctions: %v", err)
+			}
+		}
+	}
+}
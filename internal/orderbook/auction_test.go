@@ -0,0 +1,160 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCallAuction(t *testing.T) {
+	mockRepo := new(MockRepository)
+	now := time.Date(2024, 2, 20, 9, 26, 0, 0, time.UTC)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithMarketClose(time.UTC, 16*time.Hour),
+		WithAuctions([]string{"aapl"}, AuctionWindow{Name: "opening", Start: 9*time.Hour + 25*time.Minute, End: 9*time.Hour + 30*time.Minute}),
+	)
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.CheckAuctions(ctx))
+
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 101, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 100, Quantity: 6, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Type: TypeMarket, Quantity: 6})
+	assert.Equal(t, ErrAuctionOrder, err)
+
+	uncrossAt := time.Date(2024, 2, 20, 9, 30, 0, 0, time.UTC)
+	auction, err := service.GetAuction("aapl")
+	assert.NoError(t, err)
+	assert.Equal(t, &AuctionResponse{Symbol: "AAPL", Phase: PhaseCall, UncrossAt: &uncrossAt, IndicativePrice: 100, IndicativeVolume: 6}, auction)
+
+	now = uncrossAt
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1 && exec.Trades[0].Price == 100 && exec.Trades[0].Quantity == 6 && exec.Trades[0].TakerSide == "sell" &&
+			len(exec.Orders) == 2 &&
+			exec.Orders[0].OrderID == 1 && exec.Orders[0].Status == StatusPartiallyFilled &&
+			exec.Orders[1].OrderID == 2 && exec.Orders[1].Status == StatusFilled
+	})).Return(nil).Once()
+	assert.NoError(t, service.CheckAuctions(ctx))
+
+	auction, err = service.GetAuction("AAPL")
+	assert.NoError(t, err)
+	assert.Equal(t, &AuctionResponse{Symbol: "AAPL", Phase: PhaseContinuous}, auction)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestExpireOrdersWaitsForUncross(t *testing.T) {
+	mockRepo := new(MockRepository)
+	now := time.Date(2024, 2, 20, 15, 55, 0, 0, time.UTC)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithMarketClose(time.UTC, 16*time.Hour),
+		WithAuctions([]string{"AAPL"}, AuctionWindow{Name: "closing", Start: 15*time.Hour + 50*time.Minute, End: 16 * time.Hour}),
+	)
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.CheckAuctions(ctx))
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 101, Quantity: 10})
+	assert.NoError(t, err)
+
+	// the book is still in its call phase at the close
+	now = time.Date(2024, 2, 20, 16, 0, 0, 0, time.UTC)
+	mockRepo.On("ExpireQueuedOrders", ctx, now, []string{"AAPL"}).Return(int64(0), nil).Once()
+	assert.NoError(t, service.ExpireOrders(ctx))
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+
+	// nothing crosses, so the uncross has nothing to save
+	assert.NoError(t, service.CheckAuctions(ctx))
+	assert.False(t, service.engine.InAuction("AAPL"))
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 1, Status: StatusExpired, BookSeq: 1},
+	}}).Return(nil).Once()
+	mockRepo.On("ExpireQueuedOrders", ctx, now, []string(nil)).Return(int64(0), nil).Once()
+	assert.NoError(t, service.ExpireOrders(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClosingAuctionUncrossesDayOrdersAtTheClose(t *testing.T) {
+	mockRepo := new(MockRepository)
+	now := time.Date(2024, 2, 20, 15, 55, 0, 0, time.UTC)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithMarketClose(time.UTC, 16*time.Hour),
+		WithAuctions([]string{"AAPL"}, AuctionWindow{Name: "closing", Start: 15*time.Hour + 50*time.Minute, End: 16 * time.Hour}),
+	)
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.CheckAuctions(ctx))
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 101, Quantity: 10})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 100, Quantity: 10})
+	assert.NoError(t, err)
+
+	// the expiry sweep and the uncross both run at the close; the sweep must
+	// leave the orders waiting for the uncross open in the database
+	now = time.Date(2024, 2, 20, 16, 0, 0, 0, time.UTC)
+	mockRepo.On("ExpireQueuedOrders", ctx, now, []string{"AAPL"}).Return(int64(0), nil).Once()
+	assert.NoError(t, service.ExpireOrders(ctx))
+
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1 && exec.Trades[0].Quantity == 10 &&
+			statusIn([]*Execution{exec}, 1) == StatusFilled && statusIn([]*Execution{exec}, 2) == StatusFilled
+	})).Return(nil).Once()
+	assert.NoError(t, service.CheckAuctions(ctx))
+	assert.False(t, service.engine.InAuction("AAPL"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckAuctionsCarriesOnPastAFailedUncross(t *testing.T) {
+	mockRepo := new(MockRepository)
+	now := time.Date(2024, 2, 20, 9, 26, 0, 0, time.UTC)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithMarketClose(time.UTC, 16*time.Hour),
+		WithAuctions([]string{"AAPL", "MSFT"}, AuctionWindow{Name: "opening", Start: 9*time.Hour + 25*time.Minute, End: 9*time.Hour + 30*time.Minute}),
+	)
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.CheckAuctions(ctx))
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: symbol, Side: "buy", Price: 101, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+		assert.NoError(t, err)
+		_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: symbol, Side: "sell", Price: 100, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+		assert.NoError(t, err)
+	}
+
+	now = time.Date(2024, 2, 20, 9, 30, 0, 0, time.UTC)
+	dbErr := errors.New("lock wait timeout")
+	uncrossing := func(symbol string) interface{} {
+		return mock.MatchedBy(func(exec *Execution) bool {
+			return len(exec.Trades) == 1 && exec.Trades[0].Symbol == symbol
+		})
+	}
+	mockRepo.On("SaveExecution", mock.Anything, uncrossing("AAPL")).Return(dbErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, uncrossing("MSFT")).Return(nil).Once()
+
+	err := service.CheckAuctions(ctx)
+	assert.ErrorIs(t, err, dbErr)
+
+	// AAPL waits in its call phase for the next check
+	assert.True(t, service.engine.InAuction("AAPL"))
+	assert.False(t, service.engine.InAuction("MSFT"))
+	mockRepo.AssertExpectations(t)
+}
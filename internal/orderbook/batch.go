@@ -0,0 +1,54 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+)
+
+// PlaceBatch places each order in turn exactly as PlaceOrder would. One order
+// failing does not stop the others; the outcome of each is returned in the
+// order they were given.
+func (s *Service) PlaceBatch(ctx context.Context, userID int64, reqs []CreateOrderRequest) ([]BatchItem, error) {
+	if len(reqs) == 0 || len(reqs) > maxBatchOrders {
+		return nil, ErrInvalidBatch
+	}
+
+	items := make([]BatchItem, len(reqs))
+	for i := range reqs {
+		items[i].Result, items[i].Err = s.PlaceOrder(ctx, userID, &reqs[i])
+	}
+	return items, nil
+}
+
+// CancelAll cancels every open order of userID, optionally only those for one
+// symbol and/or side. Orders that finish while this runs are skipped; any other
+// failure is reported without stopping the rest.
+func (s *Service) CancelAll(ctx context.Context, userID int64, req *CancelAllRequest) (*CancelAllResponse, error) {
+	symbol := strings.ToUpper(strings.TrimSpace(req.Symbol))
+	side := strings.ToLower(strings.TrimSpace(req.Side))
+	if side != "" && side != "buy" && side != "sell" {
+		return nil, ErrInvalidSide
+	}
+
+	orders, err := s.repo.GetOpenOrders(ctx, userID, symbol, side)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &CancelAllResponse{Cancelled: []Order{}}
+	for _, o := range orders {
+		cancelled, err := s.CancelOrder(ctx, userID, o.ID)
+		switch {
+		case err == nil:
+			resp.Cancelled = append(resp.Cancelled, *cancelled)
+		case errors.Is(err, ErrOrderNotOpen):
+			// filled, or cancelled along with its bracket entry
+		default:
+			log.Printf("Error cancelling order %d: %v", o.ID, err)
+			resp.Failed = append(resp.Failed, CancelFailure{OrderID: o.ID, Error: err.Error()})
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,73 @@
+package orderbook
+
+import (
+	"context"
+	"testing"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPlaceBatch(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	items, err := service.PlaceBatch(ctx, 1, []CreateOrderRequest{
+		{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10},
+		{Symbol: "AAPL", Side: "hold", Price: 150, Quantity: 10},
+		{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 4},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+
+	assert.NoError(t, items[0].Err)
+	assert.Equal(t, StatusAccepted, items[0].Result.Order.Status)
+	assert.Equal(t, ErrInvalidSide, items[1].Err)
+	assert.Nil(t, items[1].Result)
+	assert.NoError(t, items[2].Err)
+	assert.Equal(t, StatusFilled, items[2].Result.Order.Status, "later orders see the earlier ones")
+	mockRepo.AssertNumberOfCalls(t, "CreateOrder", 2)
+
+	_, err = service.PlaceBatch(ctx, 1, nil)
+	assert.Equal(t, ErrInvalidBatch, err)
+	_, err = service.PlaceBatch(ctx, 1, make([]CreateOrderRequest, maxBatchOrders+1))
+	assert.Equal(t, ErrInvalidBatch, err)
+}
+
+func TestCancelAll(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	var orders []Order
+	for _, req := range []CreateOrderRequest{
+		{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10},
+		{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10},
+		{Symbol: "MSFT", Side: "buy", Price: 300, Quantity: 10},
+	} {
+		placed, err := service.PlaceOrder(ctx, 1, &req)
+		assert.NoError(t, err)
+		orders = append(orders, placed.Order)
+		mockRepo.On("GetOrder", ctx, placed.Order.ID).Return(&placed.Order, nil)
+	}
+	mockRepo.On("GetOpenOrders", ctx, int64(1), "AAPL", "buy").Return(orders[:1], nil)
+
+	resp, err := service.CancelAll(ctx, 1, &CancelAllRequest{Symbol: "aapl", Side: "buy"})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Cancelled, 1)
+	assert.Equal(t, orders[0].ID, resp.Cancelled[0].ID)
+	assert.Empty(t, resp.Failed)
+
+	_, ok := service.engine.Order("AAPL", orders[1].ID)
+	assert.True(t, ok, "sell orders are not part of the filter")
+
+	_, err = service.CancelAll(ctx, 1, &CancelAllRequest{Side: "short"})
+	assert.Equal(t, ErrInvalidSide, err)
+}
@@ -0,0 +1,123 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
+)
+
+// ClosePosition flattens the user's position in symbol with a market order for
+// its whole quantity on the opposite side, placed exactly as PlaceOrder would.
+// Open orders on that side in the symbol that would close the position a
+// second time, such as bracket exits, are cancelled once the closing order has
+// passed its checks, so a close that is turned down leaves them in place.
+func (s *Service) ClosePosition(ctx context.Context, userID int64, symbol string) (*ClosedPosition, error) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+
+	open, err := s.openPositions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range open {
+		if p.Symbol == symbol {
+			return s.closePosition(ctx, userID, p)
+		}
+	}
+	return nil, ErrPositionNotFound
+}
+
+// CloseAllPositions closes every open position of the user as ClosePosition
+// would. One position failing to close does not stop the others.
+func (s *Service) CloseAllPositions(ctx context.Context, userID int64) (*CloseAllResponse, error) {
+	open, err := s.openPositions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &CloseAllResponse{Closed: []ClosedPosition{}}
+	for _, p := range open {
+		closed, err := s.closePosition(ctx, userID, p)
+		if err != nil {
+			log.Printf("Error closing %s position of user %d: %v", p.Symbol, userID, err)
+			failure := CloseFailure{Symbol: p.Symbol, Quantity: p.Quantity, Error: err.Error()}
+			var rejection *risk.RejectionError
+			if errors.As(err, &rejection) {
+				failure.Error = "order rejected by risk checks"
+				failure.Reasons = rejection.Reasons
+			}
+			resp.Failed = append(resp.Failed, failure)
+			continue
+		}
+		resp.Closed = append(resp.Closed, *closed)
+	}
+	return resp, nil
+}
+
+// openPositions returns the user's positions that are not flat
+func (s *Service) openPositions(ctx context.Context, userID int64) ([]positions.Position, error) {
+	if s.positions == nil {
+		return nil, nil
+	}
+
+	resp, err := s.positions.GetPositions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var open []positions.Position
+	for _, p := range resp.Positions {
+		if p.Quantity != 0 {
+			open = append(open, p)
+		}
+	}
+	return open, nil
+}
+
+func (s *Service) closePosition(ctx context.Context, userID int64, p positions.Position) (*ClosedPosition, error) {
+	req := &CreateOrderRequest{Symbol: p.Symbol, Side: "sell", Type: TypeMarket, Quantity: p.Quantity}
+	if p.Quantity < 0 {
+		req.Side = "buy"
+		req.Quantity = -p.Quantity
+	}
+
+	result, cancelled, err := s.placeReplacing(ctx, userID, req, func(symbol string) ([]Order, error) {
+		return s.overClosing(ctx, userID, symbol, req.Side)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ClosedPosition{Symbol: p.Symbol, Quantity: p.Quantity, Order: result, Cancelled: cancelled}, nil
+}
+
+// overClosing returns the user's open orders on side in symbol that would
+// trade past flat once the position has been closed. Bracket exits still held
+// for an entry that has filled nothing are left out: they only close what that
+// entry goes on to open.
+func (s *Service) overClosing(ctx context.Context, userID int64, symbol, side string) ([]Order, error) {
+	orders, err := s.repo.GetOpenOrders(ctx, userID, symbol, side)
+	if err != nil {
+		return nil, err
+	}
+
+	var over []Order
+	for _, o := range orders {
+		if o.Status == StatusNew && o.GroupType == GroupBracket && o.ParentOrderID != 0 {
+			entry, err := s.repo.GetOrder(ctx, o.ParentOrderID)
+			if err != nil {
+				return nil, err
+			}
+			if entry.FilledQuantity == 0 {
+				continue
+			}
+		}
+		over = append(over, o)
+	}
+	return over, nil
+}
@@ -0,0 +1,174 @@
+package orderbook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"brokerapp/internal/matching"
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClosePositions(t *testing.T) {
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithPositions(positions.NewService(positionsRepo)))
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	// liquidity from another user to close against
+	_, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "MSFT", Side: "sell", Price: 300, Quantity: 5})
+	assert.NoError(t, err)
+
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{
+		{Symbol: "AAPL", Quantity: 10, EntryPrice: 140, CurrentPrice: 150},
+		{Symbol: "MSFT", Quantity: -5, EntryPrice: 310, CurrentPrice: 300},
+		{Symbol: "TSLA", RealizedPNL: 20},
+	}, nil)
+	mockRepo.On("GetOpenOrders", ctx, int64(1), mock.Anything, mock.Anything).Return([]Order{}, nil)
+
+	_, err = service.ClosePosition(ctx, 1, "tsla")
+	assert.Equal(t, ErrPositionNotFound, err)
+
+	closed, err := service.ClosePosition(ctx, 1, "aapl")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, closed.Quantity)
+	assert.Equal(t, "sell", closed.Order.Order.Side)
+	assert.Equal(t, TypeMarket, closed.Order.Order.Type)
+	assert.Equal(t, StatusFilled, closed.Order.Order.Status)
+	assert.Empty(t, closed.Cancelled)
+
+	// a short is bought back
+	resp, err := service.CloseAllPositions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Failed)
+	assert.Len(t, resp.Closed, 2)
+	assert.Equal(t, "buy", resp.Closed[1].Order.Order.Side)
+	assert.Equal(t, 5, resp.Closed[1].Order.Order.Quantity)
+	assert.Equal(t, StatusFilled, resp.Closed[1].Order.Order.Status)
+}
+
+func TestClosePositionCancelsOrdersThatWouldOverClose(t *testing.T) {
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithPositions(positions.NewService(positionsRepo)))
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	exit, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10})
+	assert.NoError(t, err)
+	// the take profit of this bracket waits for an entry that has not traded
+	entry, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 140, Quantity: 5,
+		TakeProfit: &TakeProfitRequest{Price: 170}})
+	assert.NoError(t, err)
+	tp := entry.Children[0]
+
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{{Symbol: "AAPL", Quantity: 10, EntryPrice: 150}}, nil)
+	mockRepo.On("GetOpenOrders", ctx, int64(1), "AAPL", "sell").Return([]Order{exit.Order, tp}, nil)
+	mockRepo.On("GetOrder", ctx, exit.Order.ID).Return(&exit.Order, nil)
+	mockRepo.On("GetOrder", ctx, entry.Order.ID).Return(&entry.Order, nil)
+
+	closed, err := service.ClosePosition(ctx, 1, "AAPL")
+	assert.NoError(t, err)
+	assert.Len(t, closed.Cancelled, 1)
+	assert.Equal(t, exit.Order.ID, closed.Cancelled[0].ID)
+	_, ok := service.engine.Order("AAPL", exit.Order.ID)
+	assert.False(t, ok)
+
+	// the bracket only sells what its entry buys, so it is left alone
+	_, ok = service.engine.Order("AAPL", entry.Order.ID)
+	assert.True(t, ok)
+	assert.NotNil(t, service.groupOf(tp.ID))
+}
+
+func TestClosePositionReturnsCancelErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithPositions(positions.NewService(positionsRepo)))
+
+	ctx := context.Background()
+	exit := Order{ID: 7, UserID: 1, Symbol: "AAPL", Side: "sell", Status: StatusAccepted}
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{{Symbol: "AAPL", Quantity: 10, EntryPrice: 150}}, nil)
+	mockRepo.On("GetOpenOrders", ctx, int64(1), "AAPL", "sell").Return([]Order{exit}, nil)
+	mockRepo.On("GetOrder", ctx, exit.ID).Return(nil, ErrOrderNotFound)
+
+	_, err := service.ClosePosition(ctx, 1, "AAPL")
+	assert.Equal(t, ErrOrderNotFound, err)
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestRefusedCloseLeavesOpenOrders(t *testing.T) {
+	// 17:00 in New York, after the regular session
+	now := time.Date(2024, 2, 20, 22, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithCalendar(testCalendar(t)),
+		WithPositions(positions.NewService(positionsRepo)),
+	)
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	exit, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10,
+		TimeInForce: TIFGoodTillCancel, ExtendedHours: true})
+	assert.NoError(t, err)
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{{Symbol: "AAPL", Quantity: 10, EntryPrice: 150}}, nil)
+	mockRepo.On("GetOpenOrders", ctx, int64(1), "AAPL", "sell").Return([]Order{exit.Order}, nil)
+	mockRepo.On("GetOrder", ctx, exit.Order.ID).Return(&exit.Order, nil)
+
+	// a market order cannot wait for the open
+	_, err = service.ClosePosition(ctx, 1, "AAPL")
+	assert.Equal(t, ErrMarketClosed, err)
+	_, ok := service.engine.Order("AAPL", exit.Order.ID)
+	assert.True(t, ok)
+
+	// nor be placed during an auction
+	now = time.Date(2024, 2, 20, 15, 0, 0, 0, time.UTC)
+	service.StartAuction("AAPL")
+	_, err = service.ClosePosition(ctx, 1, "AAPL")
+	assert.Equal(t, ErrAuctionOrder, err)
+	_, ok = service.engine.Order("AAPL", exit.Order.ID)
+	assert.True(t, ok)
+	mockRepo.AssertNumberOfCalls(t, "SaveExecution", 1)
+}
+
+func TestCloseRiskChecksExcludeCancelledOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	riskRepo := new(risk.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithPositions(positions.NewService(positionsRepo)),
+		WithRiskChecks(risk.NewPipeline(risk.SellQuantity(riskRepo))))
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	riskRepo.On("GetSellableQuantity", ctx, int64(1), "AAPL").Return(10, nil)
+	riskRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64(nil)).Return(0, nil).Once()
+	riskRepo.On("IsShortingEnabled", ctx, int64(1)).Return(false, nil)
+	exit, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10})
+	assert.NoError(t, err)
+
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{{Symbol: "AAPL", Quantity: 10, EntryPrice: 150}}, nil)
+	mockRepo.On("GetOpenOrders", ctx, int64(1), "AAPL", "sell").Return([]Order{exit.Order}, nil)
+	mockRepo.On("GetOrder", ctx, exit.Order.ID).Return(&exit.Order, nil)
+	// the exit still counts until the close has passed its checks
+	riskRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64{exit.Order.ID}).Return(0, nil)
+
+	closed, err := service.ClosePosition(ctx, 1, "AAPL")
+	assert.NoError(t, err)
+	assert.Len(t, closed.Cancelled, 1)
+	riskRepo.AssertCalled(t, "GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64{exit.Order.ID})
+}
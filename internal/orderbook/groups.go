@@ -0,0 +1,461 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"brokerapp/internal/matching"
+)
+
+// orderGroup links the working orders of a bracket or OCO group. A group is
+// only changed with its symbol locked.
+type orderGroup struct {
+	symbol string
+
+	// entryID is the bracket entry order, zero for OCO groups and once the
+	// entry has finished
+	entryID int64
+
+	// held are orders waiting for the entry to finish before they go on the book
+	held []*Order
+
+	// legs are the orders on the book that share one quantity between them
+	legs []*Order
+
+	// traded is how much the legs have filled between them; every other leg is
+	// reduced by it
+	traded int
+
+	// closed is set once a leg has filled or ended and the others were cancelled
+	closed bool
+}
+
+func (g *orderGroup) ids() []int64 {
+	var ids []int64
+	if g.entryID != 0 {
+		ids = append(ids, g.entryID)
+	}
+	for _, o := range g.held {
+		ids = append(ids, o.ID)
+	}
+	for _, o := range g.legs {
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+// leg returns the leg of g with the given ID, or nil if it has none
+func (g *orderGroup) leg(orderID int64) *Order {
+	for _, o := range g.legs {
+		if o.ID == orderID {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *Service) groupOf(orderID int64) *orderGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groups[orderID]
+}
+
+func (s *Service) trackGroup(g *orderGroup) {
+	s.touchGroup(g)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range g.ids() {
+		s.groups[id] = g
+	}
+}
+
+func (s *Service) untrack(ids ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.groups, id)
+	}
+}
+
+// PlaceOCO places two orders for the same symbol and side that cancel each
+// other: whatever one of them fills is taken off the other, and as soon as
+// one of them has filled or ended, the other is cancelled. The second order
+// is linked to the first as its child.
+func (s *Service) PlaceOCO(ctx context.Context, userID int64, req *OCORequest) (*OrderResult, error) {
+	if len(req.Orders) != 2 {
+		return nil, ErrInvalidOCO
+	}
+
+	now := s.now()
+	legs := make([]*Order, 0, len(req.Orders))
+	for i := range req.Orders {
+		leg := &req.Orders[i]
+		if leg.TakeProfit != nil || leg.StopLoss != nil {
+			return nil, ErrInvalidOCO
+		}
+
+		symbol := strings.ToUpper(strings.TrimSpace(leg.Symbol))
+		setDefaults(leg)
+		if err := validateOrder(symbol, leg, now); err != nil {
+			return nil, err
+		}
+		if queue, err := s.checkHours(symbol, leg, now); err != nil || queue {
+			// the legs cannot be queued as a group
+			return nil, ErrMarketClosed
+		}
+		legs = append(legs, s.newOrder(userID, symbol, leg, now))
+	}
+	if legs[0].Symbol != legs[1].Symbol || legs[0].Side != legs[1].Side {
+		return nil, ErrInvalidOCO
+	}
+	symbol := legs[0].Symbol
+
+	unlockUser := s.userLocks.lock(userID)
+	defer unlockUser()
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	// only one leg can trade, so each is checked on its own rather than
+	// counting the other against it
+	for i, leg := range legs {
+		if err := s.checkPhase(symbol, &req.Orders[i]); err != nil {
+			return nil, err
+		}
+		if err := s.startTrail(symbol, &req.Orders[i]); err != nil {
+			return nil, err
+		}
+		leg.StopPrice = req.Orders[i].StopPrice
+		if isStopType(leg.Type) && stopReached(leg.Side, leg.StopPrice, s.engine.LastPrice(symbol)) {
+			return nil, ErrStopPriceReached
+		}
+		if err := s.checkRisk(ctx, riskOrder(leg)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, leg := range legs {
+		leg.GroupType = GroupOCO
+		leg.Status = StatusNew
+		if i > 0 {
+			leg.ParentOrderID = legs[0].ID
+		}
+		if err := s.repo.CreateOrder(ctx, leg); err != nil {
+			s.closeUnplaced(ctx, StatusCancelled, "OCO orders could not be stored", legs[:i]...)
+			return nil, err
+		}
+	}
+
+	ids := []int64{legs[0].ID, legs[1].ID}
+	exec, err := s.change(ctx, symbol, ids, func() (*Execution, error) {
+		g := &orderGroup{symbol: symbol, held: slices.Clone(legs)}
+		s.trackGroup(g)
+
+		exec := &Execution{}
+		s.releaseHeld(exec, g, 0)
+		return exec, nil
+	})
+	if err != nil {
+		log.Printf("Error saving execution for OCO order %d: %v", legs[0].ID, err)
+		s.closeUnplaced(ctx, StatusRejected, "OCO orders could not be saved", legs...)
+		return nil, err
+	}
+
+	return &OrderResult{Order: *legs[0], Children: []Order{*legs[1]}, Trades: exec.Trades}, nil
+}
+
+// createBracket stores the take-profit and stop-loss orders of a bracket
+// whose entry order has just been created. They are held until the entry has
+// finished.
+func (s *Service) createBracket(ctx context.Context, entry *Order, req *CreateOrderRequest) (*orderGroup, error) {
+	child := func() *Order {
+		return &Order{
+			UserID:        entry.UserID,
+			ParentOrderID: entry.ID,
+			GroupType:     GroupBracket,
+			Symbol:        entry.Symbol,
+			Side:          opposite(entry.Side),
+			Quantity:      entry.Quantity,
+			TimeInForce:   TIFGoodTillCancel,
+			Status:        StatusNew,
+		}
+	}
+
+	var held []*Order
+	if tp := req.TakeProfit; tp != nil {
+		o := child()
+		o.Type = TypeLimit
+		o.Price = tp.Price
+		held = append(held, o)
+	}
+	if sl := req.StopLoss; sl != nil {
+		o := child()
+		o.Type = TypeStop
+		o.StopPrice = sl.StopPrice
+		if sl.Price > 0 {
+			o.Type = TypeStopLimit
+			o.Price = sl.Price
+		}
+		held = append(held, o)
+	}
+
+	for i, o := range held {
+		if err := s.repo.CreateOrder(ctx, o); err != nil {
+			s.closeUnplaced(ctx, StatusCancelled, "bracket orders could not be stored", held[:i]...)
+			return nil, err
+		}
+	}
+
+	g := &orderGroup{symbol: entry.Symbol, entryID: entry.ID, held: held}
+	s.trackGroup(g)
+	return g, nil
+}
+
+// runGroups applies the bracket and OCO rules to orders the engine just
+// changed: a bracket entry that has finished releases its held orders, a leg
+// that traded reduces the other legs by what it filled, and a leg that has
+// filled or ended cancels them. Everything this changes is added to exec.
+func (s *Service) runGroups(exec *Execution, orders []matching.Order) {
+	for i := range orders {
+		o := &orders[i]
+		g := s.groupOf(o.ID)
+		if g == nil {
+			continue
+		}
+		s.touchGroup(g)
+
+		if o.ID == g.entryID {
+			if !o.IsActive() {
+				s.releaseHeld(exec, g, o.Filled())
+			}
+			continue
+		}
+		leg := g.leg(o.ID)
+		if leg == nil {
+			continue
+		}
+		traded := o.Filled() - leg.FilledQuantity
+		applyState(leg, o)
+		if !o.IsActive() {
+			s.closeGroup(exec, g, o.ID)
+		} else if traded > 0 {
+			s.reduceLegs(exec, g, o.ID, traded)
+		}
+	}
+}
+
+// reduceLegs takes what one leg of g just traded off every other leg, and
+// cancels the legs that have nothing left
+func (s *Service) reduceLegs(exec *Execution, g *orderGroup, orderID int64, traded int) {
+	g.traded += traded
+	for _, leg := range g.legs {
+		if leg.ID == orderID {
+			continue
+		}
+		current, ok := s.engine.Order(g.symbol, leg.ID)
+		if !ok {
+			// the leg has already finished
+			continue
+		}
+
+		quantity := current.Quantity - traded
+		if quantity <= current.Filled() {
+			cancelled, err := s.engine.Cancel(g.symbol, leg.ID)
+			if err != nil {
+				continue
+			}
+			applyState(leg, &cancelled)
+			update := orderUpdate(&cancelled)
+			update.Reason = fmt.Sprintf("order %d in the same group filled its quantity", orderID)
+			exec.Orders = append(exec.Orders, update)
+			continue
+		}
+
+		res, err := s.engine.Amend(g.symbol, leg.ID, current.Price, quantity)
+		if err != nil {
+			log.Printf("Error reducing order %d after order %d traded: %v", leg.ID, orderID, err)
+			continue
+		}
+		applyState(leg, &res.Order)
+		exec.Amendments = append(exec.Amendments, Amendment{OrderID: leg.ID, Price: current.Price, Quantity: quantity})
+	}
+}
+
+// releaseHeld puts the held orders of g on the book. Bracket orders are sized
+// to what the entry filled and are cancelled if it filled nothing; a quantity
+// of zero for a group without an entry keeps their own size. Whatever legs
+// released before them have traded is taken off the rest.
+func (s *Service) releaseHeld(exec *Execution, g *orderGroup, quantity int) {
+	s.touchGroup(g)
+	bracket := g.entryID != 0
+	if bracket {
+		s.untrack(g.entryID)
+		g.entryID = 0
+	}
+
+	held := g.held
+	g.held = nil
+	for _, o := range held {
+		size := o.Quantity
+		if bracket {
+			size = quantity
+		}
+		size -= g.traded
+
+		reason := ""
+		switch {
+		case g.closed:
+			reason = "another order in the group filled or ended"
+		case bracket && quantity == 0:
+			reason = "entry order ended without filling"
+		case size <= 0:
+			reason = "another order in the group filled its quantity"
+		}
+		if reason != "" {
+			o.Status = StatusCancelled
+			exec.Orders = append(exec.Orders, OrderUpdate{OrderID: o.ID, Status: StatusCancelled, Reason: reason})
+			continue
+		}
+
+		if o.Quantity != size {
+			o.Quantity = size
+			exec.Amendments = append(exec.Amendments, Amendment{OrderID: o.ID, Price: o.Price, Quantity: size})
+		}
+		s.activate(exec, g, o)
+	}
+
+	if g.closed || len(g.legs) == 0 {
+		for _, o := range held {
+			s.untrack(o.ID)
+		}
+	}
+}
+
+// activate submits a held order to the engine as a leg of g
+func (s *Service) activate(exec *Execution, g *orderGroup, o *Order) {
+	eo := toEngineOrder(o)
+	if isStopType(o.Type) && stopReached(o.Side, o.StopPrice, s.engine.LastPrice(o.Symbol)) {
+		// the market went through the stop while the order was held
+		eo.Triggered = true
+	}
+
+	res, err := s.engine.Submit(eo)
+	if err != nil {
+		log.Printf("Engine rejected held order %d: %v", o.ID, err)
+		o.Status = StatusRejected
+		exec.Orders = append(exec.Orders, OrderUpdate{OrderID: o.ID, Status: StatusRejected, Reason: err.Error()})
+		return
+	}
+
+	// the state of the order is applied by runGroups, which needs to see
+	// what it filled
+	g.legs = append(g.legs, o)
+
+	exec.Orders = append(exec.Orders, acceptedUpdates(&res.Order)...)
+	addResult(exec, res)
+
+	s.runGroups(exec, touched(res))
+}
+
+// closeGroup cancels every leg of g other than the one that filled or ended
+func (s *Service) closeGroup(exec *Execution, g *orderGroup, orderID int64) {
+	if g.closed {
+		return
+	}
+	s.touchGroup(g)
+	g.closed = true
+
+	for _, leg := range g.legs {
+		if leg.ID == orderID {
+			continue
+		}
+		cancelled, err := s.engine.Cancel(g.symbol, leg.ID)
+		if err != nil {
+			// the leg has already finished
+			continue
+		}
+		applyState(leg, &cancelled)
+		update := orderUpdate(&cancelled)
+		update.Reason = fmt.Sprintf("order %d in the same group filled or ended", orderID)
+		exec.Orders = append(exec.Orders, update)
+	}
+
+	s.untrack(g.ids()...)
+}
+
+// dropHeld forgets a held order that was cancelled before it was released
+func (s *Service) dropHeld(orderID int64) {
+	g := s.groupOf(orderID)
+	if g == nil {
+		return
+	}
+	s.touchGroup(g)
+
+	for i, o := range g.held {
+		if o.ID == orderID {
+			g.held = append(g.held[:i], g.held[i+1:]...)
+			break
+		}
+	}
+	s.untrack(orderID)
+}
+
+// closeUnplaced moves orders that were stored but never reached the engine to
+// a final status
+func (s *Service) closeUnplaced(ctx context.Context, status, reason string, orders ...*Order) {
+	if len(orders) == 0 {
+		return
+	}
+
+	exec := &Execution{}
+	for _, o := range orders {
+		exec.Orders = append(exec.Orders, OrderUpdate{OrderID: o.ID, Status: status, Reason: reason})
+	}
+	if err := s.repo.SaveExecution(ctx, exec); err != nil {
+		log.Printf("Error cancelling order %d: %v", orders[0].ID, err)
+	}
+}
+
+// validateBracket checks that the take profit is on the profitable side of the
+// entry and the stop loss on the losing side. Market entries have no price to
+// compare against.
+func validateBracket(req *CreateOrderRequest) error {
+	tp, sl := req.TakeProfit, req.StopLoss
+	if tp == nil && sl == nil {
+		return nil
+	}
+
+	entry := req.Price
+	if entry == 0 {
+		entry = req.StopPrice
+	}
+	up := req.Side == "buy"
+
+	if tp != nil && (tp.Price <= 0 || (entry > 0 && !beyond(up, tp.Price, entry))) {
+		return ErrInvalidTakeProfit
+	}
+	if sl != nil && (sl.StopPrice <= 0 || sl.Price < 0 || (entry > 0 && !beyond(!up, sl.StopPrice, entry))) {
+		return ErrInvalidStopLoss
+	}
+	if tp != nil && sl != nil && !beyond(up, tp.Price, sl.StopPrice) {
+		return ErrInvalidTakeProfit
+	}
+	return nil
+}
+
+// beyond reports whether price is above ref when up is set and below it otherwise
+func beyond(up bool, price, ref float64) bool {
+	if up {
+		return price > ref
+	}
+	return price < ref
+}
+
+func opposite(side string) string {
+	if side == "buy" {
+		return "sell"
+	}
+	return "buy"
+}
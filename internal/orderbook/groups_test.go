@@ -0,0 +1,214 @@
+package orderbook
+
+import (
+	"context"
+	"testing"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// captureExecutions records every execution the service saves
+func captureExecutions(mockRepo *MockRepository) *[]*Execution {
+	var execs []*Execution
+	mockRepo.On("SaveExecution", mock.Anything, mock.AnythingOfType("*orderbook.Execution")).
+		Run(func(args mock.Arguments) {
+			execs = append(execs, args.Get(1).(*Execution))
+		}).
+		Return(nil)
+	return &execs
+}
+
+// statusIn returns the last status exec gave the order, or "" if it has none
+func statusIn(execs []*Execution, orderID int64) string {
+	status := ""
+	for _, exec := range execs {
+		for _, u := range exec.Orders {
+			if u.OrderID == orderID {
+				status = u.Status
+			}
+		}
+	}
+	return status
+}
+
+func TestBracketReleasedWhenEntryFills(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	execs := captureExecutions(mockRepo)
+
+	ctx := context.Background()
+	entry, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{
+		Symbol:     "AAPL",
+		Side:       "buy",
+		Price:      150,
+		Quantity:   10,
+		TakeProfit: &TakeProfitRequest{Price: 160},
+		StopLoss:   &StopLossRequest{StopPrice: 140},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, GroupBracket, entry.Order.GroupType)
+	assert.Len(t, entry.Children, 2)
+	tp, sl := entry.Children[0], entry.Children[1]
+	assert.Equal(t, StatusNew, tp.Status)
+	assert.Equal(t, "sell", tp.Side)
+	assert.Equal(t, TypeLimit, tp.Type)
+	assert.Equal(t, TypeStop, sl.Type)
+	assert.Equal(t, entry.Order.ID, sl.ParentOrderID)
+
+	_, ok := service.engine.Order("AAPL", tp.ID)
+	assert.False(t, ok, "children wait for the entry to fill")
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAccepted, statusIn(*execs, tp.ID))
+	assert.Equal(t, StatusAccepted, statusIn(*execs, sl.ID))
+
+	// the take profit trading cancels the stop loss
+	_, err = service.PlaceOrder(ctx, 3, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 160, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFilled, statusIn(*execs, tp.ID))
+	assert.Equal(t, StatusCancelled, statusIn(*execs, sl.ID))
+	_, ok = service.engine.Order("AAPL", sl.ID)
+	assert.False(t, ok)
+	assert.Empty(t, service.groups)
+}
+
+func TestBracketChildrenSizedToPartialFill(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	execs := captureExecutions(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 4})
+	assert.NoError(t, err)
+
+	entry, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{
+		Symbol:      "AAPL",
+		Side:        "buy",
+		Price:       150,
+		Quantity:    10,
+		TimeInForce: TIFImmediateOrCancel,
+		TakeProfit:  &TakeProfitRequest{Price: 160},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, entry.Order.FilledQuantity)
+
+	tp := entry.Children[0]
+	assert.Equal(t, StatusAccepted, tp.Status)
+	assert.Equal(t, 4, tp.Quantity)
+	resting, ok := service.engine.Order("AAPL", tp.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 4, resting.Remaining)
+
+	last := (*execs)[len(*execs)-1]
+	assert.Equal(t, []Amendment{{OrderID: tp.ID, Price: 160, Quantity: 4}}, last.Amendments)
+}
+
+func TestBracketCancelledWithEntry(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	execs := captureExecutions(mockRepo)
+
+	ctx := context.Background()
+	entry, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{
+		Symbol:   "AAPL",
+		Side:     "sell",
+		Price:    150,
+		Quantity: 10,
+		StopLoss: &StopLossRequest{StopPrice: 155, Price: 156},
+	})
+	assert.NoError(t, err)
+	sl := entry.Children[0]
+	assert.Equal(t, TypeStopLimit, sl.Type)
+	assert.Equal(t, "buy", sl.Side)
+
+	stored := entry.Order
+	mockRepo.On("GetOrder", ctx, stored.ID).Return(&stored, nil)
+
+	_, err = service.CancelOrder(ctx, 1, stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, statusIn(*execs, sl.ID))
+	assert.Empty(t, service.groups)
+}
+
+func TestBracketValidation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10,
+		TakeProfit: &TakeProfitRequest{Price: 149}})
+	assert.Equal(t, ErrInvalidTakeProfit, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10,
+		StopLoss: &StopLossRequest{StopPrice: 145}})
+	assert.Equal(t, ErrInvalidStopLoss, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10,
+		TakeProfit: &TakeProfitRequest{Price: 140}, StopLoss: &StopLossRequest{StopPrice: 145}})
+	assert.Equal(t, ErrInvalidTakeProfit, err)
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestOCOPartialFillReducesOtherLeg(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	execs := captureExecutions(mockRepo)
+
+	ctx := context.Background()
+	result, err := service.PlaceOCO(ctx, 1, &OCORequest{Orders: []CreateOrderRequest{
+		{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10, TimeInForce: TIFGoodTillCancel},
+		{Symbol: "aapl", Side: "sell", Type: TypeStop, StopPrice: 140, Quantity: 10, TimeInForce: TIFGoodTillCancel},
+	}})
+	assert.NoError(t, err)
+	limit, stop := result.Order, result.Children[0]
+	assert.Equal(t, GroupOCO, limit.GroupType)
+	assert.Equal(t, limit.ID, stop.ParentOrderID)
+	assert.Equal(t, StatusAccepted, limit.Status)
+	assert.Equal(t, StatusAccepted, stop.Status)
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 160, Quantity: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPartiallyFilled, statusIn(*execs, limit.ID))
+	assert.Equal(t, StatusAccepted, statusIn(*execs, stop.ID))
+
+	last := (*execs)[len(*execs)-1]
+	assert.Equal(t, []Amendment{{OrderID: stop.ID, Quantity: 7}}, last.Amendments)
+	resting, ok := service.engine.Order("AAPL", stop.ID)
+	assert.True(t, ok, "the other leg keeps working for what is left")
+	assert.Equal(t, 7, resting.Remaining)
+
+	// the rest of the limit filling cancels the stop
+	_, err = service.PlaceOrder(ctx, 3, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 160, Quantity: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFilled, statusIn(*execs, limit.ID))
+	assert.Equal(t, StatusCancelled, statusIn(*execs, stop.ID))
+	_, ok = service.engine.Order("AAPL", stop.ID)
+	assert.False(t, ok)
+	assert.Empty(t, service.groups)
+}
+
+func TestOCOValidation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	_, err := service.PlaceOCO(ctx, 1, &OCORequest{Orders: []CreateOrderRequest{
+		{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10},
+	}})
+	assert.Equal(t, ErrInvalidOCO, err)
+
+	_, err = service.PlaceOCO(ctx, 1, &OCORequest{Orders: []CreateOrderRequest{
+		{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10},
+		{Symbol: "AAPL", Side: "buy", Price: 140, Quantity: 10},
+	}})
+	assert.Equal(t, ErrInvalidOCO, err)
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
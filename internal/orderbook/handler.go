@@ -2,147 +2,424 @@ package orderbook
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"brokerapp/internal/db"
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
 
 	"github.com/go-chi/chi/v5"
 )
 
-type Order struct {
-	ID        int64   `json:"id"`
-	Symbol    string  `json:"symbol"`
-	Side      string  `json:"side"` // "buy" or "sell"
-	Price     float64 `json:"price"`
-	Quantity  int     `json:"quantity"`
-	Status    string  `json:"status"`
-	CreatedAt string  `json:"created_at"`
+type Handler struct {
+	service *Service
 }
 
-type CreateOrderRequest struct {
-	Symbol   string  `json:"symbol"`
-	Side     string  `json:"side"` // "buy" or "sell"
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
 }
 
-type PNL struct {
-	Unrealized float64 `json:"unrealized"`
-	Realized   float64 `json:"realized"`
-	Total      float64 `json:"total"`
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Use(h.requireReady)
+	r.Get("/orderbook", h.GetOrderbook)
+	r.Post("/orders", h.CreateOrder)
+	r.Post("/orders/oco", h.CreateOCO)
+	r.Post("/orders/batch", h.CreateBatch)
+	r.Post("/orders/cancel-all", h.CancelAll)
+	r.Delete("/orders/{id}", h.CancelOrder)
+	r.Patch("/orders/{id}", h.AmendOrder)
+	r.Get("/orders/{id}/history", h.GetOrderHistory)
+	r.Get("/trades", h.GetTrades)
+	r.Get("/market/{symbol}/depth", h.GetDepth)
+	r.Get("/market/{symbol}/auction", h.GetAuction)
+	r.Get("/market/{symbol}/hours", h.GetMarketHours)
+	r.Post("/positions/close", h.CloseAllPositions)
+	r.Post("/positions/{symbol}/close", h.ClosePosition)
+	r.Get("/account/self-trade-prevention", h.GetSelfTradePrevention)
+	r.Put("/account/self-trade-prevention", h.SetSelfTradePrevention)
 }
 
-type OrderbookResponse struct {
-	Orders []Order `json:"orders"`
-	PNL    PNL     `json:"pnl"`
+// requireReady turns requests away until the book has been rebuilt after a restart
+func (h *Handler) requireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.service.Ready() {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Order book is recovering, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-type Handler struct {
-	db *db.MySQL
-}
+func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
 
-func NewHandler(db *db.MySQL) *Handler {
-	return &Handler{db: db}
+	var req CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// the Idempotency-Key header is an alternative to client_order_id
+	if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+		if req.ClientOrderID != "" && strings.TrimSpace(req.ClientOrderID) != key {
+			http.Error(w, ErrClientOrderIDMismatch.Error(), http.StatusBadRequest)
+			return
+		}
+		req.ClientOrderID = key
+	}
+
+	result, err := h.service.PlaceOrder(r.Context(), userID, &req)
+	if err != nil {
+		writeOrderError(w, err, "Failed to create order")
+		return
+	}
+
+	status := http.StatusCreated
+	if result.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
 }
 
-func (h *Handler) RegisterRoutes(r chi.Router) {
-	r.Get("/orderbook", h.GetOrderbook)
-	r.Post("/orders", h.CreateOrder)
+func (h *Handler) CreateOCO(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	var req OCORequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.PlaceOCO(r.Context(), userID, &req)
+	if err != nil {
+		writeOrderError(w, err, "Failed to create OCO order")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
 }
 
-func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateBatch(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int64)
 
-	var req CreateOrderRequest
+	var req BatchOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Validate side
-	if req.Side != "buy" && req.Side != "sell" {
-		http.Error(w, "Invalid side: must be 'buy' or 'sell'", http.StatusBadRequest)
+	items, err := h.service.PlaceBatch(r.Context(), userID, req.Orders)
+	if err != nil {
+		writeOrderError(w, err, "Failed to create orders")
 		return
 	}
 
-	query := `
-		INSERT INTO orders (user_id, symbol, side, price, quantity, status)
-		VALUES (?, ?, ?, ?, ?, 'pending')
-	`
+	resp := BatchOrderResponse{Results: make([]BatchOrderResult, len(items))}
+	for i, item := range items {
+		res := BatchOrderResult{Index: i, Status: http.StatusCreated, Result: item.Result}
+		switch {
+		case item.Err != nil:
+			res.Status = orderErrorStatus(item.Err)
+			res.Error = item.Err.Error()
+			var rejection *risk.RejectionError
+			if errors.As(item.Err, &rejection) {
+				res.Error = "order rejected by risk checks"
+				res.Reasons = rejection.Reasons
+			} else if res.Status == http.StatusInternalServerError {
+				res.Error = "Failed to create order"
+			}
+		case item.Result.Replayed:
+			res.Status = http.StatusOK
+		}
+		resp.Results[i] = res
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) CancelAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	// the filter is optional, so an empty body cancels everything
+	var req CancelAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	_, err := h.db.Exec(r.Context(), query,
-		userID,
-		req.Symbol,
-		req.Side,
-		req.Price,
-		req.Quantity,
-	)
+	resp, err := h.service.CancelAll(r.Context(), userID, &req)
 	if err != nil {
-		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		writeOrderError(w, err, "Failed to cancel orders")
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) ClosePosition(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	closed, err := h.service.ClosePosition(r.Context(), userID, chi.URLParam(r, "symbol"))
+	if err != nil {
+		writeOrderError(w, err, "Failed to close position")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(closed)
+}
+
+func (h *Handler) CloseAllPositions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	resp, err := h.service.CloseAllPositions(r.Context(), userID)
+	if err != nil {
+		writeOrderError(w, err, "Failed to close positions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.service.CancelOrder(r.Context(), userID, orderID)
+	if err != nil {
+		writeOrderError(w, err, "Failed to cancel order")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+func (h *Handler) AmendOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.AmendOrder(r.Context(), userID, orderID, &req)
+	if err != nil {
+		writeOrderError(w, err, "Failed to amend order")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.service.GetOrderHistory(r.Context(), userID, orderID)
+	if err != nil {
+		writeOrderError(w, err, "Failed to fetch order history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (h *Handler) GetSelfTradePrevention(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	setting, err := h.service.GetSelfTradePrevention(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch self-trade prevention mode", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(setting)
+}
+
+func (h *Handler) SetSelfTradePrevention(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	var req SelfTradeSetting
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	setting, err := h.service.SetSelfTradePrevention(r.Context(), userID, &req)
+	if err != nil {
+		writeOrderError(w, err, "Failed to update self-trade prevention mode")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(setting)
 }
 
 func (h *Handler) GetOrderbook(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int64)
 
-	query := `
-		SELECT id, symbol, side, price, quantity, status, created_at
-		FROM orders
-		WHERE user_id = ?
-		ORDER BY created_at DESC
-	`
+	params := r.URL.Query()
+	q := OrderbookQuery{
+		Status: params.Get("status"),
+		Symbol: params.Get("symbol"),
+		Side:   params.Get("side"),
+		From:   params.Get("from"),
+		To:     params.Get("to"),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, ErrInvalidLimit.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	response, err := h.service.GetOrderbook(r.Context(), userID, &q)
+	if err != nil {
+		writeOrderError(w, err, "Failed to fetch orderbook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) GetTrades(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
 
-	rows, err := h.db.Query(r.Context(), query, userID)
+	fills, err := h.service.GetTrades(r.Context(), userID, r.URL.Query().Get("symbol"))
 	if err != nil {
-		http.Error(w, "Failed to fetch orderbook", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch trades", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var orders []Order
-	for rows.Next() {
-		var o Order
-		if err := rows.Scan(&o.ID, &o.Symbol, &o.Side, &o.Price, &o.Quantity, &o.Status, &o.CreatedAt); err != nil {
-			http.Error(w, "Failed to scan orders", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fills)
+}
+
+func (h *Handler) GetDepth(w http.ResponseWriter, r *http.Request) {
+	levels := 0
+	if v := r.URL.Query().Get("levels"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, ErrInvalidDepth.Error(), http.StatusBadRequest)
 			return
 		}
-		orders = append(orders, o)
+		levels = n
 	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, "Failed to process orders", http.StatusInternalServerError)
+	depth, err := h.service.GetDepth(chi.URLParam(r, "symbol"), levels)
+	if err != nil {
+		writeOrderError(w, err, "Failed to fetch market depth")
 		return
 	}
 
-	// Get PNL
-	pnlQuery := `
-		SELECT 
-			COALESCE(SUM(unrealized_pnl), 0) as unrealized,
-			COALESCE(SUM(realized_pnl), 0) as realized,
-			COALESCE(SUM(total_pnl), 0) as total
-		FROM positions
-		WHERE user_id = ?
-	`
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(depth)
+}
 
-	var pnl PNL
-	err = h.db.QueryRow(r.Context(), pnlQuery, userID).Scan(
-		&pnl.Unrealized,
-		&pnl.Realized,
-		&pnl.Total,
-	)
+func (h *Handler) GetAuction(w http.ResponseWriter, r *http.Request) {
+	auction, err := h.service.GetAuction(chi.URLParam(r, "symbol"))
 	if err != nil {
-		http.Error(w, "Failed to fetch PNL", http.StatusInternalServerError)
+		writeOrderError(w, err, "Failed to fetch auction state")
 		return
 	}
 
-	response := OrderbookResponse{
-		Orders: orders,
-		PNL:    pnl,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auction)
+}
+
+func (h *Handler) GetMarketHours(w http.ResponseWriter, r *http.Request) {
+	hours, err := h.service.GetMarketHours(chi.URLParam(r, "symbol"))
+	if err != nil {
+		writeOrderError(w, err, "Failed to fetch market hours")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(hours)
+}
+
+// writeOrderError maps service errors to HTTP responses, falling back to a
+// 500 with the given message for unexpected errors
+func writeOrderError(w http.ResponseWriter, err error, message string) {
+	var rejection *risk.RejectionError
+	status := orderErrorStatus(err)
+	switch {
+	case errors.As(err, &rejection):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(RiskRejection{
+			Error:   "order rejected by risk checks",
+			Reasons: rejection.Reasons,
+		})
+	case status == http.StatusInternalServerError:
+		http.Error(w, message, status)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// orderErrorStatus returns the HTTP status for a service error
+func orderErrorStatus(err error) int {
+	var rejection *risk.RejectionError
+	switch {
+	case errors.As(err, &rejection), errors.Is(err, positions.ErrBorrowUnavailable), errors.Is(err, ErrInsufficientCash):
+		return http.StatusUnprocessableEntity
+	case isValidationError(err):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrOrderNotFound), errors.Is(err, ErrPositionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrOrderNotOpen), errors.Is(err, ErrClientOrderIDReused), errors.Is(err, ErrIllegalTransition):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func isValidationError(err error) bool {
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
 }
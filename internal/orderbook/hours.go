@@ -0,0 +1,180 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"brokerapp/internal/calendar"
+)
+
+// WithCalendar enforces the trading hours of cal: orders placed while their
+// market is closed are queued until it opens or rejected if they cannot wait.
+// Without a calendar every symbol trades around the clock.
+func WithCalendar(cal *calendar.Calendar) Option {
+	return func(s *Service) {
+		s.calendar = cal
+	}
+}
+
+// tradable reports whether an order may trade on x at now: during regular
+// hours, or in the pre- and post-market sessions too if it is extended
+func tradable(x *calendar.Exchange, extended bool, now time.Time) bool {
+	session := x.Session(now)
+	return session == calendar.Regular || (extended && session.Extended())
+}
+
+// checkHours decides whether an order placed at now can go on the book or
+// has to be queued until its market opens. Orders that cannot wait, and
+// bracket and OCO groups, are rejected while the market is closed.
+func (s *Service) checkHours(symbol string, req *CreateOrderRequest, now time.Time) (bool, error) {
+	x := s.calendar.Exchange(symbol)
+	if x == nil || tradable(x, req.ExtendedHours, now) {
+		return false, nil
+	}
+	if !callable(req) || req.TakeProfit != nil || req.StopLoss != nil {
+		return false, ErrMarketClosed
+	}
+	return true, nil
+}
+
+// queueOrder stores an order that waits for its market to open
+func (s *Service) queueOrder(ctx context.Context, order *Order, now time.Time) error {
+	if err := s.repo.CreateOrder(ctx, order); err != nil {
+		return err
+	}
+
+	opens := s.calendar.Exchange(order.Symbol).NextOpen(now, order.ExtendedHours)
+	exec := &Execution{Orders: []OrderUpdate{{
+		OrderID: order.ID,
+		Status:  StatusQueued,
+		Reason:  fmt.Sprintf("market closed, queued until %s", opens.UTC().Format(time.RFC3339)),
+	}}}
+	if err := s.repo.SaveExecution(ctx, exec); err != nil {
+		log.Printf("Error queueing order %d: %v", order.ID, err)
+		return err
+	}
+	order.Status = StatusQueued
+	return nil
+}
+
+// ReleaseQueuedOrders puts every queued order whose market has opened on the
+// book, in the order they were placed. An order that cannot be released is
+// logged and left queued for the next run without holding up the others.
+func (s *Service) ReleaseQueuedOrders(ctx context.Context) error {
+	orders, err := s.repo.GetQueuedOrders(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	released, failed := 0, 0
+	for i := range orders {
+		o := &orders[i]
+		if x := s.calendar.Exchange(o.Symbol); x != nil && !tradable(x, o.ExtendedHours, now) {
+			continue
+		}
+		ok, err := s.releaseQueued(ctx, o.ID, o.Symbol)
+		if err != nil {
+			log.Printf("Error releasing queued order %d: %v", o.ID, err)
+			failed++
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	if released > 0 {
+		log.Printf("Released %d queued order(s) at the open", released)
+	}
+	if failed > 0 {
+		log.Printf("Could not release %d queued order(s), retrying on the next run", failed)
+	}
+	return nil
+}
+
+// releaseQueued puts a queued order on the book. The order is read again with
+// its symbol locked, and is skipped if it was cancelled or changed status
+// since it was listed. It is read on every attempt, as an order whose user
+// cannot pay for what it trades is cancelled like any other.
+func (s *Service) releaseQueued(ctx context.Context, orderID int64, symbol string) (bool, error) {
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	exec, err := s.change(ctx, symbol, nil, func() (*Execution, error) {
+		o, err := s.repo.GetOrder(ctx, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if o.Status != StatusQueued {
+			return nil, nil
+		}
+
+		eo := toEngineOrder(o)
+		if isStopType(o.Type) && stopReached(o.Side, o.StopPrice, s.engine.LastPrice(o.Symbol)) {
+			// the market opened through the stop
+			eo.Triggered = true
+		}
+
+		res, err := s.engine.Submit(eo)
+		if err != nil {
+			log.Printf("Engine rejected queued order %d: %v", o.ID, err)
+			return &Execution{Orders: []OrderUpdate{{OrderID: o.ID, Status: StatusRejected, Reason: err.Error()}}}, nil
+		}
+		exec := buildExecution(res)
+		s.runGroups(exec, touched(res))
+		return exec, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return exec != nil, nil
+}
+
+// RunQueueReleaser calls ReleaseQueuedOrders every interval until ctx is cancelled
+func (s *Service) RunQueueReleaser(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReleaseQueuedOrders(ctx); err != nil {
+				log.Printf("Error releasing queued orders: %v", err)
+			}
+		}
+	}
+}
+
+// GetMarketHours returns the session symbol's exchange is in and when it next
+// opens and closes for regular trading. Symbols the calendar does not cover
+// trade around the clock.
+func (s *Service) GetMarketHours(symbol string) (*MarketHoursResponse, error) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+
+	resp := &MarketHoursResponse{Symbol: symbol, Session: string(calendar.Regular)}
+	x := s.calendar.Exchange(symbol)
+	if x == nil {
+		return resp, nil
+	}
+
+	now := s.now()
+	resp.Exchange = x.Name
+	resp.Session = string(x.Session(now))
+	if opens := x.NextOpen(now, false); !opens.IsZero() {
+		opens = opens.UTC()
+		resp.NextOpen = &opens
+	}
+	if closes := x.NextClose(now, false); !closes.IsZero() {
+		closes = closes.UTC()
+		resp.NextClose = &closes
+	}
+	return resp, nil
+}
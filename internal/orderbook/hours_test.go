@@ -0,0 +1,155 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"brokerapp/internal/calendar"
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testCalendar(t *testing.T) *calendar.Calendar {
+	cal, err := calendar.Parse([]byte(`{
+		"exchanges": [{
+			"name": "XNYS",
+			"timezone": "America/New_York",
+			"symbols": ["AAPL"],
+			"pre_market": "04:00-09:30",
+			"regular": "09:30-16:00",
+			"post_market": "16:00-20:00"
+		}]
+	}`))
+	assert.NoError(t, err)
+	return cal
+}
+
+func TestPlaceOrderWhileMarketClosed(t *testing.T) {
+	// 07:00 in New York, during the pre-market session
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithCalendar(testCalendar(t)),
+	)
+	expectCreateOrder(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("SaveExecution", ctx, &Execution{Orders: []OrderUpdate{
+		{OrderID: 1, Status: StatusQueued, Reason: "market closed, queued until 2024-02-20T14:30:00Z"},
+	}}).Return(nil).Once()
+
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusQueued, result.Order.Status)
+	assert.Equal(t, time.Date(2024, 2, 20, 21, 0, 0, 0, time.UTC), *result.Order.ExpiresAt)
+	assert.Empty(t, service.engine.Depth("AAPL", 5).Bids)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10})
+	assert.Equal(t, ErrMarketClosed, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10, ExtendedHours: true})
+	assert.Equal(t, ErrInvalidExtendedHours, err)
+
+	// extended hours orders trade in the pre-market and last until the post-market ends
+	expectAccepted(mockRepo)
+	result, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 149, Quantity: 5, ExtendedHours: true})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAccepted, result.Order.Status)
+	assert.Equal(t, time.Date(2024, 2, 21, 1, 0, 0, 0, time.UTC), *result.Order.ExpiresAt)
+
+	// symbols the calendar does not list trade around the clock
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "MSFT", Side: "buy", Price: 300, Quantity: 5})
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAmendQueuedIcebergOrder(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithCalendar(testCalendar(t)),
+	)
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].Status == StatusQueued
+	})).Return(nil).Once()
+
+	ctx := context.Background()
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, DisplayQuantity: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusQueued, result.Order.Status)
+	mockRepo.On("GetOrder", ctx, int64(1)).Return(&result.Order, nil)
+
+	// the order would be refused by the engine once the market opens
+	quantity := 3
+	_, err = service.AmendOrder(ctx, 1, 1, &AmendOrderRequest{Quantity: &quantity})
+	assert.Equal(t, ErrInvalidDisplayQuantity, err)
+
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Amendments: []Amendment{{OrderID: 1, Price: 150, Quantity: 4}}}).Return(nil).Once()
+	quantity = 4
+	amended, err := service.AmendOrder(ctx, 1, 1, &AmendOrderRequest{Quantity: &quantity})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, amended.Order.Quantity)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReleaseQueuedOrders(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithCalendar(testCalendar(t)),
+	)
+
+	ctx := context.Background()
+	queued := Order{ID: 3, UserID: 1, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 10, TimeInForce: TIFDay, Status: StatusQueued}
+	cancelled := Order{ID: 4, UserID: 1, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 151, Quantity: 5, TimeInForce: TIFDay, Status: StatusQueued}
+	unreadable := Order{ID: 5, UserID: 1, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 152, Quantity: 5, TimeInForce: TIFDay, Status: StatusQueued}
+	mockRepo.On("GetQueuedOrders", ctx).Return([]Order{cancelled, unreadable, queued}, nil)
+
+	// still in the pre-market session
+	assert.NoError(t, service.ReleaseQueuedOrders(ctx))
+	mockRepo.AssertNotCalled(t, "SaveExecution", mock.Anything, mock.Anything)
+
+	now = time.Date(2024, 2, 20, 14, 30, 0, 0, time.UTC)
+	// the first was cancelled after it was listed and the second cannot be
+	// read; neither stops the third going on the book
+	cancelledNow := cancelled
+	cancelledNow.Status = StatusCancelled
+	mockRepo.On("GetOrder", ctx, int64(4)).Return(&cancelledNow, nil)
+	mockRepo.On("GetOrder", ctx, int64(5)).Return(nil, errors.New("connection lost"))
+	mockRepo.On("GetOrder", ctx, int64(3)).Return(&queued, nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].OrderID == 3 && exec.Orders[0].Status == StatusAccepted
+	})).Return(nil).Once()
+
+	assert.NoError(t, service.ReleaseQueuedOrders(ctx))
+	assert.Equal(t, []matching.Level{{Price: 150, Quantity: 10, Orders: 1}}, service.engine.Depth("AAPL", 5).Bids)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetMarketHours(t *testing.T) {
+	now := time.Date(2024, 2, 17, 15, 0, 0, 0, time.UTC) // a Saturday
+	service := NewService(new(MockRepository), matching.NewEngine(),
+		WithClock(func() time.Time { return now }),
+		WithCalendar(testCalendar(t)),
+	)
+
+	hours, err := service.GetMarketHours("aapl")
+	assert.NoError(t, err)
+	assert.Equal(t, "XNYS", hours.Exchange)
+	assert.Equal(t, string(calendar.Closed), hours.Session)
+	assert.Equal(t, time.Date(2024, 2, 19, 14, 30, 0, 0, time.UTC), *hours.NextOpen)
+	assert.Equal(t, time.Date(2024, 2, 19, 21, 0, 0, 0, time.UTC), *hours.NextClose)
+
+	hours, err = service.GetMarketHours("MSFT")
+	assert.NoError(t, err)
+	assert.Equal(t, string(calendar.Regular), hours.Session)
+	assert.Nil(t, hours.NextOpen)
+}
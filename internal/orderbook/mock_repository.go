@@ -0,0 +1,177 @@
+package orderbook
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) CreateOrder(ctx context.Context, order *Order) error {
+	args := m.Called(ctx, order)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOrder(ctx context.Context, id int64) (*Order, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Order), args.Error(1)
+}
+
+func (m *MockRepository) GetOrderByClientOrderID(ctx context.Context, userID int64, clientOrderID string) (*Order, error) {
+	args := m.Called(ctx, userID, clientOrderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Order), args.Error(1)
+}
+
+func (m *MockRepository) GetOrderResponse(ctx context.Context, orderID int64) (*OrderResult, error) {
+	args := m.Called(ctx, orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*OrderResult), args.Error(1)
+}
+
+func (m *MockRepository) SaveOrderResponse(ctx context.Context, result *OrderResult) error {
+	args := m.Called(ctx, result)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOpenOrders(ctx context.Context, userID int64, symbol, side string) ([]Order, error) {
+	args := m.Called(ctx, userID, symbol, side)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetTradesByUser(ctx context.Context, userID int64, symbol string) ([]Trade, error) {
+	args := m.Called(ctx, userID, symbol)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Trade), args.Error(1)
+}
+
+func (m *MockRepository) SaveExecution(ctx context.Context, exec *Execution) error {
+	args := m.Called(ctx, exec)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ExpireQueuedOrders(ctx context.Context, before time.Time, skipSymbols []string) (int64, error) {
+	args := m.Called(ctx, before, skipSymbols)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) GetOrderEvents(ctx context.Context, orderID int64) ([]OrderEvent, error) {
+	args := m.Called(ctx, orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]OrderEvent), args.Error(1)
+}
+
+func (m *MockRepository) ListOrders(ctx context.Context, userID int64, filter *OrderFilter) ([]Order, error) {
+	args := m.Called(ctx, userID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetBookOrders(ctx context.Context) ([]Order, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) CountBookOrders(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) GetOrdersChangedSince(ctx context.Context, eventID int64) ([]Order, error) {
+	args := m.Called(ctx, eventID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetOpenGroupOrders(ctx context.Context) ([]Order, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetQueuedOrders(ctx context.Context) ([]Order, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetNewOrders(ctx context.Context) ([]Order, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Order), args.Error(1)
+}
+
+func (m *MockRepository) GetLastPrices(ctx context.Context, afterTradeID int64) (map[string]float64, error) {
+	args := m.Called(ctx, afterTradeID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]float64), args.Error(1)
+}
+
+func (m *MockRepository) GetMarkPrices(ctx context.Context) (map[string]float64, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]float64), args.Error(1)
+}
+
+func (m *MockRepository) GetSelfTradePrevention(ctx context.Context, userID int64) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRepository) SetSelfTradePrevention(ctx context.Context, userID int64, mode string) error {
+	args := m.Called(ctx, userID, mode)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetHighWaterMarks(ctx context.Context) (int64, int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockRepository) SaveSnapshot(ctx context.Context, snap *BookSnapshot) error {
+	args := m.Called(ctx, snap)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetLatestSnapshot(ctx context.Context) (*BookSnapshot, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*BookSnapshot), args.Error(1)
+}
@@ -0,0 +1,498 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"brokerapp/internal/matching"
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
+)
+
+const (
+	TypeMarket    = "market"
+	TypeLimit     = "limit"
+	TypeStop      = "stop"
+	TypeStopLimit = "stop_limit"
+)
+
+const (
+	TIFDay               = "DAY"
+	TIFGoodTillCancel    = "GTC"
+	TIFImmediateOrCancel = "IOC"
+	TIFFillOrKill        = "FOK"
+	TIFGoodTillDate      = "GTD"
+)
+
+const (
+	GroupBracket = "bracket"
+	GroupOCO     = "oco"
+)
+
+// Self-trade prevention modes, set per account. They decide what happens when
+// an order would trade against a resting order of the same user.
+const (
+	STPNone         = "none"
+	STPCancelNewest = "cancel_newest"
+	STPCancelOldest = "cancel_oldest"
+	STPCancelBoth   = "cancel_both"
+	STPDecrement    = "decrement"
+)
+
+// defaultSelfTradePrevention applies to users without an account
+const defaultSelfTradePrevention = STPCancelNewest
+
+// Order statuses. See transitions for how an order moves between them.
+const (
+	StatusNew             = "new"    // stored, but not yet on the book
+	StatusQueued          = "queued" // waiting for the market to open
+	StatusAccepted        = "accepted"
+	StatusPartiallyFilled = "partially_filled"
+	StatusFilled          = "filled"
+	StatusCancelled       = "cancelled"
+	StatusRejected        = "rejected"
+	StatusExpired         = "expired"
+)
+
+// IsOpenStatus reports whether an order in this status is still working
+func IsOpenStatus(status string) bool {
+	return status == StatusNew || status == StatusQueued || status == StatusAccepted || status == StatusPartiallyFilled
+}
+
+type Order struct {
+	ID                  int64      `json:"id"`
+	UserID              int64      `json:"-"`
+	ClientOrderID       string     `json:"client_order_id,omitempty"`
+	ParentOrderID       int64      `json:"parent_order_id,omitempty"`
+	GroupType           string     `json:"group_type,omitempty"` // "bracket" or "oco"
+	Symbol              string     `json:"symbol"`
+	Side                string     `json:"side"` // "buy" or "sell"
+	Type                string     `json:"type"` // "market", "limit", "stop" or "stop_limit"
+	Price               float64    `json:"price"`
+	StopPrice           float64    `json:"stop_price,omitempty"`
+	TrailAmount         float64    `json:"trail_amount,omitempty"`
+	TrailPercent        float64    `json:"trail_percent,omitempty"`
+	Quantity            int        `json:"quantity"`
+	DisplayQuantity     int        `json:"display_quantity,omitempty"` // iceberg slice shown on the book
+	FilledQuantity      int        `json:"filled_quantity"`
+	AvgFillPrice        float64    `json:"avg_fill_price"`
+	TimeInForce         string     `json:"time_in_force"`
+	ExtendedHours       bool       `json:"extended_hours,omitempty"` // may trade in pre- and post-market sessions
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	SelfTradePrevention string     `json:"self_trade_prevention,omitempty"` // the account's mode when placed
+	Status              string     `json:"status"`
+	TriggeredAt         *time.Time `json:"triggered_at,omitempty"`
+	CreatedAt           string     `json:"created_at"`
+
+	// BookSeq is the order's place in the engine's time priority, used to
+	// rebuild the book after a restart
+	BookSeq uint64 `json:"-"`
+}
+
+type CreateOrderRequest struct {
+	ClientOrderID string     `json:"client_order_id"` // optional, unique per user
+	Symbol        string     `json:"symbol"`
+	Side          string     `json:"side"` // "buy" or "sell"
+	Type          string     `json:"type"` // defaults to "limit"
+	Price         float64    `json:"price"`
+	StopPrice     float64    `json:"stop_price"`
+	Quantity      int        `json:"quantity"`
+	TimeInForce   string     `json:"time_in_force"` // defaults to "DAY"
+	ExpiresAt     *time.Time `json:"expires_at"`    // required for "GTD"
+
+	// DisplayQuantity makes a resting limit order an iceberg that shows only
+	// this much of its size at a time
+	DisplayQuantity int `json:"display_quantity"`
+
+	// TrailAmount or TrailPercent make a stop order trail the market price.
+	// A trailing stop order may leave out stop_price to start from the latest
+	// market price.
+	TrailAmount  float64 `json:"trail_amount"`
+	TrailPercent float64 `json:"trail_percent"`
+
+	// ExtendedHours lets a limit order trade in the pre- and post-market
+	// sessions instead of waiting for regular hours
+	ExtendedHours bool `json:"extended_hours"`
+
+	// TakeProfit and StopLoss turn the order into the entry of a bracket
+	TakeProfit *TakeProfitRequest `json:"take_profit"`
+	StopLoss   *StopLossRequest   `json:"stop_loss"`
+}
+
+// TakeProfitRequest is the limit order that closes a bracket at a profit
+type TakeProfitRequest struct {
+	Price float64 `json:"price"`
+}
+
+// StopLossRequest is the stop order that closes a bracket at a loss. Giving a
+// price makes it a stop_limit order.
+type StopLossRequest struct {
+	StopPrice float64 `json:"stop_price"`
+	Price     float64 `json:"price"`
+}
+
+// OCORequest places two orders for the same symbol and side of which only one
+// may trade
+type OCORequest struct {
+	Orders []CreateOrderRequest `json:"orders"`
+}
+
+// BatchOrderRequest places several independent orders in one request
+type BatchOrderRequest struct {
+	Orders []CreateOrderRequest `json:"orders"`
+}
+
+// maxBatchOrders is the most orders a single batch may contain
+const maxBatchOrders = 100
+
+// CancelAllRequest optionally restricts cancel-all to one symbol and/or side
+type CancelAllRequest struct {
+	Symbol string `json:"symbol"`
+	Side   string `json:"side"`
+}
+
+// maxClientOrderIDLength matches the orders.client_order_id column
+const maxClientOrderIDLength = 64
+
+// AmendOrderRequest changes the limit price and/or total quantity of an open order
+type AmendOrderRequest struct {
+	Price    *float64 `json:"price"`
+	Quantity *int     `json:"quantity"`
+}
+
+type Trade struct {
+	ID          int64     `json:"id"`
+	Symbol      string    `json:"symbol"`
+	Price       float64   `json:"price"`
+	Quantity    int       `json:"quantity"`
+	BuyOrderID  int64     `json:"buy_order_id"`
+	SellOrderID int64     `json:"sell_order_id"`
+	BuyUserID   int64     `json:"-"`
+	SellUserID  int64     `json:"-"`
+	TakerSide   string    `json:"taker_side"`
+	ExecutedAt  time.Time `json:"executed_at"`
+}
+
+// Fill is one side of a trade as seen by the user who owns the order
+type Fill struct {
+	TradeID             int64     `json:"trade_id"`
+	OrderID             int64     `json:"order_id"`
+	CounterpartyOrderID int64     `json:"counterparty_order_id"`
+	Symbol              string    `json:"symbol"`
+	Side                string    `json:"side"`
+	Price               float64   `json:"price"`
+	Quantity            int       `json:"quantity"`
+	Liquidity           string    `json:"liquidity"` // "maker" or "taker"
+	ExecutedAt          time.Time `json:"executed_at"`
+}
+
+// OrderUpdate is the new state of an order after it went through the matching engine
+type OrderUpdate struct {
+	OrderID        int64
+	Status         string
+	FilledQuantity int
+	AvgFillPrice   float64
+	Triggered      bool
+	Reason         string // recorded on the order's event
+	BookSeq        uint64 // zero keeps the stored value
+	Quantity       int    // zero keeps the stored value
+}
+
+// BookSnapshot is a copy of the engine's books. EventID and TradeID are the
+// last order event and trade that had been written when it was taken; anything
+// after them is read back from the orders and trades tables on recovery.
+type BookSnapshot struct {
+	ID        int64
+	EventID   int64
+	TradeID   int64
+	Books     []matching.BookState
+	CreatedAt time.Time
+}
+
+// OrderEvent is one step in an order's history. Events that do not change the
+// status, such as an amendment or a further partial fill, have the same
+// FromStatus and ToStatus.
+type OrderEvent struct {
+	ID             int64     `json:"id"`
+	OrderID        int64     `json:"order_id"`
+	FromStatus     string    `json:"from_status,omitempty"`
+	ToStatus       string    `json:"to_status"`
+	FilledQuantity int       `json:"filled_quantity"`
+	Reason         string    `json:"reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Amendment is a change of price and quantity requested by the order's owner
+type Amendment struct {
+	OrderID  int64
+	Price    float64
+	Quantity int
+}
+
+// SelfTradeSetting is an account's self-trade prevention mode
+type SelfTradeSetting struct {
+	Mode string `json:"mode"`
+}
+
+// Trail is a move of a trailing stop order's stop (and, for stop_limit
+// orders, limit) price after the market moved
+type Trail struct {
+	OrderID   int64
+	StopPrice float64
+	Price     float64
+}
+
+// Execution is everything that has to be persisted after a single order was matched
+type Execution struct {
+	Amendments []Amendment
+	Trails     []Trail
+	Orders     []OrderUpdate
+	Trades     []Trade
+}
+
+// OrderResult is returned to the client after an order has been placed
+type OrderResult struct {
+	Order    Order   `json:"order"`
+	Children []Order `json:"children,omitempty"`
+	Trades   []Trade `json:"trades"`
+
+	// Replayed is set when the result is the stored response of an earlier
+	// request with the same client order ID
+	Replayed bool `json:"-"`
+}
+
+// BatchItem is the outcome of one order of a batch: either Result or Err is set
+type BatchItem struct {
+	Result *OrderResult
+	Err    error
+}
+
+// BatchOrderResult reports one order of a batch. Status is the HTTP status the
+// order would have got if it had been placed on its own.
+type BatchOrderResult struct {
+	Index   int           `json:"index"`
+	Status  int           `json:"status"`
+	Result  *OrderResult  `json:"result,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Reasons []risk.Reason `json:"reasons,omitempty"`
+}
+
+type BatchOrderResponse struct {
+	Results []BatchOrderResult `json:"results"`
+}
+
+// CancelFailure is an order cancel-all could not cancel
+type CancelFailure struct {
+	OrderID int64  `json:"order_id"`
+	Error   string `json:"error"`
+}
+
+type CancelAllResponse struct {
+	Cancelled []Order         `json:"cancelled"`
+	Failed    []CancelFailure `json:"failed,omitempty"`
+}
+
+// ClosedPosition reports the order placed to close a position. Quantity is
+// the position it closes, negative for a short, and Cancelled lists the open
+// orders that were cancelled so they would not close it too.
+type ClosedPosition struct {
+	Symbol    string       `json:"symbol"`
+	Quantity  int          `json:"quantity"`
+	Order     *OrderResult `json:"order"`
+	Cancelled []Order      `json:"cancelled"`
+}
+
+// CloseFailure is a position close-all could not close
+type CloseFailure struct {
+	Symbol   string        `json:"symbol"`
+	Quantity int           `json:"quantity"`
+	Error    string        `json:"error"`
+	Reasons  []risk.Reason `json:"reasons,omitempty"`
+}
+
+type CloseAllResponse struct {
+	Closed []ClosedPosition `json:"closed"`
+	Failed []CloseFailure   `json:"failed,omitempty"`
+}
+
+// RiskRejection is the response body for an order the risk checks rejected
+type RiskRejection struct {
+	Error   string        `json:"error"`
+	Reasons []risk.Reason `json:"reasons"`
+}
+
+// DepthLevel is the total resting size and number of orders at one price
+type DepthLevel struct {
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	Orders   int     `json:"orders"`
+}
+
+// DepthResponse is the aggregated (L2) order book for a symbol, best prices first
+type DepthResponse struct {
+	Symbol    string       `json:"symbol"`
+	Bids      []DepthLevel `json:"bids"`
+	Asks      []DepthLevel `json:"asks"`
+	LastPrice float64      `json:"last_price"`
+}
+
+// MarketHoursResponse is where a symbol's exchange is in its trading day.
+// NextOpen is when orders next trade in regular hours (now if they already
+// do) and NextClose is when regular hours next end.
+type MarketHoursResponse struct {
+	Symbol    string     `json:"symbol"`
+	Exchange  string     `json:"exchange,omitempty"`
+	Session   string     `json:"session"`
+	NextOpen  *time.Time `json:"next_open,omitempty"`
+	NextClose *time.Time `json:"next_close,omitempty"`
+}
+
+// Trading phases of a symbol
+const (
+	PhaseContinuous = "continuous"
+	PhaseCall       = "call"
+)
+
+// AuctionResponse is the state of a symbol's call auction. While the call
+// phase lasts the indicative price and volume are what would trade if the
+// book uncrossed now.
+type AuctionResponse struct {
+	Symbol           string     `json:"symbol"`
+	Phase            string     `json:"phase"`
+	UncrossAt        *time.Time `json:"uncross_at,omitempty"`
+	IndicativePrice  float64    `json:"indicative_price"`
+	IndicativeVolume int        `json:"indicative_volume"`
+}
+
+// OrderbookQuery holds the query parameters of the orderbook listing as given
+// by the client
+type OrderbookQuery struct {
+	Status string // comma separated statuses
+	Symbol string
+	Side   string
+	From   string // RFC 3339 time or date, inclusive
+	To     string // RFC 3339 time (exclusive) or date (inclusive)
+	Sort   string // "newest" (default) or "oldest"
+	Cursor string
+	Limit  int
+}
+
+// OrderFilter selects a page of a user's orders
+type OrderFilter struct {
+	Statuses []string
+	Symbol   string
+	Side     string
+	From     *time.Time
+	To       *time.Time
+	Oldest   bool         // sort oldest first instead of newest first
+	After    *orderCursor // continue after this order
+	Limit    int
+}
+
+const (
+	defaultOrderPageSize = 100
+	maxOrderPageSize     = 500
+)
+
+type OrderbookResponse struct {
+	Orders     []Order           `json:"orders"`
+	PNL        positions.Summary `json:"pnl"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+var (
+	ErrInvalidSide            = errors.New("invalid side: must be 'buy' or 'sell'")
+	ErrInvalidType            = errors.New("invalid type: must be 'market', 'limit', 'stop' or 'stop_limit'")
+	ErrInvalidSymbol          = errors.New("invalid symbol")
+	ErrInvalidPrice           = errors.New("invalid price: must be greater than zero")
+	ErrUnexpectedPrice        = errors.New("price is not allowed for market and stop orders")
+	ErrInvalidStopPrice       = errors.New("invalid stop_price: must be greater than zero")
+	ErrInvalidDisplayQuantity = errors.New("invalid display_quantity: must be between 1 and quantity, and only limit and stop_limit orders that can rest on the book may hide part of their size")
+	ErrUnexpectedStopPrice    = errors.New("stop_price is only allowed for stop and stop_limit orders")
+	ErrStopPriceReached       = errors.New("stop_price has already been reached by the last traded price")
+	ErrMarketClosed           = errors.New("the market is closed: only orders that can wait for it to open are queued, not market, IOC or FOK orders or bracket and OCO groups")
+	ErrInvalidExtendedHours   = errors.New("extended_hours is only allowed for limit orders")
+	ErrAuctionOrder           = errors.New("market, IOC and FOK orders cannot be placed while the symbol is in a call auction")
+	ErrInvalidTrail           = errors.New("invalid trail: give either trail_amount or a trail_percent below 100 on a stop or stop_limit order")
+	ErrNoMarketPrice          = errors.New("there is no market price to start the trailing stop from: give a stop_price")
+	ErrInvalidQuantity        = errors.New("invalid quantity: must be greater than zero")
+	ErrInvalidTimeInForce     = errors.New("invalid time_in_force: must be 'DAY', 'GTC', 'IOC', 'FOK' or 'GTD'")
+	ErrMarketTimeInForce      = errors.New("market orders only support 'DAY', 'IOC' or 'FOK' time_in_force")
+	ErrInvalidExpiresAt       = errors.New("invalid expires_at: GTD orders need an expiry in the future")
+	ErrUnexpectedExpiresAt    = errors.New("expires_at is only allowed for GTD orders")
+	ErrEmptyAmendment         = errors.New("nothing to amend: provide price and/or quantity")
+	ErrAmendQuantity          = errors.New("invalid quantity: must be greater than the quantity already filled")
+	ErrInvalidDepth           = errors.New("invalid levels: must be a positive number within the configured maximum")
+	ErrInvalidClientOrderID   = errors.New("invalid client_order_id: must be at most 64 characters")
+	ErrClientOrderIDMismatch  = errors.New("client_order_id and Idempotency-Key header must match when both are given")
+	ErrClientOrderIDReused    = errors.New("client_order_id has already been used for a different order")
+	ErrDuplicateClientOrderID = errors.New("duplicate client_order_id")
+	ErrInvalidTakeProfit      = errors.New("invalid take_profit: price must be above the entry and stop loss for buy orders and below them for sell orders")
+	ErrInvalidStopLoss        = errors.New("invalid stop_loss: stop_price must be below the entry for buy orders and above it for sell orders")
+	ErrInvalidOCO             = errors.New("invalid OCO group: needs exactly two orders for the same symbol and side, without brackets")
+	ErrInvalidSelfTrade       = errors.New("invalid mode: must be 'none', 'cancel_newest', 'cancel_oldest', 'cancel_both' or 'decrement'")
+	ErrInvalidBatch           = errors.New("invalid batch: must contain between 1 and 100 orders")
+	ErrInvalidStatusFilter    = errors.New("invalid status: must be a comma separated list of order statuses")
+	ErrInvalidDateRange       = errors.New("invalid from/to: must be RFC 3339 times or YYYY-MM-DD dates with from before to")
+	ErrInvalidSort            = errors.New("invalid sort: must be 'newest' or 'oldest'")
+	ErrInvalidLimit           = errors.New("invalid limit: must be between 1 and 500")
+	ErrInvalidCursor          = errors.New("invalid cursor")
+	ErrIllegalTransition      = errors.New("illegal order status transition")
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrOrderNotOpen           = errors.New("order is no longer open")
+	ErrPositionNotFound       = errors.New("no open position in this symbol")
+	ErrInsufficientCash       = errors.New("not enough cash to settle the trade")
+)
+
+// UnderfundedError is returned when a trade cannot be settled because the
+// user of its buy order does not have the cash to pay for it
+type UnderfundedError struct {
+	OrderID int64
+}
+
+func (e *UnderfundedError) Error() string {
+	return fmt.Sprintf("order %d: %v", e.OrderID, ErrInsufficientCash)
+}
+
+func (e *UnderfundedError) Unwrap() error {
+	return ErrInsufficientCash
+}
+
+// validationErrors are caused by the request itself and are reported back as 400 Bad Request
+var validationErrors = []error{
+	ErrInvalidSide,
+	ErrInvalidType,
+	ErrInvalidSymbol,
+	ErrInvalidPrice,
+	ErrUnexpectedPrice,
+	ErrInvalidStopPrice,
+	ErrInvalidDisplayQuantity,
+	ErrUnexpectedStopPrice,
+	ErrStopPriceReached,
+	ErrMarketClosed,
+	ErrInvalidExtendedHours,
+	ErrAuctionOrder,
+	ErrInvalidTrail,
+	ErrNoMarketPrice,
+	ErrInvalidQuantity,
+	ErrInvalidTimeInForce,
+	ErrMarketTimeInForce,
+	ErrInvalidExpiresAt,
+	ErrUnexpectedExpiresAt,
+	ErrEmptyAmendment,
+	ErrAmendQuantity,
+	ErrInvalidDepth,
+	ErrInvalidClientOrderID,
+	ErrClientOrderIDMismatch,
+	ErrInvalidTakeProfit,
+	ErrInvalidStopLoss,
+	ErrInvalidOCO,
+	ErrInvalidBatch,
+	ErrInvalidSelfTrade,
+	ErrInvalidStatusFilter,
+	ErrInvalidDateRange,
+	ErrInvalidSort,
+	ErrInvalidLimit,
+	ErrInvalidCursor,
+}
@@ -0,0 +1,895 @@
+package orderbook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"brokerapp/internal/db"
+	"brokerapp/internal/positions"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlErrDuplicateEntry is returned by MySQL when a unique key is violated
+const mysqlErrDuplicateEntry = 1062
+
+// orderColumns is the column list read by scanOrder
+const orderColumns = `id, user_id, client_order_id, parent_order_id, group_type, symbol, side, type, price, stop_price, trail_amount, trail_percent, quantity, display_quantity, filled_quantity, avg_fill_price, time_in_force, extended_hours, self_trade_prevention, expires_at, status, triggered_at, created_at, book_seq`
+
+type MySQLRepository struct {
+	db     *db.MySQL
+	ledger *positions.Ledger
+}
+
+// NewMySQLRepository returns a repository that settles trades against cash
+// and moves positions through ledger
+func NewMySQLRepository(db *db.MySQL, ledger *positions.Ledger) *MySQLRepository {
+	return &MySQLRepository{db: db, ledger: ledger}
+}
+
+// CreateOrder stores a new order along with the self-trade prevention mode
+// its user's account has at the time
+func (r *MySQLRepository) CreateOrder(ctx context.Context, order *Order) error {
+	query := `
+		INSERT INTO orders (user_id, client_order_id, parent_order_id, group_type, symbol, side, type, price, stop_price, trail_amount, trail_percent, quantity, display_quantity, time_in_force, extended_hours, self_trade_prevention, expires_at, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var id int64
+	mode := defaultSelfTradePrevention
+	err := r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `SELECT self_trade_prevention FROM accounts WHERE user_id = ?`, order.UserID).Scan(&mode)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query,
+			order.UserID,
+			nullString(order.ClientOrderID),
+			sql.NullInt64{Int64: order.ParentOrderID, Valid: order.ParentOrderID != 0},
+			nullString(order.GroupType),
+			order.Symbol,
+			order.Side,
+			order.Type,
+			order.Price,
+			nullFloat(order.StopPrice),
+			nullFloat(order.TrailAmount),
+			nullFloat(order.TrailPercent),
+			order.Quantity,
+			sql.NullInt64{Int64: int64(order.DisplayQuantity), Valid: order.DisplayQuantity != 0},
+			order.TimeInForce,
+			order.ExtendedHours,
+			mode,
+			order.ExpiresAt,
+			order.Status,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, id, "", order.Status, 0, "")
+	})
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return ErrDuplicateClientOrderID
+		}
+		return err
+	}
+
+	order.ID = id
+	order.SelfTradePrevention = mode
+	order.CreatedAt = now.Format(time.RFC3339)
+	return nil
+}
+
+// GetSelfTradePrevention returns the self-trade prevention mode of the user's account
+func (r *MySQLRepository) GetSelfTradePrevention(ctx context.Context, userID int64) (string, error) {
+	mode := defaultSelfTradePrevention
+	err := r.db.QueryRow(ctx, `SELECT self_trade_prevention FROM accounts WHERE user_id = ?`, userID).Scan(&mode)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return mode, nil
+}
+
+// SetSelfTradePrevention changes the self-trade prevention mode of the user's
+// account, opening an account for them if they do not have one yet. Orders
+// already placed keep the mode they were placed with.
+func (r *MySQLRepository) SetSelfTradePrevention(ctx context.Context, userID int64, mode string) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO accounts (user_id, self_trade_prevention) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE self_trade_prevention = VALUES(self_trade_prevention)
+	`, userID, mode)
+	return err
+}
+
+func (r *MySQLRepository) GetOrder(ctx context.Context, id int64) (*Order, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE id = ?
+	`
+
+	order, err := scanOrder(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// GetOrderByClientOrderID returns the order the user placed with the given
+// client order ID
+func (r *MySQLRepository) GetOrderByClientOrderID(ctx context.Context, userID int64, clientOrderID string) (*Order, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE user_id = ? AND client_order_id = ?
+	`
+
+	order, err := scanOrder(r.db.QueryRow(ctx, query, userID, clientOrderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// GetOrderResponse returns the response stored for an order by
+// SaveOrderResponse, or nil if none was stored
+func (r *MySQLRepository) GetOrderResponse(ctx context.Context, orderID int64) (*OrderResult, error) {
+	var body []byte
+	err := r.db.QueryRow(ctx, `SELECT response FROM order_responses WHERE order_id = ?`, orderID).Scan(&body)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var result OrderResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SaveOrderResponse stores the response sent for a newly placed order so that
+// a retry with the same client order ID can be answered with it
+func (r *MySQLRepository) SaveOrderResponse(ctx context.Context, result *OrderResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, `INSERT INTO order_responses (order_id, response) VALUES (?, ?)`, result.Order.ID, body)
+	return err
+}
+
+// GetOpenOrders returns the user's open orders, only those in symbol and on
+// side when they are set, oldest first
+func (r *MySQLRepository) GetOpenOrders(ctx context.Context, userID int64, symbol, side string) ([]Order, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE user_id = ? AND status IN ('new', 'queued', 'accepted', 'partially_filled')
+	`
+	args := []interface{}{userID}
+	if symbol != "" {
+		query += ` AND symbol = ?`
+		args = append(args, symbol)
+	}
+	if side != "" {
+		query += ` AND side = ?`
+		args = append(args, side)
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// ListOrders returns up to filter.Limit of the user's orders matching filter,
+// sorted by creation time and then ID
+func (r *MySQLRepository) ListOrders(ctx context.Context, userID int64, filter *OrderFilter) ([]Order, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE user_id = ?
+	`
+	args := []interface{}{userID}
+	if len(filter.Statuses) > 0 {
+		query += ` AND status IN (?` + strings.Repeat(`, ?`, len(filter.Statuses)-1) + `)`
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if filter.Symbol != "" {
+		query += ` AND symbol = ?`
+		args = append(args, filter.Symbol)
+	}
+	if filter.Side != "" {
+		query += ` AND side = ?`
+		args = append(args, filter.Side)
+	}
+	if filter.From != nil {
+		query += ` AND created_at >= ?`
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		query += ` AND created_at < ?`
+		args = append(args, *filter.To)
+	}
+
+	cmp, dir := "<", "DESC"
+	if filter.Oldest {
+		cmp, dir = ">", "ASC"
+	}
+	if c := filter.After; c != nil {
+		query += ` AND (created_at ` + cmp + ` ? OR (created_at = ? AND id ` + cmp + ` ?))`
+		args = append(args, c.CreatedAt, c.CreatedAt, c.ID)
+	}
+	query += ` ORDER BY created_at ` + dir + `, id ` + dir + ` LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// GetTradesByUser returns every trade on either side of which the user took
+// part, optionally restricted to one symbol
+func (r *MySQLRepository) GetTradesByUser(ctx context.Context, userID int64, symbol string) ([]Trade, error) {
+	query := `
+		SELECT id, symbol, price, quantity, buy_order_id, sell_order_id, buy_user_id, sell_user_id, taker_side, executed_at
+		FROM trades
+		WHERE (buy_user_id = ? OR sell_user_id = ?)
+	`
+	args := []interface{}{userID, userID}
+	if symbol != "" {
+		query += ` AND symbol = ?`
+		args = append(args, symbol)
+	}
+	query += ` ORDER BY executed_at DESC, id DESC`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []Trade
+	for rows.Next() {
+		var t Trade
+		err := rows.Scan(
+			&t.ID,
+			&t.Symbol,
+			&t.Price,
+			&t.Quantity,
+			&t.BuyOrderID,
+			&t.SellOrderID,
+			&t.BuyUserID,
+			&t.SellUserID,
+			&t.TakerSide,
+			&t.ExecutedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return trades, nil
+}
+
+// SaveExecution records the trades produced by a match and the resulting order
+// statuses in a single transaction, settling each trade against both users'
+// cash and positions. Every status change is checked against the order state
+// machine and written to order_events; an illegal one rolls the whole
+// execution back, as does a trade whose buyer is short of the cash for it,
+// with an UnderfundedError naming the buy order.
+func (r *MySQLRepository) SaveExecution(ctx context.Context, exec *Execution) error {
+	if len(exec.Amendments) == 0 && len(exec.Trails) == 0 && len(exec.Orders) == 0 && len(exec.Trades) == 0 {
+		return nil
+	}
+
+	return r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, a := range exec.Amendments {
+			cur, err := lockOrderState(ctx, tx, a.OrderID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE orders SET price = ?, quantity = ? WHERE id = ?`, a.Price, a.Quantity, a.OrderID); err != nil {
+				return err
+			}
+			reason := fmt.Sprintf("amended to price %g quantity %d", a.Price, a.Quantity)
+			if err := insertEvent(ctx, tx, a.OrderID, cur.status, cur.status, cur.filled, reason); err != nil {
+				return err
+			}
+		}
+
+		// trailing stop moves get an event too, so recovering from a
+		// snapshot picks up the latest stop price
+		for _, t := range exec.Trails {
+			cur, err := lockOrderState(ctx, tx, t.OrderID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE orders SET stop_price = ?, price = ? WHERE id = ?`, t.StopPrice, t.Price, t.OrderID); err != nil {
+				return err
+			}
+			reason := fmt.Sprintf("stop price trailed to %g", t.StopPrice)
+			if err := insertEvent(ctx, tx, t.OrderID, cur.status, cur.status, cur.filled, reason); err != nil {
+				return err
+			}
+		}
+
+		for _, u := range exec.Orders {
+			cur, err := lockOrderState(ctx, tx, u.OrderID)
+			if err != nil {
+				return err
+			}
+
+			reason := u.Reason
+			triggered := u.Triggered && !cur.triggered
+			if triggered && reason == "" {
+				reason = "stop price reached"
+			}
+			// an update that changes nothing the history shows, such as an
+			// amendment's new place in the queue, is written without an event
+			resized := u.Quantity != 0 && u.Quantity != cur.quantity
+			changed := u.Status != cur.status || u.FilledQuantity != cur.filled || triggered || resized
+			if changed {
+				if err := checkTransition(u.OrderID, cur.status, u.Status); err != nil {
+					return err
+				}
+			}
+
+			query := `
+				UPDATE orders
+				SET status = ?,
+					filled_quantity = ?,
+					avg_fill_price = ?,
+					triggered_at = CASE WHEN ? AND triggered_at IS NULL THEN CURRENT_TIMESTAMP ELSE triggered_at END,
+					book_seq = COALESCE(?, book_seq),
+					quantity = COALESCE(?, quantity)
+				WHERE id = ?
+			`
+			_, err = tx.ExecContext(ctx, query,
+				u.Status,
+				u.FilledQuantity,
+				nullFloat(u.AvgFillPrice),
+				u.Triggered,
+				sql.NullInt64{Int64: int64(u.BookSeq), Valid: u.BookSeq != 0},
+				sql.NullInt64{Int64: int64(u.Quantity), Valid: u.Quantity != 0},
+				u.OrderID,
+			)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+			if err := insertEvent(ctx, tx, u.OrderID, cur.status, u.Status, u.FilledQuantity, reason); err != nil {
+				return err
+			}
+		}
+
+		for i := range exec.Trades {
+			t := &exec.Trades[i]
+			result, err := tx.ExecContext(ctx, `
+				INSERT INTO trades (symbol, price, quantity, buy_order_id, sell_order_id, buy_user_id, sell_user_id, taker_side, executed_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, t.Symbol, t.Price, t.Quantity, t.BuyOrderID, t.SellOrderID, t.BuyUserID, t.SellUserID, t.TakerSide, t.ExecutedAt)
+			if err != nil {
+				return err
+			}
+
+			if t.ID, err = result.LastInsertId(); err != nil {
+				return err
+			}
+
+			// settle the trade against both users' cash
+			notional := t.Price * float64(t.Quantity)
+			if err := adjustCash(ctx, tx, t.BuyUserID, -notional); err != nil {
+				if errors.Is(err, ErrInsufficientCash) {
+					return &UnderfundedError{OrderID: t.BuyOrderID}
+				}
+				return err
+			}
+			if err := adjustCash(ctx, tx, t.SellUserID, notional); err != nil {
+				return err
+			}
+
+			// and move both users' positions
+			if err := r.ledger.Record(ctx, tx, t.BuyUserID, t.Symbol, t.Quantity, t.Price, t.ExecutedAt); err != nil {
+				return err
+			}
+			if err := r.ledger.Record(ctx, tx, t.SellUserID, t.Symbol, -t.Quantity, t.Price, t.ExecutedAt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// adjustCash adds amount to the user's cash balance, opening an account for
+// them if they do not have one yet. Taking out more than the balance holds
+// fails with ErrInsufficientCash.
+func adjustCash(ctx context.Context, tx *sql.Tx, userID int64, amount float64) error {
+	if amount >= 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO accounts (user_id, cash_balance) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE cash_balance = cash_balance + VALUES(cash_balance)
+		`, userID, amount)
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE accounts SET cash_balance = cash_balance + ?
+		WHERE user_id = ? AND cash_balance + ? >= 0
+	`, amount, userID, amount)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInsufficientCash
+	}
+	return nil
+}
+
+// ExpireQueuedOrders marks queued orders whose expiry time is at or before the
+// given time as expired and returns how many were changed. Orders in
+// skipSymbols are left alone. Orders on the book are only expired by the
+// engine, as expires_at is stored to the second and the book may still hold
+// an order the column says has expired.
+func (r *MySQLRepository) ExpireQueuedOrders(ctx context.Context, before time.Time, skipSymbols []string) (int64, error) {
+	query := `
+		SELECT id, status, filled_quantity
+		FROM orders
+		WHERE status = 'queued' AND expires_at IS NOT NULL AND expires_at <= ?
+	`
+	args := []interface{}{before.UTC()}
+	if len(skipSymbols) > 0 {
+		query += ` AND symbol NOT IN (?` + strings.Repeat(`, ?`, len(skipSymbols)-1) + `)`
+		for _, symbol := range skipSymbols {
+			args = append(args, symbol)
+		}
+	}
+	query += ` FOR UPDATE`
+
+	var expired int64
+	err := r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		type open struct {
+			id     int64
+			status string
+			filled int
+		}
+		var orders []open
+		for rows.Next() {
+			var o open
+			if err := rows.Scan(&o.id, &o.status, &o.filled); err != nil {
+				rows.Close()
+				return err
+			}
+			orders = append(orders, o)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, o := range orders {
+			if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = ? WHERE id = ?`, StatusExpired, o.id); err != nil {
+				return err
+			}
+			if err := insertEvent(ctx, tx, o.id, o.status, StatusExpired, o.filled, "expiry time reached"); err != nil {
+				return err
+			}
+		}
+		expired = int64(len(orders))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// GetOrderEvents returns the status history of an order, oldest first
+func (r *MySQLRepository) GetOrderEvents(ctx context.Context, orderID int64) ([]OrderEvent, error) {
+	query := `
+		SELECT id, order_id, from_status, to_status, filled_quantity, reason, created_at
+		FROM order_events
+		WHERE order_id = ?
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []OrderEvent
+	for rows.Next() {
+		var e OrderEvent
+		var from, reason sql.NullString
+		if err := rows.Scan(&e.ID, &e.OrderID, &from, &e.ToStatus, &e.FilledQuantity, &reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.FromStatus = from.String
+		e.Reason = reason.String
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetBookOrders returns every order the engine holds, accepted or partially
+// filled, in time priority
+func (r *MySQLRepository) GetBookOrders(ctx context.Context) ([]Order, error) {
+	return r.queryOrders(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE status IN ('accepted', 'partially_filled')
+		ORDER BY book_seq, id
+	`)
+}
+
+// CountBookOrders returns how many orders the engine should hold
+func (r *MySQLRepository) CountBookOrders(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM orders WHERE status IN ('accepted', 'partially_filled')`).Scan(&n)
+	return n, err
+}
+
+// GetOrdersChangedSince returns the current state of every order with an
+// event after eventID
+func (r *MySQLRepository) GetOrdersChangedSince(ctx context.Context, eventID int64) ([]Order, error) {
+	return r.queryOrders(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE id IN (SELECT order_id FROM order_events WHERE id > ?)
+		ORDER BY book_seq, id
+	`, eventID)
+}
+
+// GetOpenGroupOrders returns the open orders that belong to a bracket or OCO group
+func (r *MySQLRepository) GetOpenGroupOrders(ctx context.Context) ([]Order, error) {
+	return r.queryOrders(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE group_type IS NOT NULL AND status IN ('new', 'accepted', 'partially_filled')
+		ORDER BY id
+	`)
+}
+
+// GetQueuedOrders returns the orders waiting for the market to open, oldest first
+func (r *MySQLRepository) GetQueuedOrders(ctx context.Context) ([]Order, error) {
+	return r.queryOrders(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE status = 'queued'
+		ORDER BY id
+	`)
+}
+
+// GetNewOrders returns the orders outside any group that were stored but
+// never accepted by the engine
+func (r *MySQLRepository) GetNewOrders(ctx context.Context) ([]Order, error) {
+	return r.queryOrders(ctx, `
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE group_type IS NULL AND status = 'new'
+		ORDER BY id
+	`)
+}
+
+// GetLastPrices returns the price of the latest trade per symbol among the
+// trades after afterTradeID
+func (r *MySQLRepository) GetLastPrices(ctx context.Context, afterTradeID int64) (map[string]float64, error) {
+	return r.queryPrices(ctx, `
+		SELECT t.symbol, t.price
+		FROM trades t
+		JOIN (SELECT symbol, MAX(id) AS id FROM trades WHERE id > ? GROUP BY symbol) latest ON latest.id = t.id
+	`, afterTradeID)
+}
+
+// GetMarkPrices returns the most recently updated current_price of each
+// symbol held in positions
+func (r *MySQLRepository) GetMarkPrices(ctx context.Context) (map[string]float64, error) {
+	return r.queryPrices(ctx, `
+		SELECT p.symbol, p.current_price
+		FROM positions p
+		JOIN (SELECT symbol, MAX(updated_at) AS updated_at FROM positions GROUP BY symbol) latest
+			ON latest.symbol = p.symbol AND latest.updated_at = p.updated_at
+		WHERE p.current_price > 0
+	`)
+}
+
+// queryPrices runs a query selecting a symbol and a price per row
+func (r *MySQLRepository) queryPrices(ctx context.Context, query string, args ...interface{}) (map[string]float64, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := make(map[string]float64)
+	for rows.Next() {
+		var symbol string
+		var price float64
+		if err := rows.Scan(&symbol, &price); err != nil {
+			return nil, err
+		}
+		prices[symbol] = price
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// GetHighWaterMarks returns the IDs of the latest order event and trade
+func (r *MySQLRepository) GetHighWaterMarks(ctx context.Context) (eventID, tradeID int64, err error) {
+	err = r.db.QueryRow(ctx, `
+		SELECT
+			(SELECT COALESCE(MAX(id), 0) FROM order_events),
+			(SELECT COALESCE(MAX(id), 0) FROM trades)
+	`).Scan(&eventID, &tradeID)
+	return eventID, tradeID, err
+}
+
+// SaveSnapshot stores a snapshot of the books and drops the older ones
+func (r *MySQLRepository) SaveSnapshot(ctx context.Context, snap *BookSnapshot) error {
+	books, err := json.Marshal(snap.Books)
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO book_snapshots (event_id, trade_id, books)
+			VALUES (?, ?, ?)
+		`, snap.EventID, snap.TradeID, books)
+		if err != nil {
+			return err
+		}
+
+		if snap.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM book_snapshots WHERE id < ?`, snap.ID)
+		return err
+	})
+}
+
+// GetLatestSnapshot returns the most recent snapshot, or nil if none was taken
+func (r *MySQLRepository) GetLatestSnapshot(ctx context.Context) (*BookSnapshot, error) {
+	var snap BookSnapshot
+	var books []byte
+	err := r.db.QueryRow(ctx, `
+		SELECT id, event_id, trade_id, books, created_at
+		FROM book_snapshots
+		ORDER BY id DESC
+		LIMIT 1
+	`).Scan(&snap.ID, &snap.EventID, &snap.TradeID, &books, &snap.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(books, &snap.Books); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// queryOrders runs a query selecting orderColumns
+func (r *MySQLRepository) queryOrders(ctx context.Context, query string, args ...interface{}) ([]Order, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// orderState is the part of a stored order the state machine looks at
+type orderState struct {
+	status    string
+	quantity  int
+	filled    int
+	triggered bool
+}
+
+// lockOrderState reads the current state of an order and locks its row until
+// the transaction ends
+func lockOrderState(ctx context.Context, tx *sql.Tx, orderID int64) (*orderState, error) {
+	var st orderState
+	err := tx.QueryRowContext(ctx, `
+		SELECT status, quantity, filled_quantity, triggered_at IS NOT NULL
+		FROM orders
+		WHERE id = ?
+		FOR UPDATE
+	`, orderID).Scan(&st.status, &st.quantity, &st.filled, &st.triggered)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	return &st, nil
+}
+
+// insertEvent records a status change of an order. An empty from status marks
+// the order being created.
+func insertEvent(ctx context.Context, tx *sql.Tx, orderID int64, from, to string, filled int, reason string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_events (order_id, from_status, to_status, filled_quantity, reason)
+		VALUES (?, ?, ?, ?, ?)
+	`, orderID, nullString(from), to, filled, nullString(reason))
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanOrder reads a row selected with orderColumns
+func scanOrder(row rowScanner) (*Order, error) {
+	var o Order
+	var clientOrderID, groupType sql.NullString
+	var parentOrderID sql.NullInt64
+	var stopPrice, trailAmount, trailPercent, avgFillPrice sql.NullFloat64
+	var expiresAt, triggeredAt sql.NullTime
+	var displayQuantity, bookSeq sql.NullInt64
+	var selfTrade sql.NullString
+	err := row.Scan(
+		&o.ID,
+		&o.UserID,
+		&clientOrderID,
+		&parentOrderID,
+		&groupType,
+		&o.Symbol,
+		&o.Side,
+		&o.Type,
+		&o.Price,
+		&stopPrice,
+		&trailAmount,
+		&trailPercent,
+		&o.Quantity,
+		&displayQuantity,
+		&o.FilledQuantity,
+		&avgFillPrice,
+		&o.TimeInForce,
+		&o.ExtendedHours,
+		&selfTrade,
+		&expiresAt,
+		&o.Status,
+		&triggeredAt,
+		&o.CreatedAt,
+		&bookSeq,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	o.ClientOrderID = clientOrderID.String
+	o.ParentOrderID = parentOrderID.Int64
+	o.GroupType = groupType.String
+	o.StopPrice = stopPrice.Float64
+	o.TrailAmount = trailAmount.Float64
+	o.TrailPercent = trailPercent.Float64
+	o.DisplayQuantity = int(displayQuantity.Int64)
+	o.SelfTradePrevention = selfTrade.String
+	o.AvgFillPrice = avgFillPrice.Float64
+	if expiresAt.Valid {
+		o.ExpiresAt = &expiresAt.Time
+	}
+	if triggeredAt.Valid {
+		o.TriggeredAt = &triggeredAt.Time
+	}
+	o.BookSeq = uint64(bookSeq.Int64)
+	return &o, nil
+}
+
+// nullString stores an empty string as NULL for optional columns
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+// nullFloat stores zero as NULL for optional price columns
+func nullFloat(v float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: v != 0}
+}
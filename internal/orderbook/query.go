@@ -0,0 +1,129 @@
+package orderbook
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// orderCursor points at the last order of a page. Clients only see it encoded
+// and hand it back unchanged to get the next page.
+type orderCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int64     `json:"id"`
+	Oldest    bool      `json:"o,omitempty"`
+}
+
+func (c *orderCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*orderCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c orderCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorAfter returns the cursor that continues after o
+func cursorAfter(o *Order, oldest bool) (*orderCursor, error) {
+	createdAt, err := time.Parse(time.RFC3339, o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &orderCursor{CreatedAt: createdAt, ID: o.ID, Oldest: oldest}, nil
+}
+
+// parseOrderbookQuery validates the listing parameters and turns them into a filter
+func parseOrderbookQuery(q *OrderbookQuery) (*OrderFilter, error) {
+	f := &OrderFilter{
+		Symbol: strings.ToUpper(strings.TrimSpace(q.Symbol)),
+		Side:   strings.ToLower(strings.TrimSpace(q.Side)),
+		Limit:  q.Limit,
+	}
+
+	if q.Status != "" {
+		for _, status := range strings.Split(q.Status, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if !isStatus(status) {
+				return nil, ErrInvalidStatusFilter
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	if f.Side != "" && f.Side != "buy" && f.Side != "sell" {
+		return nil, ErrInvalidSide
+	}
+
+	var err error
+	if f.From, err = parseBound(q.From, false); err != nil {
+		return nil, err
+	}
+	if f.To, err = parseBound(q.To, true); err != nil {
+		return nil, err
+	}
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return nil, ErrInvalidDateRange
+	}
+
+	switch q.Sort {
+	case "", "newest":
+	case "oldest":
+		f.Oldest = true
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	switch {
+	case f.Limit == 0:
+		f.Limit = defaultOrderPageSize
+	case f.Limit < 0 || f.Limit > maxOrderPageSize:
+		return nil, ErrInvalidLimit
+	}
+
+	if q.Cursor != "" {
+		if f.After, err = decodeCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+		// a cursor only makes sense in the direction it was issued for
+		if f.After.Oldest != f.Oldest {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return f, nil
+}
+
+// parseBound reads a from/to parameter. A date for the upper bound includes
+// the whole day.
+func parseBound(v string, upper bool) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		t = t.UTC()
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, ErrInvalidDateRange
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func isStatus(status string) bool {
+	switch status {
+	case StatusNew, StatusQueued, StatusAccepted, StatusPartiallyFilled, StatusFilled, StatusCancelled, StatusRejected, StatusExpired:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,337 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"brokerapp/internal/matching"
+)
+
+// reasonNotAccepted is recorded on orders that were stored but had not reached
+// the engine when the service stopped
+const reasonNotAccepted = "not accepted before the service restarted"
+
+// Ready reports whether the book has been rebuilt and orders can be accepted
+func (s *Service) Ready() bool {
+	return s.ready.Load()
+}
+
+// Recover rebuilds the in-memory book from MySQL. It starts from the latest
+// snapshot if there is one and applies whatever changed after it, falling back
+// to loading every open order if the result does not match the orders table.
+// Bracket and OCO groups are rebuilt and orders the engine never accepted are
+// rejected. The service is ready once this returns without error.
+func (s *Service) Recover(ctx context.Context) error {
+	start := time.Now()
+
+	snap, err := s.repo.GetLatestSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	restored := false
+	if snap != nil {
+		if err := s.restoreFromSnapshot(ctx, snap); err != nil {
+			log.Printf("Snapshot %d could not be used, loading every open order: %v", snap.ID, err)
+		} else {
+			restored = true
+		}
+	}
+	if !restored {
+		if err := s.restoreFromOrders(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.recoverGroups(ctx); err != nil {
+		return err
+	}
+
+	s.ready.Store(true)
+	log.Printf("Order book recovered in %v", time.Since(start))
+	return nil
+}
+
+func (s *Service) restoreFromSnapshot(ctx context.Context, snap *BookSnapshot) error {
+	changed, err := s.repo.GetOrdersChangedSince(ctx, snap.EventID)
+	if err != nil {
+		return err
+	}
+	prices, err := s.repo.GetLastPrices(ctx, snap.TradeID)
+	if err != nil {
+		return err
+	}
+
+	books := make(map[string]*matching.BookState, len(snap.Books))
+	for i := range snap.Books {
+		books[snap.Books[i].Symbol] = &snap.Books[i]
+	}
+	for i := range changed {
+		o := &changed[i]
+		if b, ok := books[o.Symbol]; ok {
+			b.Orders = withoutOrder(b.Orders, o.ID)
+			b.Stops = withoutOrder(b.Stops, o.ID)
+		}
+		if onBook(o) {
+			addToBook(books, o)
+		}
+	}
+	setLastPrices(books, prices)
+
+	if err := s.restore(ctx, books); err != nil {
+		return err
+	}
+	log.Printf("Restored order book from snapshot %d taken at %s, %d order(s) changed since", snap.ID, snap.CreatedAt.Format(time.RFC3339), len(changed))
+	return nil
+}
+
+func (s *Service) restoreFromOrders(ctx context.Context) error {
+	orders, err := s.repo.GetBookOrders(ctx)
+	if err != nil {
+		return err
+	}
+	prices, err := s.repo.GetLastPrices(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	books := make(map[string]*matching.BookState)
+	for i := range orders {
+		addToBook(books, &orders[i])
+	}
+	setLastPrices(books, prices)
+
+	if err := s.restore(ctx, books); err != nil {
+		return err
+	}
+	log.Printf("Restored order book from %d open order(s)", len(orders))
+	return nil
+}
+
+// restore loads books into the engine and checks that it now holds exactly
+// as many orders as the orders table says are working
+func (s *Service) restore(ctx context.Context, books map[string]*matching.BookState) error {
+	states := make([]matching.BookState, 0, len(books))
+	for _, b := range books {
+		states = append(states, *b)
+	}
+	if err := s.engine.Restore(states); err != nil {
+		return err
+	}
+
+	want, err := s.repo.CountBookOrders(ctx)
+	if err != nil {
+		return err
+	}
+	got := 0
+	for _, b := range s.engine.Snapshot() {
+		got += len(b.Orders) + len(b.Stops)
+	}
+	if got != want {
+		s.engine.Restore(nil)
+		return fmt.Errorf("book holds %d order(s) but %d are open", got, want)
+	}
+	return nil
+}
+
+// recoverGroups tracks the bracket and OCO groups of open orders again and
+// settles orders that were stored but never reached the engine
+func (s *Service) recoverGroups(ctx context.Context) error {
+	orders, err := s.repo.GetOpenGroupOrders(ctx)
+	if err != nil {
+		return err
+	}
+	stale, err := s.repo.GetNewOrders(ctx)
+	if err != nil {
+		return err
+	}
+
+	// members of a group share the ID of its first order
+	var roots []int64
+	members := make(map[int64][]*Order)
+	for i := range orders {
+		o := &orders[i]
+		root := o.ID
+		if o.ParentOrderID != 0 {
+			root = o.ParentOrderID
+		}
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], o)
+	}
+
+	exec := &Execution{}
+	for _, root := range roots {
+		if err := s.recoverGroup(ctx, exec, root, members[root]); err != nil {
+			return err
+		}
+	}
+	for _, o := range stale {
+		exec.Orders = append(exec.Orders, OrderUpdate{OrderID: o.ID, Status: StatusRejected, Reason: reasonNotAccepted})
+	}
+
+	if err := s.repo.SaveExecution(ctx, exec); err != nil {
+		return err
+	}
+	if len(roots) > 0 || len(stale) > 0 {
+		log.Printf("Recovered %d order group(s), rejected %d order(s) that never reached the book", len(roots), len(stale))
+	}
+	return nil
+}
+
+// recoverGroup rebuilds one group from its open members
+func (s *Service) recoverGroup(ctx context.Context, exec *Execution, root int64, members []*Order) error {
+	g := &orderGroup{symbol: members[0].Symbol}
+	var entry *Order
+	for _, o := range members {
+		switch {
+		case o.ID == root && o.GroupType == GroupBracket:
+			entry = o
+		case o.Status == StatusNew:
+			g.held = append(g.held, o)
+		default:
+			g.legs = append(g.legs, o)
+			g.traded += o.FilledQuantity
+		}
+	}
+
+	if entry != nil {
+		if entry.Status == StatusNew {
+			// the entry never reached the engine, so its children cannot either
+			s.rejectUnplaced(exec, members)
+			return nil
+		}
+		g.entryID = entry.ID
+		s.trackGroup(g)
+		return nil
+	}
+
+	if len(g.held) > 0 {
+		if members[0].GroupType == GroupOCO {
+			// the legs were stored but never released
+			s.rejectUnplaced(exec, members)
+			return nil
+		}
+
+		// the bracket entry finished without its children being released
+		parent, err := s.repo.GetOrder(ctx, root)
+		if err != nil {
+			return err
+		}
+		g.entryID = root
+		s.trackGroup(g)
+		s.releaseHeld(exec, g, parent.FilledQuantity)
+		return nil
+	}
+
+	s.trackGroup(g)
+	return nil
+}
+
+// rejectUnplaced rejects the orders that are still new
+func (s *Service) rejectUnplaced(exec *Execution, orders []*Order) {
+	for _, o := range orders {
+		if o.Status != StatusNew {
+			continue
+		}
+		o.Status = StatusRejected
+		exec.Orders = append(exec.Orders, OrderUpdate{OrderID: o.ID, Status: StatusRejected, Reason: reasonNotAccepted})
+	}
+}
+
+// TakeSnapshot stores a copy of the engine's books so the next recovery only
+// has to read what changed after it
+func (s *Service) TakeSnapshot(ctx context.Context) error {
+	snap, err := s.committedSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	return s.repo.SaveSnapshot(ctx, snap)
+}
+
+// committedSnapshot copies the books with every symbol locked. A change to a
+// book is stored before its symbol is unlocked, so the copy holds only what
+// has been committed and the high-water marks read with it cover all of it.
+func (s *Service) committedSnapshot(ctx context.Context) (*BookSnapshot, error) {
+	symbols := s.engine.Symbols()
+	for _, symbol := range symbols {
+		unlock := s.lockSymbol(symbol)
+		defer unlock()
+	}
+
+	eventID, tradeID, err := s.repo.GetHighWaterMarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &BookSnapshot{EventID: eventID, TradeID: tradeID}
+	for _, symbol := range symbols {
+		snap.Books = append(snap.Books, s.engine.BookSnapshot(symbol))
+	}
+	return snap, nil
+}
+
+// RunSnapshotter calls TakeSnapshot every interval until ctx is cancelled
+func (s *Service) RunSnapshotter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.TakeSnapshot(ctx); err != nil {
+				log.Printf("Error taking order book snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// onBook reports whether the engine holds o
+func onBook(o *Order) bool {
+	return o.Status == StatusAccepted || o.Status == StatusPartiallyFilled
+}
+
+// addToBook adds the engine's view of a stored order to its symbol's book
+func addToBook(books map[string]*matching.BookState, o *Order) {
+	b, ok := books[o.Symbol]
+	if !ok {
+		b = &matching.BookState{Symbol: o.Symbol}
+		books[o.Symbol] = b
+	}
+
+	eo := toEngineOrder(o)
+	eo.Remaining = o.Quantity - o.FilledQuantity
+	eo.AvgFillPrice = o.AvgFillPrice
+	eo.Triggered = o.TriggeredAt != nil
+	eo.Seq = o.BookSeq
+	if eo.Type.IsStop() && !eo.Triggered {
+		b.Stops = append(b.Stops, eo)
+	} else {
+		b.Orders = append(b.Orders, eo)
+	}
+}
+
+func setLastPrices(books map[string]*matching.BookState, prices map[string]float64) {
+	for symbol, price := range prices {
+		b, ok := books[symbol]
+		if !ok {
+			b = &matching.BookState{Symbol: symbol}
+			books[symbol] = b
+		}
+		b.LastPrice = price
+	}
+}
+
+func withoutOrder(orders []matching.Order, id int64) []matching.Order {
+	for i := range orders {
+		if orders[i].ID == id {
+			return append(orders[:i], orders[i+1:]...)
+		}
+	}
+	return orders
+}
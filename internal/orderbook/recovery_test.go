@@ -0,0 +1,204 @@
+package orderbook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRecoverFromOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	assert.False(t, service.Ready())
+
+	triggered := time.Now()
+	ctx := context.Background()
+	mockRepo.On("GetLatestSnapshot", ctx).Return(nil, nil)
+	mockRepo.On("GetBookOrders", ctx).Return([]Order{
+		{ID: 4, UserID: 2, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 7},
+		{ID: 2, UserID: 1, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 10, FilledQuantity: 4, AvgFillPrice: 150, TimeInForce: TIFGoodTillCancel, Status: StatusPartiallyFilled, BookSeq: 9},
+		{ID: 5, UserID: 1, Symbol: "AAPL", Side: "sell", Type: TypeStop, StopPrice: 140, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 10},
+		{ID: 6, UserID: 3, Symbol: "AAPL", Side: "sell", Type: TypeStopLimit, StopPrice: 155, Price: 156, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, TriggeredAt: &triggered, BookSeq: 11},
+	}, nil)
+	mockRepo.On("GetLastPrices", ctx, int64(0)).Return(map[string]float64{"AAPL": 150}, nil)
+	mockRepo.On("CountBookOrders", ctx).Return(4, nil)
+	mockRepo.On("GetOpenGroupOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("GetNewOrders", ctx).Return([]Order{{ID: 8, Symbol: "AAPL", Status: StatusNew}}, nil)
+	mockRepo.On("SaveExecution", ctx, &Execution{Orders: []OrderUpdate{
+		{OrderID: 8, Status: StatusRejected, Reason: reasonNotAccepted},
+	}}).Return(nil).Once()
+
+	assert.NoError(t, service.Recover(ctx))
+	assert.True(t, service.Ready())
+
+	depth := service.engine.Depth("AAPL", 5)
+	assert.Equal(t, []matching.Level{{Price: 150, Quantity: 11, Orders: 2}}, depth.Bids)
+	assert.Equal(t, []matching.Level{{Price: 156, Quantity: 5, Orders: 1}}, depth.Asks)
+	assert.Equal(t, 150.0, depth.LastPrice)
+
+	restored, ok := service.engine.Order("AAPL", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 6, restored.Remaining)
+
+	// order 4 kept its place ahead of order 2
+	mockRepo.On("CreateOrder", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(*Order).ID = 20
+	}).Return(nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+	result, err := service.PlaceOrder(ctx, 3, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), result.Trades[0].BuyOrderID)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRecoverFromSnapshot(t *testing.T) {
+	engine := matching.NewEngine()
+	engine.Submit(matching.Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: matching.Buy, Type: matching.Limit, Price: 150, Quantity: 10, TimeInForce: matching.GoodTillCancel})
+	engine.Submit(matching.Order{ID: 2, UserID: 2, Symbol: "AAPL", Side: matching.Buy, Type: matching.Limit, Price: 149, Quantity: 10, TimeInForce: matching.GoodTillCancel})
+	snap := &BookSnapshot{ID: 3, EventID: 40, TradeID: 7, Books: engine.Snapshot()}
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	mockRepo.On("GetLatestSnapshot", ctx).Return(snap, nil)
+	// since the snapshot order 1 was cancelled and order 9 placed
+	mockRepo.On("GetOrdersChangedSince", ctx, int64(40)).Return([]Order{
+		{ID: 1, UserID: 1, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 10, TimeInForce: TIFGoodTillCancel, Status: StatusCancelled, BookSeq: 1},
+		{ID: 9, UserID: 3, Symbol: "MSFT", Side: "sell", Type: TypeLimit, Price: 300, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 3},
+	}, nil)
+	mockRepo.On("GetLastPrices", ctx, int64(7)).Return(map[string]float64{"MSFT": 301}, nil)
+	mockRepo.On("CountBookOrders", ctx).Return(2, nil)
+	mockRepo.On("GetOpenGroupOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("GetNewOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("SaveExecution", ctx, &Execution{}).Return(nil)
+
+	assert.NoError(t, service.Recover(ctx))
+
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.False(t, ok)
+	_, ok = service.engine.Order("AAPL", 2)
+	assert.True(t, ok)
+	_, ok = service.engine.Order("MSFT", 9)
+	assert.True(t, ok)
+	assert.Equal(t, 301.0, service.engine.LastPrice("MSFT"))
+	mockRepo.AssertNotCalled(t, "GetBookOrders", mock.Anything)
+}
+
+func TestRecoverFallsBackWhenSnapshotIsInconsistent(t *testing.T) {
+	engine := matching.NewEngine()
+	engine.Submit(matching.Order{ID: 1, UserID: 1, Symbol: "AAPL", Side: matching.Buy, Type: matching.Limit, Price: 150, Quantity: 10, TimeInForce: matching.GoodTillCancel})
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	mockRepo.On("GetLatestSnapshot", ctx).Return(&BookSnapshot{ID: 1, Books: engine.Snapshot()}, nil)
+	mockRepo.On("GetOrdersChangedSince", ctx, int64(0)).Return([]Order{}, nil)
+	mockRepo.On("GetLastPrices", ctx, int64(0)).Return(map[string]float64{}, nil)
+	mockRepo.On("GetBookOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("CountBookOrders", ctx).Return(0, nil)
+	mockRepo.On("GetOpenGroupOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("GetNewOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("SaveExecution", ctx, &Execution{}).Return(nil)
+
+	assert.NoError(t, service.Recover(ctx))
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.False(t, ok)
+	mockRepo.AssertCalled(t, "GetBookOrders", ctx)
+}
+
+func TestRecoverGroups(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	entry := Order{ID: 1, UserID: 1, GroupType: GroupBracket, Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 10, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 1}
+	tp := Order{ID: 2, UserID: 1, ParentOrderID: 1, GroupType: GroupBracket, Symbol: "AAPL", Side: "sell", Type: TypeLimit, Price: 160, Quantity: 10, TimeInForce: TIFGoodTillCancel, Status: StatusNew}
+	limit := Order{ID: 3, UserID: 1, GroupType: GroupOCO, Symbol: "MSFT", Side: "sell", Type: TypeLimit, Price: 310, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 2}
+	stop := Order{ID: 4, UserID: 1, ParentOrderID: 3, GroupType: GroupOCO, Symbol: "MSFT", Side: "sell", Type: TypeStop, StopPrice: 290, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusAccepted, BookSeq: 3}
+	// an OCO group whose legs were stored but never released
+	unreleased := Order{ID: 5, UserID: 1, GroupType: GroupOCO, Symbol: "MSFT", Side: "buy", Type: TypeLimit, Price: 290, Quantity: 5, TimeInForce: TIFGoodTillCancel, Status: StatusNew}
+
+	mockRepo.On("GetLatestSnapshot", ctx).Return(nil, nil)
+	mockRepo.On("GetBookOrders", ctx).Return([]Order{entry, limit, stop}, nil)
+	mockRepo.On("GetLastPrices", ctx, int64(0)).Return(map[string]float64{}, nil)
+	mockRepo.On("CountBookOrders", ctx).Return(3, nil)
+	mockRepo.On("GetOpenGroupOrders", ctx).Return([]Order{entry, tp, limit, stop, unreleased}, nil)
+	mockRepo.On("GetNewOrders", ctx).Return([]Order{}, nil)
+	mockRepo.On("SaveExecution", ctx, &Execution{Orders: []OrderUpdate{
+		{OrderID: 5, Status: StatusRejected, Reason: reasonNotAccepted},
+	}}).Return(nil).Once()
+
+	assert.NoError(t, service.Recover(ctx))
+	assert.Equal(t, int64(1), service.groupOf(2).entryID)
+	assert.Len(t, service.groupOf(2).held, 1)
+	assert.Same(t, service.groupOf(3), service.groupOf(4))
+	assert.Nil(t, service.groupOf(5))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	mockRepo.On("GetHighWaterMarks", ctx).Return(int64(12), int64(3), nil)
+	mockRepo.On("SaveSnapshot", ctx, mock.MatchedBy(func(snap *BookSnapshot) bool {
+		return snap.EventID == 12 && snap.TradeID == 3 &&
+			len(snap.Books) == 1 && len(snap.Books[0].Orders) == 1 && snap.Books[0].Orders[0].ID == 1
+	})).Return(nil).Once()
+
+	assert.NoError(t, service.TakeSnapshot(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestTakeSnapshotWaitsForPendingSaves(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+
+	saving, release := make(chan struct{}), make(chan struct{})
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) {
+			close(saving)
+			<-release
+		}).
+		Return(nil).Once()
+
+	ctx := context.Background()
+	placed := make(chan error)
+	go func() {
+		_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+		placed <- err
+	}()
+	<-saving
+
+	mockRepo.On("GetHighWaterMarks", ctx).Return(int64(12), int64(3), nil)
+	mockRepo.On("SaveSnapshot", ctx, mock.MatchedBy(func(snap *BookSnapshot) bool {
+		return len(snap.Books) == 1 && len(snap.Books[0].Orders) == 1
+	})).Return(nil).Once()
+
+	taken := make(chan error)
+	go func() { taken <- service.TakeSnapshot(ctx) }()
+	select {
+	case <-taken:
+		t.Fatal("the snapshot was taken while the order was being saved")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-placed)
+	assert.NoError(t, <-taken)
+	mockRepo.AssertExpectations(t)
+}
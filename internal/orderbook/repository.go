@@ -0,0 +1,35 @@
+package orderbook
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	CreateOrder(ctx context.Context, order *Order) error
+	GetOrder(ctx context.Context, id int64) (*Order, error)
+	GetOrderByClientOrderID(ctx context.Context, userID int64, clientOrderID string) (*Order, error)
+	GetOrderResponse(ctx context.Context, orderID int64) (*OrderResult, error)
+	SaveOrderResponse(ctx context.Context, result *OrderResult) error
+	GetOpenOrders(ctx context.Context, userID int64, symbol, side string) ([]Order, error)
+	ListOrders(ctx context.Context, userID int64, filter *OrderFilter) ([]Order, error)
+	GetTradesByUser(ctx context.Context, userID int64, symbol string) ([]Trade, error)
+	SaveExecution(ctx context.Context, exec *Execution) error
+	ExpireQueuedOrders(ctx context.Context, before time.Time, skipSymbols []string) (int64, error)
+	GetQueuedOrders(ctx context.Context) ([]Order, error)
+	GetOrderEvents(ctx context.Context, orderID int64) ([]OrderEvent, error)
+	GetMarkPrices(ctx context.Context) (map[string]float64, error)
+	GetSelfTradePrevention(ctx context.Context, userID int64) (string, error)
+	SetSelfTradePrevention(ctx context.Context, userID int64, mode string) error
+
+	// recovery of the in-memory book after a restart
+	GetBookOrders(ctx context.Context) ([]Order, error)
+	CountBookOrders(ctx context.Context) (int, error)
+	GetOrdersChangedSince(ctx context.Context, eventID int64) ([]Order, error)
+	GetOpenGroupOrders(ctx context.Context) ([]Order, error)
+	GetNewOrders(ctx context.Context) ([]Order, error)
+	GetLastPrices(ctx context.Context, afterTradeID int64) (map[string]float64, error)
+	GetHighWaterMarks(ctx context.Context) (eventID, tradeID int64, err error)
+	SaveSnapshot(ctx context.Context, snap *BookSnapshot) error
+	GetLatestSnapshot(ctx context.Context) (*BookSnapshot, error)
+}
@@ -0,0 +1,174 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+
+	"brokerapp/internal/matching"
+)
+
+// checkpoint records what a change to one symbol may have to undo if it
+// cannot be stored: the orders the engine changes, the order groups the change
+// touches and the symbol's mark
+type checkpoint struct {
+	symbol string
+	book   *matching.Checkpoint
+	groups map[*orderGroup]savedGroup
+	mark   float64
+}
+
+// savedGroup is a group as it was when the change first touched it, with the
+// orders it had and the IDs that mapped to it then. The orders are put back
+// in place, so whoever holds one sees it as it was.
+type savedGroup struct {
+	group  orderGroup
+	orders map[*Order]Order
+	ids    []int64
+}
+
+// checkpoint starts recording a change to symbol. It must be called with the
+// symbol locked, and lasts until the change is saved or the next checkpoint
+// of the symbol is taken.
+func (s *Service) checkpoint(symbol string) *checkpoint {
+	cp := &checkpoint{
+		symbol: symbol,
+		book:   s.engine.Checkpoint(symbol),
+		groups: make(map[*orderGroup]savedGroup),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp.mark = s.marks[symbol]
+	s.undo[symbol] = cp
+	return cp
+}
+
+// touchGroup records g in the checkpoint of its symbol, unless there is none
+// or g is already in it. It must be called before g or the IDs mapped to it
+// change.
+func (s *Service) touchGroup(g *orderGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := s.undo[g.symbol]
+	if cp == nil {
+		return
+	}
+	if _, ok := cp.groups[g]; ok {
+		return
+	}
+
+	saved := savedGroup{group: *g, orders: make(map[*Order]Order)}
+	saved.group.held = slices.Clone(g.held)
+	saved.group.legs = slices.Clone(g.legs)
+	for _, orders := range [][]*Order{g.held, g.legs} {
+		for _, o := range orders {
+			saved.orders[o] = *o
+		}
+	}
+	for _, id := range g.ids() {
+		if s.groups[id] == g {
+			saved.ids = append(saved.ids, id)
+		}
+	}
+	cp.groups[g] = saved
+}
+
+// saveExecution stores exec, the result of changes made to the symbol of cp
+// since it was taken. If it cannot be stored those changes are undone, so
+// the engine never holds orders or fills the database does not. The save is
+// not cut short when ctx is cancelled, since the engine has already changed
+// and only a finished save says whether to keep or undo that.
+func (s *Service) saveExecution(ctx context.Context, cp *checkpoint, exec *Execution) error {
+	err := s.repo.SaveExecution(context.WithoutCancel(ctx), exec)
+	if err != nil {
+		s.rollback(cp)
+		return err
+	}
+
+	s.engine.Release(cp.book)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.undo[cp.symbol] == cp {
+		delete(s.undo, cp.symbol)
+	}
+	return nil
+}
+
+// change makes a change to symbol with run and saves the execution it
+// returns; nil means there is nothing to save. If a trade cannot be settled
+// because the buy order's user is short of cash, the change is undone and,
+// unless the order is one of keep, that order is cancelled and the change
+// made again without it, so one underfunded order cannot hold up every order
+// that meets it. It must be called with the symbol locked.
+func (s *Service) change(ctx context.Context, symbol string, keep []int64, run func() (*Execution, error)) (*Execution, error) {
+	for {
+		cp := s.checkpoint(symbol)
+		exec, err := run()
+		if err != nil || exec == nil {
+			return exec, err
+		}
+
+		err = s.saveExecution(ctx, cp, exec)
+		if err == nil {
+			return exec, nil
+		}
+		var short *UnderfundedError
+		if !errors.As(err, &short) || slices.Contains(keep, short.OrderID) {
+			return nil, err
+		}
+		if dropErr := s.dropUnderfunded(ctx, short.OrderID); dropErr != nil {
+			log.Printf("Error cancelling underfunded order %d: %v", short.OrderID, dropErr)
+			return nil, err
+		}
+	}
+}
+
+// dropUnderfunded cancels an order whose user cannot pay for what it trades.
+// It must be called with the order's symbol locked.
+func (s *Service) dropUnderfunded(ctx context.Context, orderID int64) error {
+	order, err := s.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if !IsOpenStatus(order.Status) {
+		return ErrOrderNotOpen
+	}
+
+	log.Printf("Cancelling order %d of user %d: %v", order.ID, order.UserID, ErrInsufficientCash)
+	return s.cancelOpen(ctx, order, ErrInsufficientCash.Error())
+}
+
+// rollback puts the symbol of cp back to how it was when cp was taken
+func (s *Service) rollback(cp *checkpoint) {
+	if err := s.engine.Rollback(cp.book); err != nil {
+		log.Printf("Error rolling back the book of %s: %v", cp.symbol, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.undo[cp.symbol] == cp {
+		delete(s.undo, cp.symbol)
+	}
+	for g, saved := range cp.groups {
+		for _, id := range append(g.ids(), saved.ids...) {
+			if s.groups[id] == g {
+				delete(s.groups, id)
+			}
+		}
+		*g = saved.group
+		for o, was := range saved.orders {
+			*o = was
+		}
+		for _, id := range saved.ids {
+			s.groups[id] = g
+		}
+	}
+	if cp.mark == 0 {
+		delete(s.marks, cp.symbol)
+	} else {
+		s.marks[cp.symbol] = cp.mark
+	}
+}
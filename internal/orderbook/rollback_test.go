@@ -0,0 +1,267 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"brokerapp/internal/matching"
+	"brokerapp/internal/positions"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPlaceOrderRollsBackWhenSaveFails(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	dbErr := errors.New("deadlock")
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1
+	})).Return(dbErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 2, Status: StatusRejected, Reason: "order could not be saved"},
+	}}).Return(nil).Once()
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 151, Quantity: 4})
+	assert.Equal(t, dbErr, err)
+
+	// the fill never happened, so the resting order is back in full
+	resting, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, resting.Remaining)
+	assert.Equal(t, 0.0, service.engine.LastPrice("AAPL"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortSaleRejectedWhenBorrowRunsOut(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	// another short sale took the last shares to borrow after this one passed
+	// its locate check
+	borrowErr := fmt.Errorf("failed to execute transaction: %w", positions.ErrBorrowUnavailable)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1
+	})).Return(borrowErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 2, Status: StatusRejected, Reason: positions.ErrBorrowUnavailable.Error()},
+	}}).Return(nil).Once()
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.ErrorIs(t, err, positions.ErrBorrowUnavailable)
+	assert.Equal(t, http.StatusUnprocessableEntity, orderErrorStatus(err))
+
+	resting, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, resting.Remaining)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBuyRejectedWhenCashRunsOut(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	// another fill spent the buyer's cash after this order passed its
+	// buying power check
+	cashErr := fmt.Errorf("failed to execute transaction: %w", &UnderfundedError{OrderID: 2})
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1
+	})).Return(cashErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 2, Status: StatusRejected, Reason: ErrInsufficientCash.Error()},
+	}}).Return(nil).Once()
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.ErrorIs(t, err, ErrInsufficientCash)
+	assert.Equal(t, http.StatusUnprocessableEntity, orderErrorStatus(err))
+
+	resting, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, resting.Remaining)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUnderfundedMakerIsCancelledAndOrderMatchedAgain(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	underfunded, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 3, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 149, Quantity: 10})
+	assert.NoError(t, err)
+
+	// the best bid's user spent their cash elsewhere, so it cannot pay for
+	// the sell that meets it
+	stored := underfunded.Order
+	stored.Status = StatusAccepted
+	mockRepo.On("GetOrder", mock.Anything, int64(1)).Return(&stored, nil)
+	trading := func(buyOrderID int64) interface{} {
+		return mock.MatchedBy(func(exec *Execution) bool {
+			return len(exec.Trades) == 1 && exec.Trades[0].BuyOrderID == buyOrderID
+		})
+	}
+	mockRepo.On("SaveExecution", mock.Anything, trading(1)).
+		Return(fmt.Errorf("failed to execute transaction: %w", &UnderfundedError{OrderID: 1})).Once()
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].OrderID == 1 && exec.Orders[0].Status == StatusCancelled &&
+			exec.Orders[0].Reason == ErrInsufficientCash.Error()
+	})).Return(nil).Once()
+	mockRepo.On("SaveExecution", mock.Anything, trading(2)).Return(nil).Once()
+
+	// the underfunded bid is dropped and the sell trades with the next one
+	result, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 149, Quantity: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Trades, 1) {
+		assert.Equal(t, int64(2), result.Trades[0].BuyOrderID)
+	}
+	assert.Equal(t, StatusFilled, result.Order.Status)
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.False(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUnderfundedStopIsCancelledWhenTriggered(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 150))
+	stop, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeStop, TrailAmount: 5, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 160, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+
+	// the stop was valued at 155 but fills at 160, more than its user has
+	stored := stop.Order
+	stored.Status = StatusAccepted
+	mockRepo.On("GetOrder", mock.Anything, int64(1)).Return(&stored, nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1
+	})).Return(fmt.Errorf("failed to execute transaction: %w", &UnderfundedError{OrderID: 1})).Once()
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].OrderID == 1 && exec.Orders[0].Status == StatusCancelled
+	})).Return(nil).Once()
+
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 155))
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.False(t, ok)
+	resting, ok := service.engine.Order("AAPL", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 10, resting.Remaining)
+
+	// later prices no longer run into the stop
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 158))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCancelOrderRollsBackWhenSaveFails(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	dbErr := errors.New("connection lost")
+	mockRepo.On("GetOrder", ctx, int64(1)).Return(&result.Order, nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].Status == StatusCancelled
+	})).Return(dbErr).Once()
+
+	_, err = service.CancelOrder(ctx, 1, 1)
+	assert.Equal(t, dbErr, err)
+
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBracketRollsBackWhenSaveFails(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+
+	dbErr := errors.New("deadlock")
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1
+	})).Return(dbErr).Once()
+	execs := captureExecutions(mockRepo)
+
+	ctx := context.Background()
+	entry, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{
+		Symbol:     "AAPL",
+		Side:       "buy",
+		Price:      150,
+		Quantity:   10,
+		TakeProfit: &TakeProfitRequest{Price: 160},
+		StopLoss:   &StopLossRequest{StopPrice: 140},
+	})
+	assert.NoError(t, err)
+	tp, sl := entry.Children[0], entry.Children[1]
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.Equal(t, dbErr, err)
+
+	// the entry never filled, so its children are still held for it
+	_, ok := service.engine.Order("AAPL", tp.ID)
+	assert.False(t, ok)
+	g := service.groupOf(entry.Order.ID)
+	if assert.NotNil(t, g) {
+		assert.Equal(t, entry.Order.ID, g.entryID)
+		assert.Len(t, g.held, 2)
+		assert.Empty(t, g.legs)
+	}
+
+	_, err = service.PlaceOrder(ctx, 3, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAccepted, statusIn(*execs, tp.ID))
+	assert.Equal(t, StatusAccepted, statusIn(*execs, sl.ID))
+}
+
+func TestSaveExecutionOutlivesCancelledRequest(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Err() == nil
+	}), mock.Anything).Return(nil).Once()
+
+	// the client went away after the order reached the engine
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,55 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+
+	"brokerapp/internal/matching"
+)
+
+// GetSelfTradePrevention returns the self-trade prevention mode of the user's account
+func (s *Service) GetSelfTradePrevention(ctx context.Context, userID int64) (*SelfTradeSetting, error) {
+	mode, err := s.repo.GetSelfTradePrevention(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &SelfTradeSetting{Mode: mode}, nil
+}
+
+// SetSelfTradePrevention changes the self-trade prevention mode of the user's
+// account. It applies to orders placed from then on.
+func (s *Service) SetSelfTradePrevention(ctx context.Context, userID int64, req *SelfTradeSetting) (*SelfTradeSetting, error) {
+	switch req.Mode {
+	case STPNone, STPCancelNewest, STPCancelOldest, STPCancelBoth, STPDecrement:
+	default:
+		return nil, ErrInvalidSelfTrade
+	}
+
+	if err := s.repo.SetSelfTradePrevention(ctx, userID, req.Mode); err != nil {
+		return nil, err
+	}
+	return &SelfTradeSetting{Mode: req.Mode}, nil
+}
+
+// addSelfTrades records on the latest update of every order self-trade
+// prevention cancelled or decremented why it changed
+func addSelfTrades(exec *Execution, selfTrades []matching.SelfTrade) {
+	for _, st := range selfTrades {
+		for i := len(exec.Orders) - 1; i >= 0; i-- {
+			u := &exec.Orders[i]
+			if u.OrderID != st.OrderID {
+				continue
+			}
+			u.Reason = selfTradeReason(st)
+			u.Quantity = st.Quantity
+			break
+		}
+	}
+}
+
+func selfTradeReason(st matching.SelfTrade) string {
+	if st.Quantity > 0 {
+		return fmt.Sprintf("self-trade prevention (%s) against order %d reduced the quantity to %d", st.Mode, st.AgainstID, st.Quantity)
+	}
+	return fmt.Sprintf("self-trade prevention (%s) against order %d", st.Mode, st.AgainstID)
+}
@@ -0,0 +1,86 @@
+package orderbook
+
+import (
+	"context"
+	"testing"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// expectCreateOrderWithMode is expectCreateOrder for users whose accounts
+// have the given self-trade prevention mode
+func expectCreateOrderWithMode(mockRepo *MockRepository, mode string) {
+	var nextID int64
+	mockRepo.On("CreateOrder", mock.Anything, mock.AnythingOfType("*orderbook.Order")).
+		Run(func(args mock.Arguments) {
+			nextID++
+			o := args.Get(1).(*Order)
+			o.ID = nextID
+			o.SelfTradePrevention = mode
+		}).
+		Return(nil)
+}
+
+func TestSelfTradePreventionCancelsOldest(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrderWithMode(mockRepo, STPCancelOldest)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 5})
+	assert.NoError(t, err)
+
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 2, Status: StatusAccepted, BookSeq: 2},
+		{OrderID: 1, Status: StatusCancelled, BookSeq: 1, Reason: "self-trade prevention (cancel_oldest) against order 2"},
+	}}).Return(nil).Once()
+
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 8})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Trades)
+	assert.Equal(t, StatusAccepted, result.Order.Status)
+	assert.Equal(t, STPCancelOldest, result.Order.SelfTradePrevention)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSelfTradePreventionDecrements(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrderWithMode(mockRepo, STPDecrement)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 5})
+	assert.NoError(t, err)
+
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{
+		{OrderID: 2, Status: StatusAccepted, BookSeq: 2},
+		{OrderID: 2, Status: StatusCancelled, BookSeq: 2, Reason: "self-trade prevention (decrement) against order 1"},
+		{OrderID: 1, Status: StatusAccepted, BookSeq: 1, Quantity: 3, Reason: "self-trade prevention (decrement) against order 2 reduced the quantity to 3"},
+	}}).Return(nil).Once()
+
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, result.Order.Status)
+	assert.Equal(t, 0, result.Order.FilledQuantity)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSetSelfTradePrevention(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	mockRepo.On("SetSelfTradePrevention", ctx, int64(1), STPCancelBoth).Return(nil).Once()
+	setting, err := service.SetSelfTradePrevention(ctx, 1, &SelfTradeSetting{Mode: STPCancelBoth})
+	assert.NoError(t, err)
+	assert.Equal(t, STPCancelBoth, setting.Mode)
+
+	_, err = service.SetSelfTradePrevention(ctx, 1, &SelfTradeSetting{Mode: "reject"})
+	assert.Equal(t, ErrInvalidSelfTrade, err)
+	mockRepo.AssertExpectations(t)
+}
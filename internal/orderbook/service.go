@@ -0,0 +1,1164 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"brokerapp/internal/calendar"
+	"brokerapp/internal/matching"
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
+)
+
+type Service struct {
+	repo   Repository
+	engine *matching.Engine
+	now    func() time.Time
+
+	// DAY orders expire at marketClose (offset from midnight) in marketTZ,
+	// unless calendar knows the hours of their exchange
+	marketTZ    *time.Location
+	marketClose time.Duration
+
+	// calendar holds the trading hours orders are placed against; nil
+	// trades around the clock
+	calendar *calendar.Calendar
+
+	depthLevels    int
+	maxDepthLevels int
+
+	// symbolLocks serialises matching and persistence per symbol so the
+	// database sees executions in the same order the engine produced them
+	mu          sync.Mutex
+	symbolLocks map[string]*sync.Mutex
+
+	// clientLocks serialises requests that carry the same client order ID
+	// so that only one of them can place the order
+	clientLocks keyedLocks
+
+	// userLocks serialises the risk checks of one user's orders with the
+	// creation of those orders, so concurrent orders cannot both spend the
+	// same cash or shares
+	userLocks keyedLocks
+
+	risk *risk.Pipeline
+
+	// positions values the user's positions for the orderbook's PNL; nil
+	// leaves it at zero
+	positions *positions.Service
+
+	// groups maps every working order of a bracket or OCO group to its
+	// group, guarded by mu
+	groups map[int64]*orderGroup
+
+	// auctionSymbols run a call auction in each of auctionWindows
+	auctionSymbols map[string]bool
+	auctionWindows []AuctionWindow
+
+	// marks holds the latest market price of each symbol that trailing
+	// stops follow, guarded by mu
+	marks map[string]float64
+
+	// undo holds the checkpoint of the change being made to each symbol,
+	// guarded by mu
+	undo map[string]*checkpoint
+
+	// ready is set once Recover has rebuilt the book
+	ready atomic.Bool
+}
+
+// clientOrderKey identifies a client order ID within one user's orders
+type clientOrderKey struct {
+	userID int64
+	id     string
+}
+
+// keyedLocks hands out one mutex per key and forgets it once nobody holds or
+// waits for it. The zero value is ready to use.
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[interface{}]*refLock
+}
+
+type refLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (k *keyedLocks) lock(key interface{}) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[interface{}]*refLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &refLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Option configures optional behaviour of the Service
+type Option func(*Service)
+
+// WithMarketClose sets the time of day, in loc, at which DAY orders expire
+func WithMarketClose(loc *time.Location, closeTime time.Duration) Option {
+	return func(s *Service) {
+		s.marketTZ = loc
+		s.marketClose = closeTime
+	}
+}
+
+// WithDepthLevels sets how many price levels a depth request returns by
+// default and the most it may ask for
+func WithDepthLevels(levels, max int) Option {
+	return func(s *Service) {
+		s.depthLevels = levels
+		s.maxDepthLevels = max
+	}
+}
+
+// WithRiskChecks runs every new or amended order through pipeline before it
+// reaches the book
+func WithRiskChecks(pipeline *risk.Pipeline) Option {
+	return func(s *Service) {
+		s.risk = pipeline
+	}
+}
+
+// WithPositions reports the PNL of the user's positions alongside their
+// orders, worked out the same way as the positions endpoint
+func WithPositions(service *positions.Service) Option {
+	return func(s *Service) {
+		s.positions = service
+	}
+}
+
+// WithClock replaces the time source used for order expiry
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+func NewService(repo Repository, engine *matching.Engine, opts ...Option) *Service {
+	s := &Service{
+		repo:        repo,
+		engine:      engine,
+		now:         time.Now,
+		marketTZ:    time.UTC,
+		marketClose: 16 * time.Hour,
+
+		depthLevels:    10,
+		maxDepthLevels: 50,
+
+		symbolLocks: make(map[string]*sync.Mutex),
+		groups:      make(map[int64]*orderGroup),
+		marks:       make(map[string]float64),
+		undo:        make(map[string]*checkpoint),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Service) lockSymbol(symbol string) func() {
+	s.mu.Lock()
+	l, ok := s.symbolLocks[symbol]
+	if !ok {
+		l = &sync.Mutex{}
+		s.symbolLocks[symbol] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// LockUser takes the lock the user's orders are risk checked and created
+// under, so a change to the cash or shares those checks read, such as a
+// withdrawal, cannot slip in between the check and the order. It returns the
+// function that releases the lock.
+func (s *Service) LockUser(userID int64) func() {
+	return s.userLocks.lock(userID)
+}
+
+// PlaceOrder validates and matches a new order. If the request carries a
+// client order ID that the user has already placed an order with, the response
+// of that order is replayed instead of placing another one.
+func (s *Service) PlaceOrder(ctx context.Context, userID int64, req *CreateOrderRequest) (*OrderResult, error) {
+	req.ClientOrderID = strings.TrimSpace(req.ClientOrderID)
+	if len(req.ClientOrderID) > maxClientOrderIDLength {
+		return nil, ErrInvalidClientOrderID
+	}
+	if req.ClientOrderID == "" {
+		return s.placeOrder(ctx, userID, req)
+	}
+
+	// concurrent retries within this process wait here; the unique key on
+	// orders catches a duplicate that got in through another instance
+	unlock := s.clientLocks.lock(clientOrderKey{userID: userID, id: req.ClientOrderID})
+	defer unlock()
+
+	result, err := s.replayOrder(ctx, userID, req)
+	if !errors.Is(err, ErrOrderNotFound) {
+		return result, err
+	}
+
+	result, err = s.placeOrder(ctx, userID, req)
+	if errors.Is(err, ErrDuplicateClientOrderID) {
+		return s.replayOrder(ctx, userID, req)
+	}
+	return result, err
+}
+
+// replayOrder returns the response of the order the user already placed with
+// req's client order ID, or ErrOrderNotFound if there is none
+func (s *Service) replayOrder(ctx context.Context, userID int64, req *CreateOrderRequest) (*OrderResult, error) {
+	order, err := s.repo.GetOrderByClientOrderID(ctx, userID, req.ClientOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.GetOrderResponse(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		// the original request failed after the order was created, so there
+		// is no response to replay; answer with the order as it is now
+		result = &OrderResult{Order: *order}
+	}
+
+	if !sameOrder(&result.Order, req) {
+		return nil, ErrClientOrderIDReused
+	}
+
+	result.Replayed = true
+	return result, nil
+}
+
+// sameOrder reports whether req asks for the order that was placed as o
+func sameOrder(o *Order, req *CreateOrderRequest) bool {
+	typ := req.Type
+	if typ == "" {
+		typ = TypeLimit
+	}
+	tif := req.TimeInForce
+	if tif == "" {
+		tif = TIFDay
+	}
+
+	return o.Symbol == strings.ToUpper(strings.TrimSpace(req.Symbol)) &&
+		o.Side == req.Side &&
+		o.Type == typ &&
+		o.Price == req.Price &&
+		(o.StopPrice == req.StopPrice || (req.StopPrice == 0 && isTrailing(req))) &&
+		o.TrailAmount == req.TrailAmount &&
+		o.TrailPercent == req.TrailPercent &&
+		o.Quantity == req.Quantity &&
+		o.DisplayQuantity == req.DisplayQuantity &&
+		o.ExtendedHours == req.ExtendedHours &&
+		o.TimeInForce == tif
+}
+
+func (s *Service) placeOrder(ctx context.Context, userID int64, req *CreateOrderRequest) (*OrderResult, error) {
+	result, _, err := s.placeReplacing(ctx, userID, req, nil)
+	return result, err
+}
+
+// placeReplacing places req in place of the user's open orders in its symbol
+// that replaced picks, if it is set. They are cancelled only once req has
+// passed every check, and picking them, cancelling them and placing req all
+// happen under the same user and symbol locks. It returns the orders it
+// cancelled.
+func (s *Service) placeReplacing(ctx context.Context, userID int64, req *CreateOrderRequest, replaced func(symbol string) ([]Order, error)) (*OrderResult, []Order, error) {
+	symbol := strings.ToUpper(strings.TrimSpace(req.Symbol))
+	setDefaults(req)
+	now := s.now()
+	if err := validateOrder(symbol, req, now); err != nil {
+		return nil, nil, err
+	}
+	if err := validateBracket(req); err != nil {
+		return nil, nil, err
+	}
+	queue, err := s.checkHours(symbol, req, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlockUser := s.userLocks.lock(userID)
+	defer unlockUser()
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	if !queue {
+		if err := s.checkPhase(symbol, req); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := s.startTrail(symbol, req); err != nil {
+		return nil, nil, err
+	}
+	if isStopType(req.Type) && stopReached(req.Side, req.StopPrice, s.engine.LastPrice(symbol)) {
+		return nil, nil, ErrStopPriceReached
+	}
+
+	var old []Order
+	if replaced != nil {
+		if old, err = replaced(symbol); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	order := s.newOrder(userID, symbol, req, now)
+	check := riskOrder(order)
+	for _, o := range old {
+		check.ReplacesOrderIDs = append(check.ReplacesOrderIDs, o.ID)
+	}
+	if err := s.checkRisk(ctx, check); err != nil {
+		return nil, nil, err
+	}
+
+	cancelled := []Order{}
+	for _, o := range old {
+		c, err := s.cancelLocked(ctx, userID, o.ID)
+		switch {
+		case err == nil:
+			cancelled = append(cancelled, *c)
+		case errors.Is(err, ErrOrderNotOpen):
+			// filled, or cancelled along with its bracket entry
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if queue {
+		if err := s.queueOrder(ctx, order, now); err != nil {
+			return nil, nil, err
+		}
+		result := &OrderResult{Order: *order}
+		s.saveResponse(ctx, result)
+		return result, cancelled, nil
+	}
+
+	bracket := req.TakeProfit != nil || req.StopLoss != nil
+	if bracket {
+		order.GroupType = GroupBracket
+	}
+	if err := s.repo.CreateOrder(ctx, order); err != nil {
+		return nil, nil, err
+	}
+
+	var group *orderGroup
+	if bracket {
+		var err error
+		if group, err = s.createBracket(ctx, order, req); err != nil {
+			s.closeUnplaced(ctx, StatusCancelled, "bracket orders could not be stored", order)
+			return nil, nil, err
+		}
+	}
+
+	// an order that does not make it onto the book must not stay open in
+	// the database
+	unplaced := []*Order{order}
+	var children []*Order
+	var groupIDs []int64
+	if group != nil {
+		children = group.held
+		unplaced = append(unplaced, group.held...)
+		groupIDs = group.ids()
+	}
+	reject := func(reason string) {
+		s.untrack(groupIDs...)
+		s.closeUnplaced(ctx, StatusRejected, reason, unplaced...)
+	}
+
+	// a counterparty short of cash is dropped and the order matched again,
+	// but the order itself is rejected if its user cannot pay
+	var res *matching.Result
+	var submitErr error
+	exec, err := s.change(ctx, symbol, append([]int64{order.ID}, groupIDs...), func() (*Execution, error) {
+		if res, submitErr = s.engine.Submit(toEngineOrder(order)); submitErr != nil {
+			return nil, submitErr
+		}
+		exec := buildExecution(res)
+		s.runGroups(exec, touched(res))
+		return exec, nil
+	})
+	if submitErr != nil {
+		log.Printf("Engine rejected order %d: %v", order.ID, submitErr)
+		reject(submitErr.Error())
+		return nil, nil, submitErr
+	}
+	if err != nil {
+		log.Printf("Error saving execution for order %d: %v", order.ID, err)
+		reason := "order could not be saved"
+		switch {
+		case errors.Is(err, positions.ErrBorrowUnavailable):
+			reason = positions.ErrBorrowUnavailable.Error()
+		case errors.Is(err, ErrInsufficientCash):
+			reason = ErrInsufficientCash.Error()
+		}
+		reject(reason)
+		return nil, nil, err
+	}
+
+	applyState(order, &res.Order)
+	result := &OrderResult{Order: *order, Trades: exec.Trades}
+	for _, child := range children {
+		result.Children = append(result.Children, *child)
+	}
+
+	s.saveResponse(ctx, result)
+	return result, cancelled, nil
+}
+
+// saveResponse keeps the response to an order placed with a client order ID
+// so that retries can replay it
+func (s *Service) saveResponse(ctx context.Context, result *OrderResult) {
+	if result.Order.ClientOrderID == "" {
+		return
+	}
+	// the order is already live, so a failure here only means a retry sees
+	// the order's current state instead of this response
+	if err := s.repo.SaveOrderResponse(ctx, result); err != nil {
+		log.Printf("Error saving response for order %d: %v", result.Order.ID, err)
+	}
+}
+
+// setDefaults fills in the order type and time in force when they are left out
+func setDefaults(req *CreateOrderRequest) {
+	if req.Type == "" {
+		req.Type = TypeLimit
+	}
+	if req.TimeInForce == "" {
+		req.TimeInForce = TIFDay
+	}
+}
+
+// newOrder builds the order to store for a validated request
+func (s *Service) newOrder(userID int64, symbol string, req *CreateOrderRequest, now time.Time) *Order {
+	return &Order{
+		UserID:          userID,
+		ClientOrderID:   req.ClientOrderID,
+		Symbol:          symbol,
+		Side:            req.Side,
+		Type:            req.Type,
+		Price:           req.Price,
+		StopPrice:       req.StopPrice,
+		TrailAmount:     req.TrailAmount,
+		TrailPercent:    req.TrailPercent,
+		Quantity:        req.Quantity,
+		DisplayQuantity: req.DisplayQuantity,
+		TimeInForce:     req.TimeInForce,
+		ExtendedHours:   req.ExtendedHours,
+		ExpiresAt:       s.expiryFor(symbol, req, now),
+		Status:          StatusNew,
+	}
+}
+
+func riskOrder(o *Order) *risk.Order {
+	return &risk.Order{
+		UserID:    o.UserID,
+		Symbol:    o.Symbol,
+		Side:      o.Side,
+		Type:      o.Type,
+		Price:     o.Price,
+		StopPrice: o.StopPrice,
+		Quantity:  o.Quantity,
+	}
+}
+
+// CancelOrder takes an open order owned by userID off the book
+func (s *Service) CancelOrder(ctx context.Context, userID, orderID int64) (*Order, error) {
+	order, err := s.openOrder(ctx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.lockSymbol(order.Symbol)
+	defer unlock()
+
+	return s.cancelLocked(ctx, userID, orderID)
+}
+
+// cancelLocked cancels the order as CancelOrder does, with the lock on its
+// symbol already held
+func (s *Service) cancelLocked(ctx context.Context, userID, orderID int64) (*Order, error) {
+	// the order may have been filled while we were waiting for the lock
+	order, err := s.openOrder(ctx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cancelOpen(ctx, order, "cancelled by user"); err != nil {
+		return nil, err
+	}
+
+	order.Status = StatusCancelled
+	return order, nil
+}
+
+// cancelOpen takes an open order off the book, or out of the group holding
+// it, and records why. It must be called with the order's symbol locked.
+func (s *Service) cancelOpen(ctx context.Context, order *Order, reason string) error {
+	_, err := s.change(ctx, order.Symbol, nil, func() (*Execution, error) {
+		// an open order that is not on the book (e.g. placed before a
+		// restart) only needs its status changed
+		update := OrderUpdate{
+			OrderID:        order.ID,
+			Status:         StatusCancelled,
+			FilledQuantity: order.FilledQuantity,
+			AvgFillPrice:   order.AvgFillPrice,
+			Reason:         reason,
+		}
+		exec := &Execution{}
+		cancelled, err := s.engine.Cancel(order.Symbol, order.ID)
+		switch {
+		case err == nil:
+			update = orderUpdate(&cancelled)
+			update.Reason = reason
+			exec.Orders = append(exec.Orders, update)
+			s.runGroups(exec, []matching.Order{cancelled})
+		case errors.Is(err, matching.ErrOrderNotFound):
+			exec.Orders = append(exec.Orders, update)
+		default:
+			return nil, err
+		}
+		return exec, nil
+	})
+	if err != nil {
+		log.Printf("Error cancelling order %d: %v", order.ID, err)
+		return err
+	}
+	if order.Status == StatusNew {
+		s.dropHeld(order.ID)
+	}
+	return nil
+}
+
+// AmendOrder changes the price and/or quantity of an open order owned by
+// userID. Quantity is the new total size of the order, including whatever has
+// already been filled.
+func (s *Service) AmendOrder(ctx context.Context, userID, orderID int64, req *AmendOrderRequest) (*OrderResult, error) {
+	if req.Price == nil && req.Quantity == nil {
+		return nil, ErrEmptyAmendment
+	}
+
+	order, err := s.openOrder(ctx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockUser := s.userLocks.lock(userID)
+	defer unlockUser()
+	unlock := s.lockSymbol(order.Symbol)
+	defer unlock()
+
+	if order, err = s.openOrder(ctx, userID, orderID); err != nil {
+		return nil, err
+	}
+
+	price, quantity := order.Price, order.Quantity
+	if req.Price != nil {
+		price = *req.Price
+	}
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	switch order.Type {
+	case TypeLimit, TypeStopLimit:
+		if price <= 0 {
+			return nil, ErrInvalidPrice
+		}
+	default:
+		if price != 0 {
+			return nil, ErrUnexpectedPrice
+		}
+	}
+
+	// the engine keeps the iceberg slice of an order on the book within its
+	// size, but only sees a queued order once it is released
+	if order.Status == StatusQueued {
+		if err := validateDisplay(order.Type, order.TimeInForce, order.DisplayQuantity, quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	if quantity > order.FilledQuantity {
+		err := s.checkRisk(ctx, &risk.Order{
+			UserID:           userID,
+			Symbol:           order.Symbol,
+			Side:             order.Side,
+			Type:             order.Type,
+			Price:            price,
+			StopPrice:        order.StopPrice,
+			Quantity:         quantity - order.FilledQuantity,
+			ReplacesOrderIDs: []int64{order.ID},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if order.Status == StatusQueued {
+		// not on the book yet, so only the stored order changes
+		exec := &Execution{Amendments: []Amendment{{OrderID: order.ID, Price: price, Quantity: quantity}}}
+		if err := s.repo.SaveExecution(ctx, exec); err != nil {
+			log.Printf("Error saving amendment for order %d: %v", order.ID, err)
+			return nil, err
+		}
+		order.Price, order.Quantity = price, quantity
+		return &OrderResult{Order: *order}, nil
+	}
+
+	var res *matching.Result
+	var amendErr error
+	exec, err := s.change(ctx, order.Symbol, []int64{order.ID}, func() (*Execution, error) {
+		if res, amendErr = s.engine.Amend(order.Symbol, order.ID, price, quantity); amendErr != nil {
+			return nil, amendErr
+		}
+		exec := &Execution{Amendments: []Amendment{{OrderID: order.ID, Price: price, Quantity: quantity}}}
+		exec.Orders = append(exec.Orders, orderUpdate(&res.Order))
+		addResult(exec, res)
+		s.runGroups(exec, touched(res))
+		return exec, nil
+	})
+	switch {
+	case errors.Is(amendErr, matching.ErrOrderNotFound):
+		return nil, ErrOrderNotOpen
+	case errors.Is(amendErr, matching.ErrInvalidQuantity):
+		return nil, ErrAmendQuantity
+	case amendErr != nil:
+		return nil, amendErr
+	case err != nil:
+		log.Printf("Error saving amendment for order %d: %v", order.ID, err)
+		return nil, err
+	}
+
+	applyState(order, &res.Order)
+	return &OrderResult{Order: *order, Trades: exec.Trades}, nil
+}
+
+// checkRisk fills in the market prices of o and runs it through the risk
+// pipeline. It must be called with the symbol locked.
+func (s *Service) checkRisk(ctx context.Context, o *risk.Order) error {
+	if s.risk == nil {
+		return nil
+	}
+
+	o.LastPrice = s.engine.LastPrice(o.Symbol)
+	o.ReferencePrice = s.referencePrice(o)
+	return s.risk.Evaluate(ctx, o)
+}
+
+// referencePrice is what an order is expected to trade at: its limit price,
+// else its stop price, else the average price of the orders on the other side
+// of the book it would trade against, else the last trade
+func (s *Service) referencePrice(o *risk.Order) float64 {
+	if o.Price > 0 {
+		return o.Price
+	}
+	if o.StopPrice > 0 {
+		return o.StopPrice
+	}
+
+	if price := s.engine.MarketPrice(o.Symbol, matching.Side(o.Side), o.Quantity); price > 0 {
+		return price
+	}
+	return o.LastPrice
+}
+
+// openOrder loads an order owned by userID and checks that it can still be changed
+func (s *Service) openOrder(ctx context.Context, userID, orderID int64) (*Order, error) {
+	order, err := s.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	if !IsOpenStatus(order.Status) {
+		return nil, ErrOrderNotOpen
+	}
+	return order, nil
+}
+
+// GetOrderHistory returns every status change of an order owned by userID
+func (s *Service) GetOrderHistory(ctx context.Context, userID, orderID int64) ([]OrderEvent, error) {
+	order, err := s.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+
+	events, err := s.repo.GetOrderEvents(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []OrderEvent{}
+	}
+	return events, nil
+}
+
+// GetOrderbook returns one page of the user's orders matching q, together
+// with their PNL. NextCursor is set when there are more orders to fetch.
+func (s *Service) GetOrderbook(ctx context.Context, userID int64, q *OrderbookQuery) (*OrderbookResponse, error) {
+	filter, err := parseOrderbookQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// one extra order tells whether there is another page
+	limit := filter.Limit
+	filter.Limit++
+	orders, err := s.repo.ListOrders(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &OrderbookResponse{Orders: orders}
+	if len(orders) > limit {
+		resp.Orders = orders[:limit]
+		next, err := cursorAfter(&resp.Orders[limit-1], filter.Oldest)
+		if err != nil {
+			return nil, err
+		}
+		resp.NextCursor = next.encode()
+	}
+	if resp.Orders == nil {
+		resp.Orders = []Order{}
+	}
+
+	if s.positions != nil {
+		pnl, err := s.positions.GetSummary(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		resp.PNL = *pnl
+	}
+
+	return resp, nil
+}
+
+// ExpireOrders takes every DAY and GTD order that has reached its expiry time
+// off the book and marks it expired. A symbol that fails does not hold up the
+// others; its orders are tried again on the next sweep and the errors are
+// returned together.
+func (s *Service) ExpireOrders(ctx context.Context) error {
+	now := s.now()
+	var auctions []string
+	var errs []error
+	for _, symbol := range s.engine.Symbols() {
+		waiting, err := s.expireSymbol(ctx, symbol, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if waiting {
+			auctions = append(auctions, symbol)
+		}
+	}
+
+	// queued orders are not on the book yet, so they are expired directly in
+	// the database. Symbols whose orders wait for an uncross are left for the
+	// engine.
+	n, err := s.repo.ExpireQueuedOrders(ctx, now, auctions)
+	if err != nil {
+		log.Printf("Error expiring queued orders: %v", err)
+		errs = append(errs, err)
+	}
+	if n > 0 {
+		log.Printf("Expired %d queued order(s)", n)
+	}
+	return errors.Join(errs...)
+}
+
+// expireSymbol expires the orders on the book of symbol whose time is up. It
+// reports true when the symbol is in a call auction, whose DAY orders stay
+// for the uncross and expire after it.
+func (s *Service) expireSymbol(ctx context.Context, symbol string, now time.Time) (bool, error) {
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	if s.engine.InAuction(symbol) {
+		return true, nil
+	}
+
+	var expired []matching.Order
+	_, err := s.change(ctx, symbol, nil, func() (*Execution, error) {
+		expired = s.engine.Expire(symbol, now)
+		if len(expired) == 0 {
+			return nil, nil
+		}
+
+		exec := &Execution{}
+		for i := range expired {
+			exec.Orders = append(exec.Orders, orderUpdate(&expired[i]))
+		}
+		s.runGroups(exec, expired)
+		return exec, nil
+	})
+	if err != nil {
+		log.Printf("Error saving expired orders for %s: %v", symbol, err)
+		return false, err
+	}
+
+	if len(expired) > 0 {
+		log.Printf("Expired %d order(s) for %s", len(expired), symbol)
+	}
+	return false, nil
+}
+
+// RunExpirySweeper calls ExpireOrders every interval until ctx is cancelled
+func (s *Service) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ExpireOrders(ctx); err != nil {
+				log.Printf("Error expiring orders: %v", err)
+			}
+		}
+	}
+}
+
+// expiryFor returns when an order placed at now should expire, or nil if it
+// stays on the book until it is filled or cancelled. DAY orders last until the
+// next close of their exchange: the end of regular hours, or of the last
+// session of the day for extended hours orders.
+func (s *Service) expiryFor(symbol string, req *CreateOrderRequest, now time.Time) *time.Time {
+	switch req.TimeInForce {
+	case TIFDay:
+		expiry := s.nextMarketClose(now)
+		if x := s.calendar.Exchange(symbol); x != nil {
+			if closes := x.NextClose(now, req.ExtendedHours); !closes.IsZero() {
+				expiry = closes.UTC()
+			}
+		}
+		return &expiry
+	case TIFGoodTillDate:
+		expiry := req.ExpiresAt.UTC()
+		return &expiry
+	default:
+		return nil
+	}
+}
+
+// nextMarketClose returns the first market close strictly after now
+func (s *Service) nextMarketClose(now time.Time) time.Time {
+	local := now.In(s.marketTZ)
+	year, month, day := local.Date()
+	closeAt := time.Date(year, month, day, 0, 0, 0, 0, s.marketTZ).Add(s.marketClose)
+	if !closeAt.After(now) {
+		closeAt = time.Date(year, month, day+1, 0, 0, 0, 0, s.marketTZ).Add(s.marketClose)
+	}
+	return closeAt.UTC()
+}
+
+// GetDepth aggregates the resting orders of every user on symbol's book into
+// price levels. A levels value of zero uses the configured default.
+func (s *Service) GetDepth(symbol string, levels int) (*DepthResponse, error) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+	if levels == 0 {
+		levels = s.depthLevels
+	}
+	if levels < 0 || levels > s.maxDepthLevels {
+		return nil, ErrInvalidDepth
+	}
+
+	d := s.engine.Depth(symbol, levels)
+	return &DepthResponse{
+		Symbol:    d.Symbol,
+		Bids:      toDepthLevels(d.Bids),
+		Asks:      toDepthLevels(d.Asks),
+		LastPrice: d.LastPrice,
+	}, nil
+}
+
+func toDepthLevels(levels []matching.Level) []DepthLevel {
+	result := make([]DepthLevel, 0, len(levels))
+	for _, l := range levels {
+		result = append(result, DepthLevel{Price: l.Price, Quantity: l.Quantity, Orders: l.Orders})
+	}
+	return result
+}
+
+// GetTrades returns the user's side of every trade they took part in, newest first
+func (s *Service) GetTrades(ctx context.Context, userID int64, symbol string) ([]Fill, error) {
+	trades, err := s.repo.GetTradesByUser(ctx, userID, strings.ToUpper(strings.TrimSpace(symbol)))
+	if err != nil {
+		return nil, err
+	}
+
+	fills := []Fill{}
+	for _, t := range trades {
+		// a user trading with themselves sees both sides
+		if t.BuyUserID == userID {
+			fills = append(fills, fillFor(t, "buy"))
+		}
+		if t.SellUserID == userID {
+			fills = append(fills, fillFor(t, "sell"))
+		}
+	}
+
+	return fills, nil
+}
+
+func fillFor(t Trade, side string) Fill {
+	f := Fill{
+		TradeID:    t.ID,
+		Symbol:     t.Symbol,
+		Side:       side,
+		Price:      t.Price,
+		Quantity:   t.Quantity,
+		Liquidity:  "maker",
+		ExecutedAt: t.ExecutedAt,
+	}
+	if side == "buy" {
+		f.OrderID, f.CounterpartyOrderID = t.BuyOrderID, t.SellOrderID
+	} else {
+		f.OrderID, f.CounterpartyOrderID = t.SellOrderID, t.BuyOrderID
+	}
+	if t.TakerSide == side {
+		f.Liquidity = "taker"
+	}
+	return f
+}
+
+func validateOrder(symbol string, req *CreateOrderRequest, now time.Time) error {
+	if req.Side != "buy" && req.Side != "sell" {
+		return ErrInvalidSide
+	}
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
+	if req.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+
+	switch req.Type {
+	case TypeMarket, TypeStop:
+		if req.Price != 0 {
+			return ErrUnexpectedPrice
+		}
+	case TypeLimit, TypeStopLimit:
+		if req.Price <= 0 {
+			return ErrInvalidPrice
+		}
+	default:
+		return ErrInvalidType
+	}
+
+	if isStopType(req.Type) {
+		// a trailing stop order can start from the market price instead
+		if req.StopPrice < 0 || (req.StopPrice == 0 && !(req.Type == TypeStop && isTrailing(req))) {
+			return ErrInvalidStopPrice
+		}
+	} else if req.StopPrice != 0 {
+		return ErrUnexpectedStopPrice
+	}
+
+	if req.TrailAmount != 0 || req.TrailPercent != 0 {
+		if !isStopType(req.Type) || req.TrailAmount < 0 || req.TrailPercent < 0 || req.TrailPercent >= 100 ||
+			(req.TrailAmount > 0 && req.TrailPercent > 0) {
+			return ErrInvalidTrail
+		}
+	}
+
+	switch req.TimeInForce {
+	case TIFDay, TIFImmediateOrCancel, TIFFillOrKill:
+	case TIFGoodTillCancel, TIFGoodTillDate:
+		if req.Type == TypeMarket {
+			return ErrMarketTimeInForce
+		}
+	default:
+		return ErrInvalidTimeInForce
+	}
+
+	if req.TimeInForce == TIFGoodTillDate {
+		if req.ExpiresAt == nil || !req.ExpiresAt.After(now) {
+			return ErrInvalidExpiresAt
+		}
+	} else if req.ExpiresAt != nil {
+		return ErrUnexpectedExpiresAt
+	}
+
+	if req.ExtendedHours && req.Type != TypeLimit {
+		return ErrInvalidExtendedHours
+	}
+
+	return validateDisplay(req.Type, req.TimeInForce, req.DisplayQuantity, req.Quantity)
+}
+
+// validateDisplay checks the iceberg slice of an order of the given type, time
+// in force and total quantity. A display quantity of zero shows the whole order.
+func validateDisplay(orderType, tif string, display, quantity int) error {
+	if display == 0 {
+		return nil
+	}
+
+	rests := orderType == TypeLimit || orderType == TypeStopLimit
+	immediate := tif == TIFImmediateOrCancel || tif == TIFFillOrKill
+	if display < 0 || display > quantity || !rests || immediate {
+		return ErrInvalidDisplayQuantity
+	}
+	return nil
+}
+
+func isStopType(orderType string) bool {
+	return orderType == TypeStop || orderType == TypeStopLimit
+}
+
+// stopReached reports whether a stop order on side would trigger immediately at lastPrice
+func stopReached(side string, stopPrice, lastPrice float64) bool {
+	if lastPrice <= 0 {
+		return false
+	}
+	if side == "buy" {
+		return lastPrice >= stopPrice
+	}
+	return lastPrice <= stopPrice
+}
+
+// buildExecution converts the engine result of a newly submitted order into
+// the rows that need to be written
+func buildExecution(res *matching.Result) *Execution {
+	exec := &Execution{Orders: acceptedUpdates(&res.Order)}
+	addResult(exec, res)
+	return exec
+}
+
+// acceptedUpdates records the engine taking an order that was stored as new,
+// followed by whatever matching did to it straight away
+func acceptedUpdates(o *matching.Order) []OrderUpdate {
+	updates := []OrderUpdate{{OrderID: o.ID, Status: StatusAccepted, BookSeq: o.Seq}}
+	if o.Filled() > 0 || o.Cancelled || o.Triggered {
+		updates = append(updates, orderUpdate(o))
+	}
+	return updates
+}
+
+// addResult adds the resting orders and trades an engine result changed to exec
+func addResult(exec *Execution, res *matching.Result) {
+	for i := range res.Updated {
+		exec.Orders = append(exec.Orders, orderUpdate(&res.Updated[i]))
+	}
+	addTrades(exec, res.Trades)
+	addSelfTrades(exec, res.SelfTrades)
+}
+
+func addTrades(exec *Execution, trades []matching.Trade) {
+	for _, t := range trades {
+		exec.Trades = append(exec.Trades, Trade{
+			Symbol:      t.Symbol,
+			Price:       t.Price,
+			Quantity:    t.Quantity,
+			BuyOrderID:  t.BuyOrderID,
+			SellOrderID: t.SellOrderID,
+			BuyUserID:   t.BuyUserID,
+			SellUserID:  t.SellUserID,
+			TakerSide:   string(t.TakerSide),
+			ExecutedAt:  t.ExecutedAt,
+		})
+	}
+}
+
+// touched lists every order an engine result changed, the incoming one first
+func touched(res *matching.Result) []matching.Order {
+	return append([]matching.Order{res.Order}, res.Updated...)
+}
+
+func toEngineOrder(o *Order) matching.Order {
+	eo := matching.Order{
+		ID:              o.ID,
+		UserID:          o.UserID,
+		Symbol:          o.Symbol,
+		Side:            matching.Side(o.Side),
+		Type:            matching.OrderType(o.Type),
+		Price:           o.Price,
+		StopPrice:       o.StopPrice,
+		TrailAmount:     o.TrailAmount,
+		TrailPercent:    o.TrailPercent,
+		Quantity:        o.Quantity,
+		DisplayQuantity: o.DisplayQuantity,
+		TimeInForce:     matching.TimeInForce(o.TimeInForce),
+
+		SelfTradePrevention: matching.SelfTradePrevention(o.SelfTradePrevention),
+	}
+	if o.ExpiresAt != nil {
+		eo.ExpiresAt = *o.ExpiresAt
+	}
+	return eo
+}
+
+func orderUpdate(o *matching.Order) OrderUpdate {
+	return OrderUpdate{
+		OrderID:        o.ID,
+		Status:         statusOf(o),
+		FilledQuantity: o.Filled(),
+		AvgFillPrice:   o.AvgFillPrice,
+		Triggered:      o.Triggered,
+		BookSeq:        o.Seq,
+	}
+}
+
+// applyState copies the engine's view of an order onto the stored order
+func applyState(order *Order, o *matching.Order) {
+	order.Price = o.Price
+	order.StopPrice = o.StopPrice
+	order.Quantity = o.Quantity
+	order.FilledQuantity = o.Filled()
+	order.AvgFillPrice = o.AvgFillPrice
+	order.Status = statusOf(o)
+}
+
+func statusOf(o *matching.Order) string {
+	switch {
+	case o.IsFilled():
+		return StatusFilled
+	case o.Expired:
+		return StatusExpired
+	case o.Cancelled:
+		return StatusCancelled
+	case o.Filled() > 0:
+		return StatusPartiallyFilled
+	default:
+		return StatusAccepted
+	}
+}
@@ -0,0 +1,733 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"brokerapp/internal/matching"
+	"brokerapp/internal/positions"
+	"brokerapp/internal/risk"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// expectCreateOrder makes the mock assign sequential IDs to created orders
+func expectCreateOrder(mockRepo *MockRepository) {
+	var nextID int64
+	mockRepo.On("CreateOrder", mock.Anything, mock.AnythingOfType("*orderbook.Order")).
+		Run(func(args mock.Arguments) {
+			nextID++
+			args.Get(1).(*Order).ID = nextID
+		}).
+		Return(nil)
+}
+
+// expectAccepted lets through the executions that only record the engine
+// accepting newly placed orders
+func expectAccepted(mockRepo *MockRepository) {
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Amendments) == 0 && len(exec.Trails) == 0 && len(exec.Trades) == 0 && len(changes(exec)) == 0
+	})).Return(nil)
+}
+
+// changes returns the order updates in exec other than the engine accepting
+// an order
+func changes(exec *Execution) []OrderUpdate {
+	var updates []OrderUpdate
+	for _, u := range exec.Orders {
+		if u.Status != StatusAccepted || u.FilledQuantity > 0 || u.Triggered {
+			updates = append(updates, u)
+		}
+	}
+	return updates
+}
+
+func TestPlaceOrderRests(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{
+		Symbol:   "aapl",
+		Side:     "buy",
+		Price:    150,
+		Quantity: 10,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL", result.Order.Symbol)
+	assert.Equal(t, StatusAccepted, result.Order.Status)
+	assert.Empty(t, result.Trades)
+	mockRepo.AssertCalled(t, "SaveExecution", mock.Anything, &Execution{Orders: []OrderUpdate{{OrderID: 1, Status: StatusAccepted, BookSeq: 1}}})
+}
+
+func TestPlaceOrderMatchesAcrossUsers(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		updates := changes(exec)
+		return len(exec.Trades) == 1 &&
+			exec.Trades[0].BuyOrderID == 2 &&
+			exec.Trades[0].SellOrderID == 1 &&
+			len(updates) == 2 &&
+			updates[0].Status == StatusFilled &&
+			updates[1].Status == StatusFilled
+	})).Return(nil).Once()
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	result, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 151, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFilled, result.Order.Status)
+	assert.Len(t, result.Trades, 1)
+	assert.Equal(t, 150.0, result.Trades[0].Price)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlaceOrderValidation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "hold", Price: 150, Quantity: 10})
+	assert.Equal(t, ErrInvalidSide, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: " ", Side: "buy", Price: 150, Quantity: 10})
+	assert.Equal(t, ErrInvalidSymbol, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 0})
+	assert.Equal(t, ErrInvalidQuantity, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: -1, Quantity: 10})
+	assert.Equal(t, ErrInvalidPrice, err)
+
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestPlaceOrderTypeValidation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: "fok", Price: 150, Quantity: 10})
+	assert.Equal(t, ErrInvalidType, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Price: 150, Quantity: 10})
+	assert.Equal(t, ErrUnexpectedPrice, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, StopPrice: 140, Quantity: 10})
+	assert.Equal(t, ErrUnexpectedStopPrice, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeStopLimit, Price: 150, Quantity: 10})
+	assert.Equal(t, ErrInvalidStopPrice, err)
+
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestPlaceMarketOrderWithoutLiquidityIsCancelled(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 0 &&
+			len(exec.Orders) == 2 &&
+			exec.Orders[0].Status == StatusAccepted &&
+			exec.Orders[1].Status == StatusCancelled
+	})).Return(nil).Once()
+
+	result, err := service.PlaceOrder(context.Background(), 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, result.Order.Status)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlaceOrderDayExpiresAtNextMarketClose(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2024, 2, 20, 22, 0, 0, 0, time.UTC) // 17:00 in New York, after the close
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithMarketClose(newYork, 16*time.Hour),
+		WithClock(func() time.Time { return now }),
+	)
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	result, err := service.PlaceOrder(context.Background(), 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, TIFDay, result.Order.TimeInForce)
+	assert.Equal(t, time.Date(2024, 2, 21, 21, 0, 0, 0, time.UTC), *result.Order.ExpiresAt)
+}
+
+func TestPlaceOrderTimeInForceValidation(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithClock(func() time.Time { return now }))
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, TimeInForce: "GFD"})
+	assert.Equal(t, ErrInvalidTimeInForce, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.Equal(t, ErrMarketTimeInForce, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, TimeInForce: TIFGoodTillDate, ExpiresAt: &past})
+	assert.Equal(t, ErrInvalidExpiresAt, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, TimeInForce: TIFGoodTillCancel, ExpiresAt: &past})
+	assert.Equal(t, ErrUnexpectedExpiresAt, err)
+
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+}
+
+func TestExpireOrders(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(time.Minute)
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithClock(func() time.Time { return now }))
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, TimeInForce: TIFGoodTillDate, ExpiresAt: &expiry})
+	assert.NoError(t, err)
+
+	now = expiry
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].OrderID == 1 && exec.Orders[0].Status == StatusExpired
+	})).Return(nil).Once()
+	mockRepo.On("ExpireQueuedOrders", ctx, expiry, []string(nil)).Return(int64(0), nil).Once()
+
+	assert.NoError(t, service.ExpireOrders(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestExpireOrdersCarriesOnPastAFailedSymbol(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(time.Minute)
+
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithClock(func() time.Time { return now }))
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: symbol, Side: "buy", Price: 150, Quantity: 10, TimeInForce: TIFGoodTillDate, ExpiresAt: &expiry})
+		assert.NoError(t, err)
+	}
+
+	now = expiry
+	dbErr := errors.New("lock wait timeout")
+	expiring := func(orderID int64) interface{} {
+		return mock.MatchedBy(func(exec *Execution) bool {
+			return len(exec.Orders) == 1 && exec.Orders[0].OrderID == orderID && exec.Orders[0].Status == StatusExpired
+		})
+	}
+	mockRepo.On("SaveExecution", mock.Anything, expiring(1)).Return(dbErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, expiring(2)).Return(nil).Once()
+	mockRepo.On("ExpireQueuedOrders", ctx, expiry, []string(nil)).Return(int64(0), nil).Once()
+
+	err := service.ExpireOrders(ctx)
+	assert.ErrorIs(t, err, dbErr)
+
+	// AAPL is left for the next sweep
+	_, ok := service.engine.Order("AAPL", 1)
+	assert.True(t, ok)
+	_, ok = service.engine.Order("MSFT", 2)
+	assert.False(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCancelOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	placed, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	stored := placed.Order
+	mockRepo.On("GetOrder", ctx, stored.ID).Return(&stored, nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Orders) == 1 && exec.Orders[0].Status == StatusCancelled
+	})).Return(nil).Once()
+
+	order, err := service.CancelOrder(ctx, 1, stored.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, order.Status)
+
+	_, ok := service.engine.Order("AAPL", stored.ID)
+	assert.False(t, ok)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCancelOrderRejectsOtherUsersAndClosedOrders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	mockRepo.On("GetOrder", ctx, int64(1)).Return(&Order{ID: 1, UserID: 2, Symbol: "AAPL", Status: StatusAccepted}, nil)
+	mockRepo.On("GetOrder", ctx, int64(2)).Return(&Order{ID: 2, UserID: 1, Symbol: "AAPL", Status: StatusFilled}, nil)
+
+	_, err := service.CancelOrder(ctx, 1, 1)
+	assert.Equal(t, ErrOrderNotFound, err)
+
+	_, err = service.CancelOrder(ctx, 1, 2)
+	assert.Equal(t, ErrOrderNotOpen, err)
+
+	_, err = service.AmendOrder(ctx, 1, 2, &AmendOrderRequest{})
+	assert.Equal(t, ErrEmptyAmendment, err)
+
+	quantity := 5
+	_, err = service.AmendOrder(ctx, 1, 2, &AmendOrderRequest{Quantity: &quantity})
+	assert.Equal(t, ErrOrderNotOpen, err)
+
+	mockRepo.AssertNotCalled(t, "SaveExecution", mock.Anything, mock.Anything)
+}
+
+func TestAmendOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	placed, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	stored := placed.Order
+	mockRepo.On("GetOrder", ctx, stored.ID).Return(&stored, nil)
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Amendments) == 1 &&
+			exec.Amendments[0].Price == 151 &&
+			exec.Amendments[0].Quantity == 10
+	})).Return(nil).Once()
+
+	price := 151.0
+	result, err := service.AmendOrder(ctx, 1, stored.ID, &AmendOrderRequest{Price: &price})
+	assert.NoError(t, err)
+	assert.Equal(t, 151.0, result.Order.Price)
+	assert.Equal(t, StatusAccepted, result.Order.Status)
+
+	resting, ok := service.engine.Order("AAPL", stored.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 151.0, resting.Price)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlaceOrderPartialFill(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.AnythingOfType("*orderbook.Execution")).Return(nil)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 4})
+	assert.NoError(t, err)
+
+	result, err := service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPartiallyFilled, result.Order.Status)
+	assert.Equal(t, 4, result.Order.FilledQuantity)
+	assert.Equal(t, 150.0, result.Order.AvgFillPrice)
+
+	mockRepo.AssertCalled(t, "SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		updates := changes(exec)
+		return len(updates) == 2 &&
+			updates[0].OrderID == 2 &&
+			updates[0].Status == StatusPartiallyFilled &&
+			updates[0].FilledQuantity == 4 &&
+			updates[1].Status == StatusFilled
+	}))
+}
+
+func TestGetTrades(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	mockRepo.On("GetTradesByUser", ctx, int64(1), "AAPL").Return([]Trade{
+		{ID: 2, Symbol: "AAPL", Price: 151, Quantity: 3, BuyOrderID: 5, SellOrderID: 4, BuyUserID: 1, SellUserID: 1, TakerSide: "buy"},
+		{ID: 1, Symbol: "AAPL", Price: 150, Quantity: 4, BuyOrderID: 3, SellOrderID: 2, BuyUserID: 2, SellUserID: 1, TakerSide: "buy"},
+	}, nil)
+
+	fills, err := service.GetTrades(ctx, 1, " aapl ")
+	assert.NoError(t, err)
+	assert.Len(t, fills, 3)
+
+	assert.Equal(t, "buy", fills[0].Side)
+	assert.Equal(t, "taker", fills[0].Liquidity)
+	assert.Equal(t, "sell", fills[1].Side)
+	assert.Equal(t, "maker", fills[1].Liquidity)
+
+	assert.Equal(t, int64(2), fills[2].OrderID)
+	assert.Equal(t, int64(3), fills[2].CounterpartyOrderID)
+	assert.Equal(t, "maker", fills[2].Liquidity)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetDepth(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithDepthLevels(1, 5))
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 4})
+	service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 6})
+	service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 149, Quantity: 1})
+
+	depth, err := service.GetDepth("aapl", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []DepthLevel{{Price: 150, Quantity: 10, Orders: 2}}, depth.Bids)
+	assert.Empty(t, depth.Asks)
+
+	depth, err = service.GetDepth("AAPL", 5)
+	assert.NoError(t, err)
+	assert.Len(t, depth.Bids, 2)
+
+	_, err = service.GetDepth("AAPL", 6)
+	assert.Equal(t, ErrInvalidDepth, err)
+	_, err = service.GetDepth("AAPL", -1)
+	assert.Equal(t, ErrInvalidDepth, err)
+}
+
+func TestPlaceIcebergOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.AnythingOfType("*orderbook.Execution")).Return(nil)
+
+	ctx := context.Background()
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 10, DisplayQuantity: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Order.DisplayQuantity)
+
+	depth, err := service.GetDepth("AAPL", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []DepthLevel{{Price: 150, Quantity: 4, Orders: 1}}, depth.Asks)
+
+	// taking the whole slice shows the next one, queued afresh
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 4})
+	assert.NoError(t, err)
+	mockRepo.AssertCalled(t, "SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		updates := changes(exec)
+		return len(updates) == 2 &&
+			updates[1].OrderID == 1 &&
+			updates[1].Status == StatusPartiallyFilled &&
+			updates[1].BookSeq == 3
+	}))
+
+	depth, err = service.GetDepth("AAPL", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []DepthLevel{{Price: 150, Quantity: 4, Orders: 1}}, depth.Asks)
+}
+
+func TestPlaceIcebergOrderValidation(t *testing.T) {
+	service := NewService(new(MockRepository), matching.NewEngine())
+	ctx := context.Background()
+
+	for _, req := range []*CreateOrderRequest{
+		{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, DisplayQuantity: 11},
+		{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, DisplayQuantity: -1},
+		{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 10, DisplayQuantity: 2},
+		{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10, DisplayQuantity: 2, TimeInForce: TIFImmediateOrCancel},
+	} {
+		_, err := service.PlaceOrder(ctx, 1, req)
+		assert.Equal(t, ErrInvalidDisplayQuantity, err)
+	}
+}
+
+// expectClientOrderID makes the mock remember the response saved for the first
+// order placed with a client order ID and hand it back to later lookups
+func expectClientOrderID(mockRepo *MockRepository, userID int64, clientOrderID string) *OrderResult {
+	saved := &OrderResult{}
+	mockRepo.On("GetOrderByClientOrderID", mock.Anything, userID, clientOrderID).Return(nil, ErrOrderNotFound).Once()
+	mockRepo.On("GetOrderByClientOrderID", mock.Anything, userID, clientOrderID).Return(&saved.Order, nil)
+	mockRepo.On("SaveOrderResponse", mock.Anything, mock.AnythingOfType("*orderbook.OrderResult")).
+		Run(func(args mock.Arguments) {
+			*saved = *args.Get(1).(*OrderResult)
+		}).
+		Return(nil)
+	mockRepo.On("GetOrderResponse", mock.Anything, mock.Anything).Return(saved, nil)
+	return saved
+}
+
+func TestPlaceOrderReplaysClientOrderID(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+	expectClientOrderID(mockRepo, 1, "abc-1")
+
+	ctx := context.Background()
+	req := CreateOrderRequest{ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10}
+
+	first := req
+	result, err := service.PlaceOrder(ctx, 1, &first)
+	assert.NoError(t, err)
+	assert.False(t, result.Replayed)
+	assert.Equal(t, "abc-1", result.Order.ClientOrderID)
+
+	retry := req
+	replayed, err := service.PlaceOrder(ctx, 1, &retry)
+	assert.NoError(t, err)
+	assert.True(t, replayed.Replayed)
+	assert.Equal(t, result.Order.ID, replayed.Order.ID)
+
+	mockRepo.AssertNumberOfCalls(t, "CreateOrder", 1)
+	assert.Equal(t, 10, service.engine.Depth("AAPL", 1).Bids[0].Quantity)
+}
+
+func TestPlaceOrderConcurrentClientOrderID(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+	expectClientOrderID(mockRepo, 1, "abc-1")
+
+	ctx := context.Background()
+	results := make([]*OrderResult, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := CreateOrderRequest{ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10}
+			result, err := service.PlaceOrder(ctx, 1, &req)
+			assert.NoError(t, err)
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+
+	mockRepo.AssertNumberOfCalls(t, "CreateOrder", 1)
+	assert.Equal(t, results[0].Order.ID, results[1].Order.ID)
+	assert.NotEqual(t, results[0].Replayed, results[1].Replayed)
+	assert.Equal(t, 1, service.engine.Depth("AAPL", 1).Bids[0].Orders)
+}
+
+func TestPlaceOrderClientOrderIDReused(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+	expectClientOrderID(mockRepo, 1, "abc-1")
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 20})
+	assert.Equal(t, ErrClientOrderIDReused, err)
+	mockRepo.AssertNumberOfCalls(t, "CreateOrder", 1)
+}
+
+func TestPlaceOrderClientOrderIDTakenElsewhere(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	// another instance placed the order between the lookup and the insert
+	existing := &Order{ID: 7, ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Type: TypeLimit, Price: 150, Quantity: 10, TimeInForce: TIFDay, Status: StatusAccepted}
+	mockRepo.On("GetOrderByClientOrderID", mock.Anything, int64(1), "abc-1").Return(nil, ErrOrderNotFound).Once()
+	mockRepo.On("GetOrderByClientOrderID", mock.Anything, int64(1), "abc-1").Return(existing, nil)
+	mockRepo.On("CreateOrder", mock.Anything, mock.Anything).Return(ErrDuplicateClientOrderID)
+	mockRepo.On("GetOrderResponse", mock.Anything, int64(7)).Return(nil, nil)
+
+	ctx := context.Background()
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{ClientOrderID: "abc-1", Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 10})
+	assert.NoError(t, err)
+	assert.True(t, result.Replayed)
+	assert.Equal(t, int64(7), result.Order.ID)
+	assert.Empty(t, service.engine.Depth("AAPL", 1).Bids)
+}
+
+func TestPlaceOrderRejectedByRiskChecks(t *testing.T) {
+	mockRepo := new(MockRepository)
+	riskRepo := new(risk.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithRiskChecks(risk.NewPipeline(risk.BuyingPower(riskRepo), risk.MaxNotional(5000))))
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	riskRepo.On("GetCash", ctx, int64(1)).Return(1000.0, nil)
+	riskRepo.On("GetOpenBuyNotional", ctx, int64(1), []int64(nil)).Return(0.0, nil)
+
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 40})
+	var rejection *risk.RejectionError
+	assert.True(t, errors.As(err, &rejection))
+	assert.Len(t, rejection.Reasons, 2)
+	assert.Equal(t, risk.CheckBuyingPower, rejection.Reasons[0].Check)
+	assert.Equal(t, risk.CheckMaxNotional, rejection.Reasons[1].Check)
+	mockRepo.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+
+	result, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 150, Quantity: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAccepted, result.Order.Status)
+}
+
+func TestPlaceMarketOrderValuedAgainstDepth(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithRiskChecks(risk.NewPipeline(risk.MaxNotional(1000))))
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 5})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 260, Quantity: 1})
+	assert.NoError(t, err)
+
+	// 6 at the best ask would be 900, but the sixth share comes from the 260 level
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 6})
+	assert.IsType(t, &risk.RejectionError{}, err)
+
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Type: TypeMarket, Quantity: 5})
+	assert.NoError(t, err)
+}
+
+func TestAmendOrderRiskChecksExcludeAmendedOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	riskRepo := new(risk.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(),
+		WithRiskChecks(risk.NewPipeline(risk.SellQuantity(riskRepo))))
+	expectCreateOrder(mockRepo)
+	mockRepo.On("SaveExecution", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.Background()
+	riskRepo.On("GetSellableQuantity", ctx, int64(1), "AAPL").Return(100, nil)
+	riskRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64(nil)).Return(0, nil)
+	riskRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64{1}).Return(20, nil)
+	riskRepo.On("IsShortingEnabled", ctx, int64(1)).Return(false, nil)
+
+	placed, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Price: 150, Quantity: 50})
+	assert.NoError(t, err)
+
+	stored := placed.Order
+	mockRepo.On("GetOrder", ctx, stored.ID).Return(&stored, nil)
+
+	quantity := 81
+	_, err = service.AmendOrder(ctx, 1, stored.ID, &AmendOrderRequest{Quantity: &quantity})
+	assert.IsType(t, &risk.RejectionError{}, err)
+
+	quantity = 80
+	result, err := service.AmendOrder(ctx, 1, stored.ID, &AmendOrderRequest{Quantity: &quantity})
+	assert.NoError(t, err)
+	assert.Equal(t, 80, result.Order.Quantity)
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	events := []OrderEvent{
+		{ID: 1, OrderID: 1, ToStatus: StatusNew},
+		{ID: 2, OrderID: 1, FromStatus: StatusNew, ToStatus: StatusAccepted},
+		{ID: 3, OrderID: 1, FromStatus: StatusAccepted, ToStatus: StatusCancelled, Reason: "cancelled by user"},
+	}
+	mockRepo.On("GetOrder", ctx, int64(1)).Return(&Order{ID: 1, UserID: 1, Status: StatusCancelled}, nil)
+	mockRepo.On("GetOrderEvents", ctx, int64(1)).Return(events, nil)
+
+	history, err := service.GetOrderHistory(ctx, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, events, history)
+
+	_, err = service.GetOrderHistory(ctx, 2, 1)
+	assert.Equal(t, ErrOrderNotFound, err)
+	mockRepo.AssertNumberOfCalls(t, "GetOrderEvents", 1)
+}
+
+func TestGetOrderbookReportsPositionsPNL(t *testing.T) {
+	mockRepo := new(MockRepository)
+	positionsRepo := new(positions.MockRepository)
+	service := NewService(mockRepo, matching.NewEngine(), WithPositions(positions.NewService(positionsRepo)))
+
+	ctx := context.Background()
+	mockRepo.On("ListOrders", ctx, int64(1), mock.Anything).Return([]Order{}, nil)
+	positionsRepo.On("GetPositions", ctx, int64(1)).Return([]positions.Position{
+		{Symbol: "AAPL", Quantity: 10, EntryPrice: 150, CurrentPrice: 155, RealizedPNL: 12},
+	}, nil)
+
+	page, err := service.GetOrderbook(ctx, 1, &OrderbookQuery{})
+	assert.NoError(t, err)
+	assert.InDelta(t, 50.0, page.PNL.TotalUnrealizedPNL, 1e-9)
+	assert.InDelta(t, 12.0, page.PNL.TotalRealizedPNL, 1e-9)
+	assert.InDelta(t, 62.0, page.PNL.TotalPNL, 1e-9)
+}
+
+func TestGetOrderbookPaginates(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	orders := []Order{
+		{ID: 3, Symbol: "AAPL", Status: StatusFilled, CreatedAt: "2024-02-20T12:00:02Z"},
+		{ID: 2, Symbol: "AAPL", Status: StatusCancelled, CreatedAt: "2024-02-20T12:00:01Z"},
+		{ID: 1, Symbol: "AAPL", Status: StatusFilled, CreatedAt: "2024-02-20T12:00:00Z"},
+	}
+	mockRepo.On("ListOrders", ctx, int64(1), mock.MatchedBy(func(f *OrderFilter) bool {
+		return f.After == nil && f.Limit == 3 && f.Symbol == "AAPL" &&
+			len(f.Statuses) == 2 && f.To.Equal(time.Date(2024, 2, 21, 0, 0, 0, 0, time.UTC))
+	})).Return(orders, nil).Once()
+
+	page, err := service.GetOrderbook(ctx, 1, &OrderbookQuery{Status: "filled, cancelled", Symbol: "aapl", To: "2024-02-20", Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page.Orders, 2)
+	assert.NotEmpty(t, page.NextCursor)
+
+	mockRepo.On("ListOrders", ctx, int64(1), mock.MatchedBy(func(f *OrderFilter) bool {
+		return f.After != nil && f.After.ID == 2 && f.After.CreatedAt.Equal(time.Date(2024, 2, 20, 12, 0, 1, 0, time.UTC))
+	})).Return(orders[2:], nil).Once()
+
+	page, err = service.GetOrderbook(ctx, 1, &OrderbookQuery{Status: "filled,cancelled", Symbol: "AAPL", To: "2024-02-20", Limit: 2, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []Order{orders[2]}, page.Orders)
+	assert.Empty(t, page.NextCursor)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetOrderbookValidation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+
+	ctx := context.Background()
+	cursor := (&orderCursor{ID: 5}).encode()
+	for _, tc := range []struct {
+		query OrderbookQuery
+		err   error
+	}{
+		{OrderbookQuery{Status: "pending"}, ErrInvalidStatusFilter},
+		{OrderbookQuery{Side: "short"}, ErrInvalidSide},
+		{OrderbookQuery{From: "yesterday"}, ErrInvalidDateRange},
+		{OrderbookQuery{From: "2024-02-21", To: "2024-02-20"}, ErrInvalidDateRange},
+		{OrderbookQuery{Sort: "price"}, ErrInvalidSort},
+		{OrderbookQuery{Limit: maxOrderPageSize + 1}, ErrInvalidLimit},
+		{OrderbookQuery{Cursor: "not-a-cursor"}, ErrInvalidCursor},
+		{OrderbookQuery{Cursor: cursor, Sort: "oldest"}, ErrInvalidCursor},
+	} {
+		_, err := service.GetOrderbook(ctx, 1, &tc.query)
+		assert.Equal(t, tc.err, err, "%+v", tc.query)
+	}
+	mockRepo.AssertNotCalled(t, "ListOrders", mock.Anything, mock.Anything, mock.Anything)
+}
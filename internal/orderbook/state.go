@@ -0,0 +1,40 @@
+package orderbook
+
+import "fmt"
+
+// transitions lists the statuses an order may move to from each status.
+// Filled, cancelled, rejected and expired orders are final.
+//
+//	new ----------> accepted -> partially_filled -> filled
+//	 |  \             ^    |               |
+//	 |   -> queued ---+    +---------------+--> cancelled / expired
+//	 |        |
+//	 +--------+--> cancelled / rejected (queued orders may also expire)
+//
+// An open order may also stay in its status, which records amendments,
+// triggered stops and further partial fills.
+var transitions = map[string][]string{
+	StatusNew:             {StatusNew, StatusQueued, StatusAccepted, StatusCancelled, StatusRejected},
+	StatusQueued:          {StatusQueued, StatusAccepted, StatusCancelled, StatusRejected, StatusExpired},
+	StatusAccepted:        {StatusAccepted, StatusPartiallyFilled, StatusFilled, StatusCancelled, StatusExpired},
+	StatusPartiallyFilled: {StatusPartiallyFilled, StatusFilled, StatusCancelled, StatusExpired},
+}
+
+// CanTransition reports whether an order in status from may move to status to
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition returns ErrIllegalTransition if order may not move from one
+// status to the other
+func checkTransition(orderID int64, from, to string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: order %d from %s to %s", ErrIllegalTransition, orderID, from, to)
+	}
+	return nil
+}
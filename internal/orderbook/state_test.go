@@ -0,0 +1,50 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	allowed := [][2]string{
+		{StatusNew, StatusAccepted},
+		{StatusNew, StatusRejected},
+		{StatusNew, StatusCancelled},
+		{StatusNew, StatusQueued},
+		{StatusQueued, StatusAccepted},
+		{StatusQueued, StatusExpired},
+		{StatusAccepted, StatusPartiallyFilled},
+		{StatusAccepted, StatusFilled},
+		{StatusAccepted, StatusExpired},
+		{StatusPartiallyFilled, StatusPartiallyFilled},
+		{StatusPartiallyFilled, StatusCancelled},
+	}
+	for _, tr := range allowed {
+		assert.True(t, CanTransition(tr[0], tr[1]), "%s to %s", tr[0], tr[1])
+	}
+
+	illegal := [][2]string{
+		{StatusNew, StatusFilled},
+		{StatusQueued, StatusFilled},
+		{StatusAccepted, StatusNew},
+		{StatusAccepted, StatusRejected},
+		{StatusPartiallyFilled, StatusAccepted},
+		{StatusFilled, StatusCancelled},
+		{StatusCancelled, StatusAccepted},
+		{StatusExpired, StatusExpired},
+		{"pending", StatusAccepted},
+	}
+	for _, tr := range illegal {
+		assert.False(t, CanTransition(tr[0], tr[1]), "%s to %s", tr[0], tr[1])
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	assert.NoError(t, checkTransition(1, StatusAccepted, StatusFilled))
+
+	err := checkTransition(1, StatusFilled, StatusCancelled)
+	assert.True(t, errors.Is(err, ErrIllegalTransition))
+	assert.Contains(t, err.Error(), "order 1 from filled to cancelled")
+}
@@ -0,0 +1,126 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"brokerapp/internal/matching"
+)
+
+func isTrailing(req *CreateOrderRequest) bool {
+	return req.TrailAmount > 0 || req.TrailPercent > 0
+}
+
+// marketPrice returns the latest market price of symbol, falling back to the
+// last trade on the book before the first price has come in
+func (s *Service) marketPrice(symbol string) float64 {
+	s.mu.Lock()
+	price := s.marks[symbol]
+	s.mu.Unlock()
+
+	if price > 0 {
+		return price
+	}
+	return s.engine.LastPrice(symbol)
+}
+
+// startTrail sets the first stop price of a trailing stop order placed
+// without one. The symbol must be locked.
+func (s *Service) startTrail(symbol string, req *CreateOrderRequest) error {
+	if !isTrailing(req) || req.StopPrice != 0 {
+		return nil
+	}
+
+	price := s.marketPrice(symbol)
+	if price <= 0 {
+		return ErrNoMarketPrice
+	}
+	req.StopPrice = matching.TrailingStop(matching.Side(req.Side), price, req.TrailAmount, req.TrailPercent)
+	return nil
+}
+
+// MarkPrice moves the trailing stops on symbol after its market price changed
+// to price and triggers the ones it has reached
+func (s *Service) MarkPrice(ctx context.Context, symbol string, price float64) error {
+	if price <= 0 {
+		return nil
+	}
+
+	unlock := s.lockSymbol(symbol)
+	defer unlock()
+
+	_, err := s.change(ctx, symbol, nil, func() (*Execution, error) {
+		s.mu.Lock()
+		s.marks[symbol] = price
+		s.mu.Unlock()
+
+		res := s.engine.Mark(symbol, price)
+		if len(res.Updated) == 0 {
+			return nil, nil
+		}
+
+		exec := &Execution{}
+		for i := range res.Updated {
+			o := &res.Updated[i]
+			if o.IsTrailing() && o.IsActive() && !o.Triggered {
+				// the stop only moved
+				exec.Trails = append(exec.Trails, Trail{OrderID: o.ID, StopPrice: o.StopPrice, Price: o.Price})
+				continue
+			}
+			exec.Orders = append(exec.Orders, orderUpdate(o))
+		}
+		addTrades(exec, res.Trades)
+		addSelfTrades(exec, res.SelfTrades)
+		s.runGroups(exec, res.Updated)
+		return exec, nil
+	})
+	if err != nil {
+		log.Printf("Error saving trailing stops for %s: %v", symbol, err)
+		return err
+	}
+	return nil
+}
+
+// MarkPrices passes the latest price of every symbol in positions.current_price
+// to MarkPrice when it has changed since the last call. A symbol whose stops
+// cannot be saved is tried again on the next call, without holding up the
+// others.
+func (s *Service) MarkPrices(ctx context.Context) error {
+	prices, err := s.repo.GetMarkPrices(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for symbol, price := range prices {
+		s.mu.Lock()
+		seen := s.marks[symbol]
+		s.mu.Unlock()
+		if price == seen {
+			continue
+		}
+		if err := s.MarkPrice(ctx, symbol, price); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RunMarkPricer calls MarkPrices every interval until ctx is cancelled
+func (s *Service) RunMarkPricer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.MarkPrices(ctx); err != nil {
+				log.Printf("Error updating trailing stops: %v", err)
+			}
+		}
+	}
+}
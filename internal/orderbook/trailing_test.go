@@ -0,0 +1,121 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"brokerapp/internal/matching"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPlaceTrailingStopStartsFromMarketPrice(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	req := &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Type: TypeStop, TrailPercent: 5, Quantity: 10, TimeInForce: TIFGoodTillCancel}
+	_, err := service.PlaceOrder(ctx, 1, req)
+	assert.Equal(t, ErrNoMarketPrice, err)
+
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 150))
+	result, err := service.PlaceOrder(ctx, 1, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 142.5, result.Order.StopPrice)
+	assert.Equal(t, 5.0, result.Order.TrailPercent)
+}
+
+func TestMarkPriceMovesAndTriggersTrailingStops(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Type: TypeStop, StopPrice: 145, TrailAmount: 5, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+	_, err = service.PlaceOrder(ctx, 2, &CreateOrderRequest{Symbol: "AAPL", Side: "buy", Price: 149, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Trails: []Trail{{OrderID: 1, StopPrice: 151}}}).Return(nil).Once()
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 156))
+
+	// moving back does not move the stop until the price reaches it
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 153))
+	mockRepo.On("SaveExecution", mock.Anything, mock.MatchedBy(func(exec *Execution) bool {
+		return len(exec.Trades) == 1 && exec.Trades[0].SellOrderID == 1 && exec.Trades[0].Price == 149 &&
+			len(exec.Orders) == 2 && exec.Orders[0].OrderID == 2 && exec.Orders[0].Status == StatusFilled &&
+			exec.Orders[1].OrderID == 1 && exec.Orders[1].Triggered && exec.Orders[1].Status == StatusFilled
+	})).Return(nil).Once()
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 150.5))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMarkPricesSkipsUnchangedPrices(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	assert.NoError(t, service.MarkPrice(ctx, "AAPL", 150))
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Type: TypeStop, TrailAmount: 5, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+	assert.NoError(t, err)
+
+	mockRepo.On("GetMarkPrices", ctx).Return(map[string]float64{"AAPL": 150}, nil).Once()
+	assert.NoError(t, service.MarkPrices(ctx))
+
+	mockRepo.On("GetMarkPrices", ctx).Return(map[string]float64{"AAPL": 152}, nil).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Trails: []Trail{{OrderID: 1, StopPrice: 147}}}).Return(nil).Once()
+	assert.NoError(t, service.MarkPrices(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMarkPricesCarriesOnPastAFailedSymbol(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, matching.NewEngine())
+	expectCreateOrder(mockRepo)
+	expectAccepted(mockRepo)
+
+	ctx := context.Background()
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		assert.NoError(t, service.MarkPrice(ctx, symbol, 150))
+		_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: symbol, Side: "sell", Type: TypeStop, TrailAmount: 5, Quantity: 10, TimeInForce: TIFGoodTillCancel})
+		assert.NoError(t, err)
+	}
+
+	dbErr := errors.New("lock wait timeout")
+	mockRepo.On("GetMarkPrices", ctx).Return(map[string]float64{"AAPL": 152, "MSFT": 152}, nil).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Trails: []Trail{{OrderID: 1, StopPrice: 147}}}).Return(dbErr).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Trails: []Trail{{OrderID: 2, StopPrice: 147}}}).Return(nil).Once()
+	assert.ErrorIs(t, service.MarkPrices(ctx), dbErr)
+
+	// AAPL is tried again on the next call, MSFT has already moved
+	mockRepo.On("GetMarkPrices", ctx).Return(map[string]float64{"AAPL": 152}, nil).Once()
+	mockRepo.On("SaveExecution", mock.Anything, &Execution{Trails: []Trail{{OrderID: 1, StopPrice: 147}}}).Return(nil).Once()
+	assert.NoError(t, service.MarkPrices(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPlaceTrailingStopValidation(t *testing.T) {
+	service := NewService(new(MockRepository), matching.NewEngine())
+	ctx := context.Background()
+
+	for _, req := range []*CreateOrderRequest{
+		{Symbol: "AAPL", Side: "sell", Price: 150, TrailAmount: 5, Quantity: 10},
+		{Symbol: "AAPL", Side: "sell", Type: TypeStop, StopPrice: 140, TrailAmount: 5, TrailPercent: 2, Quantity: 10},
+		{Symbol: "AAPL", Side: "sell", Type: TypeStop, StopPrice: 140, TrailPercent: 100, Quantity: 10},
+		{Symbol: "AAPL", Side: "sell", Type: TypeStop, StopPrice: 140, TrailAmount: -1, Quantity: 10},
+	} {
+		_, err := service.PlaceOrder(ctx, 1, req)
+		assert.Equal(t, ErrInvalidTrail, err)
+	}
+
+	// a trailing stop_limit needs a stop price to keep its limit price from
+	_, err := service.PlaceOrder(ctx, 1, &CreateOrderRequest{Symbol: "AAPL", Side: "sell", Type: TypeStopLimit, Price: 139, TrailAmount: 5, Quantity: 10})
+	assert.Equal(t, ErrInvalidStopPrice, err)
+}
@@ -0,0 +1,79 @@
+package performance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"brokerapp/internal/positions"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Post("/deposits", h.Deposit)
+	r.Post("/withdrawals", h.Withdraw)
+	r.Get("/analytics/performance", h.GetPerformance)
+}
+
+func (h *Handler) Deposit(w http.ResponseWriter, r *http.Request) {
+	h.saveCashFlow(w, r, h.service.Deposit)
+}
+
+func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
+	h.saveCashFlow(w, r, h.service.Withdraw)
+}
+
+func (h *Handler) saveCashFlow(w http.ResponseWriter, r *http.Request, save func(ctx context.Context, userID int64, amount float64) (*CashFlow, error)) {
+	userID := r.Context().Value("user_id").(int64)
+
+	var req CashFlowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	flow, err := save(r.Context(), userID, req.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidAmount):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrInsufficientCash):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, "Failed to save cash flow", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(flow)
+}
+
+func (h *Handler) GetPerformance(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	query := r.URL.Query()
+	perf, err := h.service.GetPerformance(r.Context(), userID, query.Get("from"), query.Get("to"))
+	if err != nil {
+		if errors.Is(err, positions.ErrInvalidDateRange) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to compute performance", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(perf)
+}
@@ -0,0 +1,166 @@
+package performance
+
+import (
+	"math"
+	"time"
+)
+
+// tradingDays is how many daily returns make up a year
+const tradingDays = 252
+
+// dailyReturn is the return of the account from one snapshot to the next
+type dailyReturn struct {
+	date time.Time
+	rate float64
+}
+
+// netFlows adds up the cash flows between each snapshot and the one before
+// it. A flow belongs to the first snapshot taken after it was made; flows
+// is ordered oldest first.
+func netFlows(values []Valuation, flows []CashFlow) []float64 {
+	net := make([]float64, len(values))
+	j := 0
+	for i := 1; i < len(values); i++ {
+		for ; j < len(flows) && !flows[j].CreatedAt.After(values[i].TakenAt); j++ {
+			if flows[j].CreatedAt.After(values[i-1].TakenAt) {
+				net[i] += flows[j].Amount
+			}
+		}
+	}
+	return net
+}
+
+// dailyReturns works out the return of every day from the value it started
+// with and the flows made during it, which are treated as coming in at the
+// start of the day. Days that start with nothing in the account have no
+// return.
+func dailyReturns(values []Valuation, net []float64) []dailyReturn {
+	var returns []dailyReturn
+	for i := 1; i < len(values); i++ {
+		invested := values[i-1].Value + net[i]
+		if invested <= 0 {
+			continue
+		}
+		returns = append(returns, dailyReturn{date: values[i].Date, rate: values[i].Value/invested - 1})
+	}
+	return returns
+}
+
+// timeWeightedReturn chains the daily returns, so the size and timing of cash
+// flows make no difference
+func timeWeightedReturn(returns []dailyReturn) float64 {
+	growth := 1.0
+	for _, r := range returns {
+		growth *= 1 + r.rate
+	}
+	return growth - 1
+}
+
+// moneyWeightedReturn is the rate over the whole period at which the starting
+// value and every flow grow to the end value. Flows are dated at the start of
+// the day they were made, as for the daily returns. It is zero when nothing
+// was put in or no rate fits.
+func moneyWeightedReturn(values []Valuation, net []float64) float64 {
+	n := len(values)
+	if n < 2 {
+		return 0
+	}
+	start, end := values[0].Date, values[n-1].Date
+	span := end.Sub(start).Hours()
+	if span <= 0 {
+		return 0
+	}
+
+	type flow struct {
+		amount float64
+		at     float64 // fraction of the period gone
+	}
+	flows := []flow{{amount: values[0].Value}}
+	for i := 1; i < n; i++ {
+		if net[i] != 0 {
+			flows = append(flows, flow{amount: net[i], at: values[i-1].Date.Sub(start).Hours() / span})
+		}
+	}
+
+	// shortfall is what the flows grow to at rate, less the end value. It
+	// rises with rate as long as more has been put in than taken out.
+	shortfall := func(rate float64) float64 {
+		total := -values[n-1].Value
+		for _, f := range flows {
+			total += f.amount * math.Pow(1+rate, 1-f.at)
+		}
+		return total
+	}
+
+	lo, hi := -0.999999, 1.0
+	for shortfall(hi) < 0 && hi < 1e9 {
+		hi *= 2
+	}
+	if shortfall(lo) > 0 || shortfall(hi) < 0 {
+		return 0
+	}
+	for i := 0; i < 200 && hi-lo > 1e-12; i++ {
+		mid := (lo + hi) / 2
+		if shortfall(mid) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// maxDrawdown returns the largest fall of the time-weighted growth of the
+// account from a peak, with the dates of the peak and the trough it fell to.
+// Measuring the growth rather than the value keeps withdrawals from showing
+// up as drawdowns.
+func maxDrawdown(start time.Time, returns []dailyReturn) (float64, time.Time, time.Time) {
+	var drawdown float64
+	var peakAt, troughAt time.Time
+
+	growth, peak, at := 1.0, 1.0, start
+	for _, r := range returns {
+		growth *= 1 + r.rate
+		if growth > peak {
+			peak, at = growth, r.date
+			continue
+		}
+		if fall := 1 - growth/peak; fall > drawdown {
+			drawdown, peakAt, troughAt = fall, at, r.date
+		}
+	}
+	return drawdown, peakAt, troughAt
+}
+
+// meanAndDeviation returns the mean of the daily returns and their sample
+// standard deviation, which is zero for fewer than two returns
+func meanAndDeviation(returns []dailyReturn) (float64, float64) {
+	if len(returns) == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, r := range returns {
+		sum += r.rate
+	}
+	mean := sum / float64(len(returns))
+	if len(returns) < 2 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, r := range returns {
+		squares += (r.rate - mean) * (r.rate - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(returns)-1))
+}
+
+// sharpeRatio annualises the mean daily return over riskFreeRate, a yearly
+// rate, per unit of daily volatility. It is zero when the returns do not
+// vary.
+func sharpeRatio(mean, deviation, riskFreeRate float64) float64 {
+	if deviation == 0 {
+		return 0
+	}
+	return (mean - riskFreeRate/tradingDays) / deviation * math.Sqrt(tradingDays)
+}
@@ -0,0 +1,33 @@
+package performance
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) SaveCashFlow(ctx context.Context, userID int64, flow *CashFlow) error {
+	args := m.Called(ctx, userID, flow)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetCashFlows(ctx context.Context, userID int64, after, until time.Time) ([]CashFlow, error) {
+	args := m.Called(ctx, userID, after, until)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]CashFlow), args.Error(1)
+}
+
+func (m *MockRepository) GetValuations(ctx context.Context, userID int64, from, to string) ([]Valuation, error) {
+	args := m.Called(ctx, userID, from, to)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Valuation), args.Error(1)
+}
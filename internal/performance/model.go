@@ -0,0 +1,69 @@
+package performance
+
+import (
+	"errors"
+	"time"
+)
+
+// Cash flow types
+const (
+	FlowDeposit    = "deposit"
+	FlowWithdrawal = "withdrawal"
+	// FlowTransfer is a holding brought into the account, valued at the price
+	// it was recorded at
+	FlowTransfer = "transfer"
+)
+
+// CashFlow is money or securities moved into or out of an account. Amount is
+// negative when value leaves it.
+type CashFlow struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CashFlowRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+// Valuation is what an account was worth in its end of day snapshot for
+// Date, taken at TakenAt
+type Valuation struct {
+	Date    time.Time
+	Value   float64
+	TakenAt time.Time
+}
+
+// Performance describes how an account did over a period of daily snapshots.
+// Returns, the drawdown and the volatility are percentages; cash flows are
+// taken out of all of them.
+type Performance struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Days is the number of daily returns the statistics are worked out from
+	Days int `json:"days"`
+
+	StartValue   float64 `json:"start_value"`
+	EndValue     float64 `json:"end_value"`
+	NetCashFlows float64 `json:"net_cash_flows"`
+	Gain         float64 `json:"gain"`
+
+	TimeWeightedReturn  float64 `json:"time_weighted_return"`
+	MoneyWeightedReturn float64 `json:"money_weighted_return"`
+
+	MaxDrawdown       float64 `json:"max_drawdown"`
+	MaxDrawdownPeak   string  `json:"max_drawdown_peak,omitempty"`
+	MaxDrawdownTrough string  `json:"max_drawdown_trough,omitempty"`
+
+	AnnualisedVolatility float64 `json:"annualised_volatility"`
+	SharpeRatio          float64 `json:"sharpe_ratio"`
+	RiskFreeRate         float64 `json:"risk_free_rate"`
+}
+
+var (
+	ErrInvalidAmount    = errors.New("amount must be greater than zero")
+	ErrInsufficientCash = errors.New("insufficient cash")
+	ErrPositionHeld     = errors.New("the account already has a position in this symbol, so its shares cannot be transferred in as a holding")
+)
@@ -0,0 +1,169 @@
+package performance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"brokerapp/internal/db"
+)
+
+type MySQLRepository struct {
+	db     *db.MySQL
+	orders OpenBuyOrders
+}
+
+func NewMySQLRepository(db *db.MySQL, orders OpenBuyOrders) *MySQLRepository {
+	return &MySQLRepository{db: db, orders: orders}
+}
+
+// SaveCashFlow records flow and moves its amount into or out of the user's
+// cash balance. A withdrawal may not take more than is left once the user's
+// open buy orders are paid for, nor ever take the balance below zero. The
+// flow's ID and time are set from the stored row.
+func (r *MySQLRepository) SaveCashFlow(ctx context.Context, userID int64, flow *CashFlow) error {
+	return r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		if flow.Amount < 0 {
+			var cash float64
+			err := tx.QueryRowContext(ctx, `
+				SELECT cash_balance FROM accounts WHERE user_id = ? FOR UPDATE
+			`, userID).Scan(&cash)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			committed, err := r.orders.GetOpenBuyNotional(ctx, userID, nil)
+			if err != nil {
+				return err
+			}
+			if cash-committed < -flow.Amount {
+				return ErrInsufficientCash
+			}
+
+			result, err := tx.ExecContext(ctx, `
+				UPDATE accounts SET cash_balance = cash_balance + ?
+				WHERE user_id = ? AND cash_balance + ? >= 0
+			`, flow.Amount, userID, flow.Amount)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return ErrInsufficientCash
+			}
+			return insertCashFlow(ctx, tx, userID, flow)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO accounts (user_id, cash_balance) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE cash_balance = cash_balance + VALUES(cash_balance)
+		`, userID, flow.Amount)
+		if err != nil {
+			return err
+		}
+
+		return insertCashFlow(ctx, tx, userID, flow)
+	})
+}
+
+// RecordTransfer records a holding in symbol of the given value brought into
+// the user's account as part of tx, without touching their cash. It fails
+// with ErrPositionHeld if the user has a position in symbol: snapshots leave
+// holdings in those symbols out of the account's value, so the transfer
+// would show up as a loss.
+func RecordTransfer(ctx context.Context, tx *sql.Tx, userID int64, symbol string, value float64) error {
+	var positioned bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM positions WHERE user_id = ? AND symbol = ?)
+	`, userID, symbol).Scan(&positioned)
+	if err != nil {
+		return err
+	}
+	if positioned {
+		return ErrPositionHeld
+	}
+	return insertCashFlow(ctx, tx, userID, &CashFlow{Type: FlowTransfer, Amount: value})
+}
+
+func insertCashFlow(ctx context.Context, tx *sql.Tx, userID int64, flow *CashFlow) error {
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO cash_flows (user_id, type, amount) VALUES (?, ?, ?)
+	`, userID, flow.Type, flow.Amount)
+	if err != nil {
+		return err
+	}
+
+	flow.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return tx.QueryRowContext(ctx, `SELECT created_at FROM cash_flows WHERE id = ?`, flow.ID).Scan(&flow.CreatedAt)
+}
+
+// GetCashFlows returns the user's cash flows made after one time up to and
+// including another, oldest first
+func (r *MySQLRepository) GetCashFlows(ctx context.Context, userID int64, after, until time.Time) ([]CashFlow, error) {
+	query := `
+		SELECT id, type, amount, created_at
+		FROM cash_flows
+		WHERE user_id = ? AND created_at > ? AND created_at <= ?
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, after, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flows []CashFlow
+	for rows.Next() {
+		var f CashFlow
+		if err := rows.Scan(&f.ID, &f.Type, &f.Amount, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		flows = append(flows, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+// GetValuations returns the total value of the user's end of day snapshots
+// from one date to another, inclusive, oldest first. A snapshot was taken when
+// its balances were read; one saved before that was recorded is taken to have
+// been taken when it was last saved.
+func (r *MySQLRepository) GetValuations(ctx context.Context, userID int64, from, to string) ([]Valuation, error) {
+	query := `
+		SELECT date, total_value, COALESCE(taken_at, updated_at)
+		FROM pnl_history
+		WHERE user_id = ? AND date BETWEEN ? AND ?
+		ORDER BY date
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var valuations []Valuation
+	for rows.Next() {
+		var v Valuation
+		if err := rows.Scan(&v.Date, &v.Value, &v.TakenAt); err != nil {
+			return nil, err
+		}
+		valuations = append(valuations, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return valuations, nil
+}
@@ -0,0 +1,19 @@
+package performance
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	SaveCashFlow(ctx context.Context, userID int64, flow *CashFlow) error
+	GetCashFlows(ctx context.Context, userID int64, after, until time.Time) ([]CashFlow, error)
+	GetValuations(ctx context.Context, userID int64, from, to string) ([]Valuation, error)
+}
+
+// OpenBuyOrders values the cash a user's open buy orders have committed. The
+// risk repository implements it, so withdrawals and buy orders agree on what
+// is left to spend.
+type OpenBuyOrders interface {
+	GetOpenBuyNotional(ctx context.Context, userID int64, excludeOrderIDs []int64) (float64, error)
+}
@@ -0,0 +1,150 @@
+package performance
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"brokerapp/internal/positions"
+)
+
+type Service struct {
+	repo Repository
+
+	// riskFreeRate is the yearly percentage the Sharpe ratio is measured against
+	riskFreeRate float64
+
+	// loc is the time zone of the snapshot dates, for the default period
+	loc *time.Location
+	now func() time.Time
+
+	// lockUser serialises withdrawals with the user's buy orders, so both
+	// cannot spend the same cash; nil takes no lock
+	lockUser func(userID int64) func()
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithRiskFreeRate measures the Sharpe ratio against a yearly rate given in percent
+func WithRiskFreeRate(percent float64) Option {
+	return func(s *Service) {
+		s.riskFreeRate = percent
+	}
+}
+
+// WithLocation sets the time zone that today's date is taken in
+func WithLocation(loc *time.Location) Option {
+	return func(s *Service) {
+		s.loc = loc
+	}
+}
+
+// WithClock replaces the time source used for the default period
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+// WithUserLock takes lock, which returns the function that releases it,
+// around each withdrawal. The orderbook's LockUser is the lock buy orders are
+// checked against the user's cash under.
+func WithUserLock(lock func(userID int64) func()) Option {
+	return func(s *Service) {
+		s.lockUser = lock
+	}
+}
+
+func NewService(repo Repository, opts ...Option) *Service {
+	s := &Service{repo: repo, loc: time.UTC, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Deposit adds amount to the user's cash
+func (s *Service) Deposit(ctx context.Context, userID int64, amount float64) (*CashFlow, error) {
+	if !validAmount(amount) {
+		return nil, ErrInvalidAmount
+	}
+	return s.saveCashFlow(ctx, userID, &CashFlow{Type: FlowDeposit, Amount: amount})
+}
+
+// Withdraw takes amount out of the user's cash
+func (s *Service) Withdraw(ctx context.Context, userID int64, amount float64) (*CashFlow, error) {
+	if !validAmount(amount) {
+		return nil, ErrInvalidAmount
+	}
+	if s.lockUser != nil {
+		unlock := s.lockUser(userID)
+		defer unlock()
+	}
+	return s.saveCashFlow(ctx, userID, &CashFlow{Type: FlowWithdrawal, Amount: -amount})
+}
+
+func (s *Service) saveCashFlow(ctx context.Context, userID int64, flow *CashFlow) (*CashFlow, error) {
+	if err := s.repo.SaveCashFlow(ctx, userID, flow); err != nil {
+		return nil, err
+	}
+	return flow, nil
+}
+
+func validAmount(amount float64) bool {
+	return amount > 0 && !math.IsInf(amount, 1)
+}
+
+// GetPerformance works out how the user's account did over the end of day
+// snapshots from one date to another, both inclusive, defaulting as
+// positions.ParseDateRange does. Cash flows between the first and the last
+// snapshot are taken out of every figure.
+func (s *Service) GetPerformance(ctx context.Context, userID int64, from, to string) (*Performance, error) {
+	from, to, err := positions.ParseDateRange(from, to, s.now().In(s.loc))
+	if err != nil {
+		return nil, err
+	}
+
+	perf := &Performance{From: from, To: to, RiskFreeRate: s.riskFreeRate}
+	values, err := s.repo.GetValuations(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return perf, nil
+	}
+
+	first, last := values[0], values[len(values)-1]
+	var flows []CashFlow
+	if len(values) > 1 {
+		flows, err = s.repo.GetCashFlows(ctx, userID, first.TakenAt, last.TakenAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	net := netFlows(values, flows)
+	for _, amount := range net {
+		perf.NetCashFlows += amount
+	}
+	perf.StartValue = first.Value
+	perf.EndValue = last.Value
+	perf.Gain = last.Value - first.Value - perf.NetCashFlows
+
+	returns := dailyReturns(values, net)
+	perf.Days = len(returns)
+	perf.TimeWeightedReturn = timeWeightedReturn(returns) * 100
+	perf.MoneyWeightedReturn = moneyWeightedReturn(values, net) * 100
+
+	drawdown, peak, trough := maxDrawdown(first.Date, returns)
+	if drawdown > 0 {
+		perf.MaxDrawdown = drawdown * 100
+		perf.MaxDrawdownPeak = peak.Format(positions.DateLayout)
+		perf.MaxDrawdownTrough = trough.Format(positions.DateLayout)
+	}
+
+	mean, deviation := meanAndDeviation(returns)
+	perf.AnnualisedVolatility = deviation * math.Sqrt(tradingDays) * 100
+	perf.SharpeRatio = sharpeRatio(mean, deviation, s.riskFreeRate/100)
+	return perf, nil
+}
@@ -0,0 +1,124 @@
+package performance
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// snapshot is the valuation for the given day of February 2024, taken at 16:30 in New York
+func snapshot(day int, value float64) Valuation {
+	date := time.Date(2024, 2, day, 0, 0, 0, 0, time.UTC)
+	return Valuation{Date: date, Value: value, TakenAt: date.Add(21*time.Hour + 30*time.Minute)}
+}
+
+func TestGetPerformance(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo)
+
+	ctx := context.Background()
+	values := []Valuation{
+		snapshot(19, 1000),
+		snapshot(20, 1100), // up 10%
+		snapshot(21, 2310), // up 10% on 1100 and the 1000 deposited the evening before
+		snapshot(22, 2079), // down 10%
+	}
+	mockRepo.On("GetValuations", ctx, int64(1), "2024-02-01", "2024-02-29").Return(values, nil)
+	mockRepo.On("GetCashFlows", ctx, int64(1), values[0].TakenAt, values[3].TakenAt).Return([]CashFlow{
+		{Type: FlowDeposit, Amount: 1000, CreatedAt: values[1].TakenAt.Add(30 * time.Minute)},
+	}, nil)
+
+	perf, err := service.GetPerformance(ctx, 1, "2024-02-01", "2024-02-29")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, perf.Days)
+	assert.Equal(t, 1000.0, perf.NetCashFlows)
+	assert.InDelta(t, 79.0, perf.Gain, 1e-9)
+	assert.InDelta(t, (1.1*1.1*0.9-1)*100, perf.TimeWeightedReturn, 1e-9)
+
+	// the start value and the deposit, made at the start of the second of
+	// three days, grow to the end value at the money-weighted return
+	rate := 1 + perf.MoneyWeightedReturn/100
+	assert.InDelta(t, 2079.0, 1000*rate+1000*math.Pow(rate, 2.0/3), 1e-6)
+
+	assert.InDelta(t, 10.0, perf.MaxDrawdown, 1e-9)
+	assert.Equal(t, "2024-02-21", perf.MaxDrawdownPeak)
+	assert.Equal(t, "2024-02-22", perf.MaxDrawdownTrough)
+
+	deviation := math.Sqrt(0.04 / 3)
+	assert.InDelta(t, deviation*math.Sqrt(252)*100, perf.AnnualisedVolatility, 1e-9)
+	assert.InDelta(t, 0.1/3/deviation*math.Sqrt(252), perf.SharpeRatio, 1e-9)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDepositsAreNotReturns(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, WithRiskFreeRate(5))
+
+	ctx := context.Background()
+	values := []Valuation{snapshot(19, 0), snapshot(20, 5000), snapshot(21, 3000)}
+	mockRepo.On("GetValuations", ctx, int64(1), "2024-02-19", "2024-02-21").Return(values, nil)
+	mockRepo.On("GetCashFlows", ctx, int64(1), values[0].TakenAt, values[2].TakenAt).Return([]CashFlow{
+		{Type: FlowDeposit, Amount: 5000, CreatedAt: values[0].TakenAt.Add(time.Hour)},
+		{Type: FlowWithdrawal, Amount: -2000, CreatedAt: values[1].TakenAt.Add(time.Hour)},
+	}, nil)
+
+	perf, err := service.GetPerformance(ctx, 1, "2024-02-19", "2024-02-21")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, perf.Days)
+	assert.Equal(t, 3000.0, perf.NetCashFlows)
+	assert.InDelta(t, 0.0, perf.Gain, 1e-9)
+	assert.InDelta(t, 0.0, perf.TimeWeightedReturn, 1e-9)
+	assert.InDelta(t, 0.0, perf.MoneyWeightedReturn, 1e-6)
+	assert.Equal(t, 0.0, perf.MaxDrawdown)
+	assert.Equal(t, 0.0, perf.SharpeRatio)
+	assert.Equal(t, 5.0, perf.RiskFreeRate)
+}
+
+func TestCashFlowAmounts(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("SaveCashFlow", ctx, int64(1), &CashFlow{Type: FlowWithdrawal, Amount: -250}).Return(nil).Once()
+
+	flow, err := service.Withdraw(ctx, 1, 250)
+	assert.NoError(t, err)
+	assert.Equal(t, -250.0, flow.Amount)
+
+	for _, amount := range []float64{0, -100, math.NaN(), math.Inf(1)} {
+		_, err = service.Deposit(ctx, 1, amount)
+		assert.Equal(t, ErrInvalidAmount, err)
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWithdrawHoldsUserLock(t *testing.T) {
+	mockRepo := new(MockRepository)
+	var held []int64
+	locked := false
+	service := NewService(mockRepo, WithUserLock(func(userID int64) func() {
+		held = append(held, userID)
+		locked = true
+		return func() { locked = false }
+	}))
+
+	ctx := context.Background()
+	mockRepo.On("SaveCashFlow", ctx, int64(1), &CashFlow{Type: FlowWithdrawal, Amount: -250}).
+		Run(func(mock.Arguments) { assert.True(t, locked) }).Return(nil).Once()
+	mockRepo.On("SaveCashFlow", ctx, int64(1), &CashFlow{Type: FlowDeposit, Amount: 100}).Return(nil).Once()
+
+	_, err := service.Withdraw(ctx, 1, 250)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	// deposits cannot leave buy orders short, so they do not wait
+	_, err = service.Deposit(ctx, 1, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, held)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,121 @@
+package positions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Method decides which lots a closing fill takes from and the cost its
+// realized PnL is measured against
+type Method string
+
+const (
+	// AverageCost keeps a single lot at the average price paid
+	AverageCost Method = "average"
+	// FIFO closes the oldest lots first
+	FIFO Method = "fifo"
+	// LIFO closes the newest lots first
+	LIFO Method = "lifo"
+)
+
+// ParseMethod returns the cost basis method named by value
+func ParseMethod(value string) (Method, error) {
+	switch m := Method(strings.ToLower(strings.TrimSpace(value))); m {
+	case AverageCost, FIFO, LIFO:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown cost basis method %q, expected average, fifo or lifo", value)
+}
+
+// Lot is an open part of a position at the price it was opened at. Long lots
+// have a positive quantity and short lots a negative one; the lots of a
+// position are all on the same side and ordered oldest first.
+type Lot struct {
+	ID       int64
+	Quantity int
+	Price    float64
+	OpenedAt time.Time
+}
+
+// Apply adds a fill to the open lots of a position and returns the lots left
+// open together with the PnL realized by the ones it closed. quantity is
+// positive for buys and negative for sells. A fill larger than the position
+// closes it and opens a new one on the other side with the rest.
+func (m Method) Apply(lots []Lot, quantity int, price float64, at time.Time) ([]Lot, float64) {
+	open := make([]Lot, len(lots))
+	copy(open, lots)
+
+	var realized float64
+	if held := Quantity(open); held != 0 && sign(held) != sign(quantity) {
+		closing := min(abs(quantity), abs(held))
+		if m == AverageCost {
+			// the lots are merged, so it makes no difference which ones are closed
+			realized = float64(closing) * (price - EntryPrice(open)) * float64(sign(held))
+		}
+
+		for remaining := closing; remaining > 0; {
+			i := 0
+			if m == LIFO {
+				i = len(open) - 1
+			}
+			lot := &open[i]
+			take := min(remaining, abs(lot.Quantity))
+			if m != AverageCost {
+				realized += float64(take) * (price - lot.Price) * float64(sign(lot.Quantity))
+			}
+			lot.Quantity -= take * sign(lot.Quantity)
+			remaining -= take
+			if lot.Quantity == 0 {
+				open = append(open[:i], open[i+1:]...)
+			}
+		}
+		quantity += closing * sign(held)
+	}
+
+	if quantity != 0 {
+		open = append(open, Lot{Quantity: quantity, Price: price, OpenedAt: at})
+	}
+	if m == AverageCost && len(open) > 1 {
+		open = []Lot{{ID: open[0].ID, Quantity: Quantity(open), Price: EntryPrice(open), OpenedAt: open[0].OpenedAt}}
+	}
+	return open, realized
+}
+
+// Quantity returns the size of a position, negative when it is short
+func Quantity(lots []Lot) int {
+	total := 0
+	for _, lot := range lots {
+		total += lot.Quantity
+	}
+	return total
+}
+
+// EntryPrice returns the average price the open lots were opened at, zero for
+// a flat position
+func EntryPrice(lots []Lot) float64 {
+	var cost float64
+	total := 0
+	for _, lot := range lots {
+		cost += float64(lot.Quantity) * lot.Price
+		total += lot.Quantity
+	}
+	if total == 0 {
+		return 0
+	}
+	return cost / float64(total)
+}
+
+func sign(v int) int {
+	if v < 0 {
+		return -1
+	}
+	return 1
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
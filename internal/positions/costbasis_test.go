@@ -0,0 +1,86 @@
+package positions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var day = time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC)
+
+// buyTwice opens a position of 10 at 100 and adds 10 at 110
+func buyTwice(m Method) []Lot {
+	lots, _ := m.Apply(nil, 10, 100, day)
+	lots[0].ID = 1
+	lots, _ = m.Apply(lots, 10, 110, day.Add(time.Hour))
+	return lots
+}
+
+func TestApplyAverageCost(t *testing.T) {
+	lots := buyTwice(AverageCost)
+	assert.Equal(t, []Lot{{ID: 1, Quantity: 20, Price: 105, OpenedAt: day}}, lots)
+
+	lots, realized := AverageCost.Apply(lots, -5, 120, day)
+	assert.InDelta(t, 75.0, realized, 1e-9)
+	assert.Equal(t, 15, Quantity(lots))
+	assert.Equal(t, 105.0, EntryPrice(lots))
+}
+
+func TestApplyFIFO(t *testing.T) {
+	lots, realized := FIFO.Apply(buyTwice(FIFO), -15, 120, day)
+	// 10 from the lot at 100 and 5 from the one at 110
+	assert.InDelta(t, 250.0, realized, 1e-9)
+	assert.Equal(t, []Lot{{Quantity: 5, Price: 110, OpenedAt: day.Add(time.Hour)}}, lots)
+}
+
+func TestApplyLIFO(t *testing.T) {
+	lots, realized := LIFO.Apply(buyTwice(LIFO), -15, 120, day)
+	// 10 from the lot at 110 and 5 from the one at 100
+	assert.InDelta(t, 200.0, realized, 1e-9)
+	assert.Equal(t, []Lot{{ID: 1, Quantity: 5, Price: 100, OpenedAt: day}}, lots)
+}
+
+func TestApplyThroughFlat(t *testing.T) {
+	lots, realized := FIFO.Apply([]Lot{{ID: 1, Quantity: 10, Price: 100}}, -15, 90, day)
+	assert.InDelta(t, -100.0, realized, 1e-9)
+	assert.Equal(t, []Lot{{Quantity: -5, Price: 90, OpenedAt: day}}, lots)
+
+	// buying back the short below where it was sold is a gain
+	lots, realized = FIFO.Apply(lots, 5, 80, day)
+	assert.InDelta(t, 50.0, realized, 1e-9)
+	assert.Empty(t, lots)
+	assert.Equal(t, 0.0, EntryPrice(lots))
+}
+
+func TestApplyLeavesLotsUntouched(t *testing.T) {
+	lots := []Lot{{ID: 1, Quantity: 10, Price: 100}}
+	FIFO.Apply(lots, -10, 110, day)
+	assert.Equal(t, 10, lots[0].Quantity)
+}
+
+func TestParseMethod(t *testing.T) {
+	m, err := ParseMethod(" FIFO ")
+	assert.NoError(t, err)
+	assert.Equal(t, FIFO, m)
+
+	_, err = ParseMethod("hifo")
+	assert.Error(t, err)
+}
+
+func TestApplyShortAverageCost(t *testing.T) {
+	lots, _ := AverageCost.Apply(nil, -10, 100, day)
+	lots, _ = AverageCost.Apply(lots, -10, 90, day)
+	assert.Equal(t, -20, Quantity(lots))
+	assert.Equal(t, 95.0, EntryPrice(lots))
+
+	lots, realized := AverageCost.Apply(lots, 5, 80, day)
+	assert.InDelta(t, 75.0, realized, 1e-9)
+	assert.Equal(t, -15, Quantity(lots))
+
+	p := Position{Quantity: Quantity(lots), EntryPrice: EntryPrice(lots), RealizedPNL: realized}
+	p.Mark(100)
+	assert.InDelta(t, -75.0, p.UnrealizedPNL, 1e-9)
+	assert.InDelta(t, 0.0, p.TotalPNL, 1e-9)
+	assert.InDelta(t, -75.0/1425*100, p.PNLPercentage, 1e-9)
+}
@@ -0,0 +1,129 @@
+package positions
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// TakeSnapshots saves what every user's account is worth now as their
+// snapshot for date. Positions are valued the same way GetPositions values
+// them. Taking them again the same day replaces them. A user whose snapshot
+// cannot be taken is logged and skipped without holding up the others.
+func (s *Service) TakeSnapshots(ctx context.Context, date string) error {
+	userIDs, err := s.repo.GetUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, userID := range userIDs {
+		if err := s.takeSnapshot(ctx, userID, date); err != nil {
+			log.Printf("Error taking the %s snapshot of user %d: %v", date, userID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("Could not take %d of %d snapshot(s) for %s", failed, len(userIDs), date)
+	}
+	return nil
+}
+
+func (s *Service) takeSnapshot(ctx context.Context, userID int64, date string) error {
+	b, err := s.repo.GetBalances(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	sum := Summarize(b.Positions).Summary
+	return s.repo.SaveSnapshot(ctx, userID, &Snapshot{
+		Date:           date,
+		CashBalance:    b.Cash,
+		HoldingsValue:  b.Holdings,
+		PositionsValue: sum.TotalMarketValue,
+		TotalValue:     b.Cash + b.Holdings + sum.TotalMarketValue,
+		UnrealizedPNL:  sum.TotalUnrealizedPNL,
+		RealizedPNL:    sum.TotalRealizedPNL,
+		TotalPNL:       sum.TotalPNL,
+		TakenAt:        b.TakenAt,
+	})
+}
+
+// SnapshotEndOfDay takes the day's snapshots once a weekday has ended, and
+// does nothing before then or once they are taken
+func (s *Service) SnapshotEndOfDay(ctx context.Context) error {
+	now := s.now().In(s.loc)
+	if now.Weekday() == time.Saturday || now.Weekday() == time.Sunday {
+		return nil
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
+	date := now.Format(DateLayout)
+	if now.Before(midnight.Add(s.endOfDay)) || date == s.lastSnapshot {
+		return nil
+	}
+
+	if err := s.TakeSnapshots(ctx, date); err != nil {
+		return err
+	}
+	s.lastSnapshot = date
+	return nil
+}
+
+// RunSnapshots calls SnapshotEndOfDay every interval until ctx is cancelled
+func (s *Service) RunSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.SnapshotEndOfDay(ctx); err != nil {
+				log.Printf("Error taking end of day PnL snapshots: %v", err)
+			}
+		}
+	}
+}
+
+// GetHistory returns the user's daily snapshots from one date to another,
+// both inclusive, defaulting as ParseDateRange does
+func (s *Service) GetHistory(ctx context.Context, userID int64, from, to string) (*HistoryResponse, error) {
+	from, to, err := ParseDateRange(from, to, s.now().In(s.loc))
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.repo.GetHistory(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryResponse{From: from, To: to, History: history}, nil
+}
+
+// ParseDateRange checks the from and to dates of a history request. to
+// defaults to today and from to a year before to.
+func ParseDateRange(from, to string, today time.Time) (string, string, error) {
+	end := today
+	if to != "" {
+		t, err := time.Parse(DateLayout, to)
+		if err != nil {
+			return "", "", ErrInvalidDateRange
+		}
+		end = t
+	}
+	start := end.AddDate(-1, 0, 0)
+	if from != "" {
+		t, err := time.Parse(DateLayout, from)
+		if err != nil {
+			return "", "", ErrInvalidDateRange
+		}
+		start = t
+	}
+
+	from, to = start.Format(DateLayout), end.Format(DateLayout)
+	if from > to {
+		return "", "", ErrInvalidDateRange
+	}
+	return from, to, nil
+}
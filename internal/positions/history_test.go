@@ -0,0 +1,85 @@
+package positions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSnapshotEndOfDay(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	// 16:00 in New York on a Tuesday
+	now := time.Date(2024, 2, 20, 21, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo,
+		WithEndOfDay(newYork, 16*time.Hour+30*time.Minute),
+		WithClock(func() time.Time { return now }),
+	)
+
+	ctx := context.Background()
+	mockRepo.On("GetUserIDs", ctx).Return([]int64{3, 1, 2}, nil)
+	// the first user failing does not stop the others
+	mockRepo.On("GetBalances", ctx, int64(3)).Return(nil, errors.New("connection lost"))
+	read := now.Add(time.Hour - time.Second)
+	mockRepo.On("GetBalances", ctx, int64(1)).Return(&Balances{Cash: 1000, Holdings: 500, Positions: []Position{
+		{Symbol: "AAPL", Quantity: 10, EntryPrice: 150, CurrentPrice: 160, RealizedPNL: 20},
+	}, TakenAt: read}, nil)
+	mockRepo.On("GetBalances", ctx, int64(2)).Return(&Balances{Cash: 250}, nil)
+	mockRepo.On("SaveSnapshot", ctx, int64(1), &Snapshot{
+		Date:           "2024-02-20",
+		CashBalance:    1000,
+		HoldingsValue:  500,
+		PositionsValue: 1600,
+		TotalValue:     3100,
+		UnrealizedPNL:  100,
+		RealizedPNL:    20,
+		TotalPNL:       120,
+		TakenAt:        read,
+	}).Return(nil).Once()
+	mockRepo.On("SaveSnapshot", ctx, int64(2), &Snapshot{Date: "2024-02-20", CashBalance: 250, TotalValue: 250}).Return(nil).Once()
+
+	// the day has not ended yet
+	assert.NoError(t, service.SnapshotEndOfDay(ctx))
+	mockRepo.AssertNotCalled(t, "SaveSnapshot", mock.Anything, mock.Anything, mock.Anything)
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, service.SnapshotEndOfDay(ctx))
+	assert.NoError(t, service.SnapshotEndOfDay(ctx))
+	mockRepo.AssertNumberOfCalls(t, "SaveSnapshot", 2)
+
+	// nothing is taken at weekends
+	now = time.Date(2024, 2, 24, 23, 0, 0, 0, time.UTC)
+	assert.NoError(t, service.SnapshotEndOfDay(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetHistory(t *testing.T) {
+	now := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, WithClock(func() time.Time { return now }))
+
+	ctx := context.Background()
+	history := []Snapshot{{Date: "2024-02-19", TotalValue: 1000}}
+	mockRepo.On("GetHistory", ctx, int64(1), "2023-02-20", "2024-02-20").Return(history, nil).Once()
+	mockRepo.On("GetHistory", ctx, int64(1), "2024-01-01", "2024-01-31").Return([]Snapshot{}, nil).Once()
+
+	resp, err := service.GetHistory(ctx, 1, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, &HistoryResponse{From: "2023-02-20", To: "2024-02-20", History: history}, resp)
+
+	resp, err = service.GetHistory(ctx, 1, "2024-01-01", "2024-01-31")
+	assert.NoError(t, err)
+	assert.Empty(t, resp.History)
+
+	for _, dates := range [][2]string{{"2024-02-01", "2024-01-01"}, {"yesterday", ""}, {"", "2024-02-30"}} {
+		_, err = service.GetHistory(ctx, 1, dates[0], dates[1])
+		assert.Equal(t, ErrInvalidDateRange, err)
+	}
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,182 @@
+package positions
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Ledger keeps the positions table and its lots up to date as trades settle
+type Ledger struct {
+	method Method
+}
+
+func NewLedger(method Method) *Ledger {
+	return &Ledger{method: method}
+}
+
+// Record applies a fill to the user's position in symbol as part of tx, the
+// transaction that stores the trade. quantity is positive for buys and
+// negative for sells. The position is marked at the fill price.
+//
+// A user's first fill in a symbol starts the position from their holdings in
+// it, so shares they already held keep their cost. A position stored without
+// lots, such as one loaded straight into the table, counts as a single lot of
+// its quantity at its entry price. Shares sold short are
+// counted as borrowed until the short is covered; a fill that would borrow
+// more than is left fails with ErrBorrowUnavailable.
+func (l *Ledger) Record(ctx context.Context, tx *sql.Tx, userID int64, symbol string, quantity int, price float64, at time.Time) error {
+	var (
+		realized float64
+		stored   Lot
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT realized_pnl, quantity, entry_price, created_at FROM positions WHERE user_id = ? AND symbol = ? FOR UPDATE
+	`, userID, symbol).Scan(&realized, &stored.Quantity, &stored.Price, &stored.OpenedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var lots []Lot
+	if exists {
+		lots, err = queryLots(ctx, tx, `
+			SELECT id, quantity, price, opened_at FROM position_lots
+			WHERE user_id = ? AND symbol = ?
+			ORDER BY opened_at, id
+			FOR UPDATE
+		`, userID, symbol)
+	} else {
+		lots, err = queryLots(ctx, tx, `
+			SELECT 0, quantity, price, created_at FROM holdings
+			WHERE user_id = ? AND symbol = ? AND quantity > 0
+			ORDER BY created_at, id
+		`, userID, symbol)
+	}
+	if err != nil {
+		return err
+	}
+	if exists && len(lots) == 0 && stored.Quantity != 0 {
+		lots = []Lot{stored}
+	}
+
+	open, gain := l.method.Apply(lots, quantity, price, at)
+	if err := saveLots(ctx, tx, userID, symbol, lots, open); err != nil {
+		return err
+	}
+	if err := borrow(ctx, tx, symbol, Quantity(lots), Quantity(open)); err != nil {
+		return err
+	}
+
+	p := Position{Symbol: symbol, Quantity: Quantity(open), EntryPrice: EntryPrice(open), RealizedPNL: realized + gain}
+	p.Mark(price)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO positions (user_id, symbol, quantity, entry_price, current_price, unrealized_pnl, realized_pnl, total_pnl, pnl_percentage)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			quantity = VALUES(quantity),
+			entry_price = VALUES(entry_price),
+			current_price = VALUES(current_price),
+			unrealized_pnl = VALUES(unrealized_pnl),
+			realized_pnl = VALUES(realized_pnl),
+			total_pnl = VALUES(total_pnl),
+			pnl_percentage = VALUES(pnl_percentage)
+	`, userID, symbol, p.Quantity, p.EntryPrice, p.CurrentPrice, p.UnrealizedPNL, p.RealizedPNL, p.TotalPNL, p.PNLPercentage)
+	return err
+}
+
+// borrow takes the shares a position selling further short needs from what
+// can be borrowed of symbol, and returns those a short covers. The locate is
+// checked again as the shares are taken, in the transaction that stores the
+// trade, so two short sales passing their checks at the same time cannot
+// together borrow more than is available.
+func borrow(ctx context.Context, tx *sql.Tx, symbol string, before, after int) error {
+	change := max(-after, 0) - max(-before, 0)
+	if change == 0 {
+		return nil
+	}
+	if change < 0 {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE borrow_availability SET borrowed = borrowed + ? WHERE symbol = ?
+		`, change, symbol)
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE borrow_availability SET borrowed = borrowed + ?
+		WHERE symbol = ? AND available - borrowed >= ?
+	`, change, symbol, change)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBorrowUnavailable
+	}
+	return nil
+}
+
+func queryLots(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]Lot, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []Lot
+	for rows.Next() {
+		var lot Lot
+		if err := rows.Scan(&lot.ID, &lot.Quantity, &lot.Price, &lot.OpenedAt); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+// saveLots writes the difference between the lots a position had and the
+// ones it has now
+func saveLots(ctx context.Context, tx *sql.Tx, userID int64, symbol string, before, after []Lot) error {
+	previous := make(map[int64]Lot)
+	for _, lot := range before {
+		if lot.ID != 0 {
+			previous[lot.ID] = lot
+		}
+	}
+
+	for _, lot := range after {
+		if lot.ID == 0 {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO position_lots (user_id, symbol, quantity, price, opened_at) VALUES (?, ?, ?, ?, ?)
+			`, userID, symbol, lot.Quantity, lot.Price, lot.OpenedAt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if was := previous[lot.ID]; lot.Quantity != was.Quantity || lot.Price != was.Price {
+			if _, err := tx.ExecContext(ctx, `UPDATE position_lots SET quantity = ?, price = ? WHERE id = ?`, lot.Quantity, lot.Price, lot.ID); err != nil {
+				return err
+			}
+		}
+		delete(previous, lot.ID)
+	}
+
+	// whatever is left was closed
+	for id := range previous {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM position_lots WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
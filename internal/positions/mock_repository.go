@@ -0,0 +1,61 @@
+package positions
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) GetPositions(ctx context.Context, userID int64) ([]Position, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Position), args.Error(1)
+}
+
+func (m *MockRepository) GetMarks(ctx context.Context) (map[string]float64, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]float64), args.Error(1)
+}
+
+func (m *MockRepository) SavePrice(ctx context.Context, symbol string, price float64) error {
+	args := m.Called(ctx, symbol, price)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetUserIDs(ctx context.Context) ([]int64, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *MockRepository) GetBalances(ctx context.Context, userID int64) (*Balances, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Balances), args.Error(1)
+}
+
+func (m *MockRepository) SaveSnapshot(ctx context.Context, userID int64, snapshot *Snapshot) error {
+	args := m.Called(ctx, userID, snapshot)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetHistory(ctx context.Context, userID int64, from, to string) ([]Snapshot, error) {
+	args := m.Called(ctx, userID, from, to)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Snapshot), args.Error(1)
+}
@@ -0,0 +1,74 @@
+package positions
+
+import (
+	"errors"
+	"time"
+)
+
+// DateLayout is how the dates of the PnL history are written
+const DateLayout = "2006-01-02"
+
+var (
+	ErrInvalidDateRange  = errors.New("invalid date range, expected from and to as YYYY-MM-DD with from not after to")
+	ErrBorrowUnavailable = errors.New("not enough shares left to borrow to sell short")
+)
+
+// Position is a user's holding in one symbol valued at its current price.
+// Quantity is negative for a short position.
+type Position struct {
+	Symbol        string  `json:"symbol"`
+	Quantity      int     `json:"quantity"`
+	EntryPrice    float64 `json:"entry_price"`
+	CurrentPrice  float64 `json:"current_price"`
+	CostBasis     float64 `json:"cost_basis"`
+	MarketValue   float64 `json:"market_value"`
+	UnrealizedPNL float64 `json:"unrealized_pnl"`
+	RealizedPNL   float64 `json:"realized_pnl"`
+	TotalPNL      float64 `json:"total_pnl"`
+	PNLPercentage float64 `json:"pnl_percentage"`
+}
+
+// Summary totals the PnL of every position of a user
+type Summary struct {
+	TotalCostBasis     float64 `json:"total_cost_basis"`
+	TotalMarketValue   float64 `json:"total_market_value"`
+	TotalUnrealizedPNL float64 `json:"total_unrealized_pnl"`
+	TotalRealizedPNL   float64 `json:"total_realized_pnl"`
+	TotalPNL           float64 `json:"total_pnl"`
+	PNLPercentage      float64 `json:"pnl_percentage"`
+}
+
+type PositionsResponse struct {
+	Positions []Position `json:"positions"`
+	Summary   Summary    `json:"summary"`
+}
+
+// Snapshot is what a user's account was worth at the end of a trading day.
+// HoldingsValue only counts holdings in symbols without a position, since a
+// position takes over the holding it started from.
+type Snapshot struct {
+	Date           string  `json:"date"`
+	CashBalance    float64 `json:"cash_balance"`
+	HoldingsValue  float64 `json:"holdings_value"`
+	PositionsValue float64 `json:"positions_value"`
+	TotalValue     float64 `json:"total_value"`
+	UnrealizedPNL  float64 `json:"unrealized_pnl"`
+	RealizedPNL    float64 `json:"realized_pnl"`
+	TotalPNL       float64 `json:"total_pnl"`
+	// TakenAt is when the balances were read
+	TakenAt time.Time `json:"-"`
+}
+
+// Balances is everything a user's account holds at one moment
+type Balances struct {
+	Cash      float64
+	Holdings  float64 // holdings in symbols without a position, as in Snapshot
+	Positions []Position
+	TakenAt   time.Time // database time the balances were read at
+}
+
+type HistoryResponse struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	History []Snapshot `json:"history"`
+}
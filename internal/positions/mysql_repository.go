@@ -0,0 +1,254 @@
+package positions
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"brokerapp/internal/db"
+)
+
+type MySQLRepository struct {
+	db *db.MySQL
+}
+
+func NewMySQLRepository(db *db.MySQL) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// positionsQuery selects the user's positions, including flat ones that have
+// realized PnL
+const positionsQuery = `
+	SELECT symbol, quantity, entry_price, current_price, realized_pnl
+	FROM positions
+	WHERE user_id = ? AND (quantity <> 0 OR realized_pnl <> 0)
+	ORDER BY symbol
+`
+
+// GetPositions returns the user's positions, including flat ones that have
+// realized PnL
+func (r *MySQLRepository) GetPositions(ctx context.Context, userID int64) ([]Position, error) {
+	rows, err := r.db.Query(ctx, positionsQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPositions(rows)
+}
+
+func scanPositions(rows *sql.Rows) ([]Position, error) {
+	var positions []Position
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.Symbol, &p.Quantity, &p.EntryPrice, &p.CurrentPrice, &p.RealizedPNL); err != nil {
+			return nil, err
+		}
+		positions = append(positions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// GetMarks returns every symbol held in an open position or a holding with
+// the price it was last marked at. A position's current price takes
+// precedence over a holding's.
+func (r *MySQLRepository) GetMarks(ctx context.Context) (map[string]float64, error) {
+	marks := make(map[string]float64)
+	queries := []string{
+		`SELECT symbol, price FROM holdings WHERE quantity <> 0 ORDER BY updated_at, id`,
+		`SELECT symbol, current_price FROM positions WHERE quantity <> 0 ORDER BY updated_at, id`,
+	}
+	for _, query := range queries {
+		if err := r.queryMarks(ctx, marks, query); err != nil {
+			return nil, err
+		}
+	}
+	return marks, nil
+}
+
+// queryMarks adds the symbol and price of every row of query to marks,
+// later rows replacing earlier ones
+func (r *MySQLRepository) queryMarks(ctx context.Context, marks map[string]float64, query string) error {
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var symbol string
+		var price float64
+		if err := rows.Scan(&symbol, &price); err != nil {
+			return err
+		}
+		marks[symbol] = price
+	}
+	return rows.Err()
+}
+
+// SavePrice revalues every open position and holding in symbol at price.
+// Each position is updated on its own, against whatever its quantity and
+// entry price are at that moment, so a trade settling at the same time is
+// never blocked on or by more than one row.
+func (r *MySQLRepository) SavePrice(ctx context.Context, symbol string, price float64) error {
+	rows, err := r.db.Query(ctx, `SELECT id FROM positions WHERE symbol = ? AND quantity <> 0`, symbol)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// the same valuation as Position.Mark
+	for _, id := range ids {
+		_, err := r.db.Exec(ctx, `
+			UPDATE positions
+			SET current_price = ?,
+				unrealized_pnl = quantity * (? - entry_price),
+				total_pnl = realized_pnl + quantity * (? - entry_price),
+				pnl_percentage = CASE WHEN quantity = 0 OR entry_price = 0 THEN 0
+					ELSE quantity * (? - entry_price) / (ABS(quantity) * entry_price) * 100 END
+			WHERE id = ?
+		`, price, price, price, price, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = r.db.Exec(ctx, `UPDATE holdings SET price = ?, value = quantity * ? WHERE symbol = ?`, price, price, symbol)
+	return err
+}
+
+// GetUserIDs returns every user in id order
+func (r *MySQLRepository) GetUserIDs(ctx context.Context) ([]int64, error) {
+	rows, err := r.db.Query(ctx, `SELECT id FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetBalances returns the user's cash, the value of their holdings in
+// symbols they have no position in, and their positions. They are read in
+// one transaction, so a trade settling in between cannot be counted in the
+// cash but not the positions or the other way round. TakenAt is the database
+// time of the transaction's first read, so cash flows can be matched against
+// it.
+func (r *MySQLRepository) GetBalances(ctx context.Context, userID int64) (*Balances, error) {
+	b := &Balances{}
+	err := r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			SELECT
+				CURRENT_TIMESTAMP(6),
+				COALESCE((SELECT cash_balance FROM accounts WHERE user_id = ?), 0),
+				COALESCE((
+					SELECT SUM(h.value) FROM holdings h
+					WHERE h.user_id = ? AND NOT EXISTS (
+						SELECT 1 FROM positions p WHERE p.user_id = h.user_id AND p.symbol = h.symbol
+					)
+				), 0)
+		`, userID, userID).Scan(&b.TakenAt, &b.Cash, &b.Holdings)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, positionsQuery, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		b.Positions, err = scanPositions(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SaveSnapshot stores the user's snapshot for its date, replacing one taken
+// earlier the same day. updated_at is always moved on, even when no value
+// changed, since it records when the snapshot was saved.
+func (r *MySQLRepository) SaveSnapshot(ctx context.Context, userID int64, s *Snapshot) error {
+	query := `
+		INSERT INTO pnl_history (user_id, date, cash_balance, holdings_value, positions_value, total_value, unrealized_pnl, realized_pnl, total_pnl, taken_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			cash_balance = VALUES(cash_balance),
+			holdings_value = VALUES(holdings_value),
+			positions_value = VALUES(positions_value),
+			total_value = VALUES(total_value),
+			unrealized_pnl = VALUES(unrealized_pnl),
+			realized_pnl = VALUES(realized_pnl),
+			total_pnl = VALUES(total_pnl),
+			taken_at = VALUES(taken_at),
+			updated_at = CURRENT_TIMESTAMP(6)
+	`
+	_, err := r.db.Exec(ctx, query, userID, s.Date, s.CashBalance, s.HoldingsValue, s.PositionsValue, s.TotalValue, s.UnrealizedPNL, s.RealizedPNL, s.TotalPNL, s.TakenAt)
+	return err
+}
+
+// GetHistory returns the user's snapshots from one date to another,
+// inclusive, oldest first
+func (r *MySQLRepository) GetHistory(ctx context.Context, userID int64, from, to string) ([]Snapshot, error) {
+	query := `
+		SELECT date, cash_balance, holdings_value, positions_value, total_value, unrealized_pnl, realized_pnl, total_pnl
+		FROM pnl_history
+		WHERE user_id = ? AND date BETWEEN ? AND ?
+		ORDER BY date
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []Snapshot{}
+	for rows.Next() {
+		var s Snapshot
+		var date time.Time
+		if err := rows.Scan(&date, &s.CashBalance, &s.HoldingsValue, &s.PositionsValue, &s.TotalValue, &s.UnrealizedPNL, &s.RealizedPNL, &s.TotalPNL); err != nil {
+			return nil, err
+		}
+		s.Date = date.Format(DateLayout)
+		history = append(history, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
@@ -0,0 +1,40 @@
+package positions
+
+// Mark values the position at price, working out its cost basis, market value
+// and PnL. The percentage is the unrealized PnL over the cost of what is
+// still open.
+func (p *Position) Mark(price float64) {
+	p.CurrentPrice = price
+	p.CostBasis = float64(abs(p.Quantity)) * p.EntryPrice
+	p.MarketValue = float64(p.Quantity) * price
+	p.UnrealizedPNL = float64(p.Quantity) * (price - p.EntryPrice)
+	p.TotalPNL = p.RealizedPNL + p.UnrealizedPNL
+	p.PNLPercentage = percentage(p.UnrealizedPNL, p.CostBasis)
+}
+
+// Summarize values every position at its current price and totals them.
+// Only the quantity, entry price, current price and realized PnL of each
+// position are read; everything else is worked out again.
+func Summarize(positions []Position) *PositionsResponse {
+	resp := &PositionsResponse{Positions: make([]Position, len(positions))}
+	sum := &resp.Summary
+	for i, p := range positions {
+		p.Mark(p.CurrentPrice)
+		resp.Positions[i] = p
+
+		sum.TotalCostBasis += p.CostBasis
+		sum.TotalMarketValue += p.MarketValue
+		sum.TotalUnrealizedPNL += p.UnrealizedPNL
+		sum.TotalRealizedPNL += p.RealizedPNL
+	}
+	sum.TotalPNL = sum.TotalRealizedPNL + sum.TotalUnrealizedPNL
+	sum.PNLPercentage = percentage(sum.TotalUnrealizedPNL, sum.TotalCostBasis)
+	return resp
+}
+
+func percentage(pnl, cost float64) float64 {
+	if cost == 0 {
+		return 0
+	}
+	return pnl / cost * 100
+}
@@ -0,0 +1,13 @@
+package positions
+
+import "context"
+
+type Repository interface {
+	GetPositions(ctx context.Context, userID int64) ([]Position, error)
+	GetMarks(ctx context.Context) (map[string]float64, error)
+	SavePrice(ctx context.Context, symbol string, price float64) error
+	GetUserIDs(ctx context.Context) ([]int64, error)
+	GetBalances(ctx context.Context, userID int64) (*Balances, error)
+	SaveSnapshot(ctx context.Context, userID int64, snapshot *Snapshot) error
+	GetHistory(ctx context.Context, userID int64, from, to string) ([]Snapshot, error)
+}
@@ -0,0 +1,129 @@
+package positions
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"brokerapp/internal/pricefeed"
+)
+
+type Service struct {
+	repo Repository
+
+	// feed prices positions and holdings for MarkToMarket; nil leaves them
+	// at the price of their last trade
+	feed pricefeed.PriceFeed
+
+	// loc and endOfDay give when a trading day ends for SnapshotEndOfDay,
+	// endOfDay being an offset from midnight in loc
+	loc      *time.Location
+	endOfDay time.Duration
+	now      func() time.Time
+
+	// lastSnapshot is the last date SnapshotEndOfDay saved, only used by
+	// the goroutine running it
+	lastSnapshot string
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithPriceFeed marks positions and holdings to market at the prices of feed
+func WithPriceFeed(feed pricefeed.PriceFeed) Option {
+	return func(s *Service) {
+		s.feed = feed
+	}
+}
+
+// WithEndOfDay takes the daily PnL snapshot at endOfDay, an offset from
+// midnight in loc
+func WithEndOfDay(loc *time.Location, endOfDay time.Duration) Option {
+	return func(s *Service) {
+		s.loc = loc
+		s.endOfDay = endOfDay
+	}
+}
+
+// WithClock replaces the time source used for the end of day snapshot
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+func NewService(repo Repository, opts ...Option) *Service {
+	s := &Service{repo: repo, loc: time.UTC, endOfDay: 16 * time.Hour, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// GetPositions returns the user's positions and their PnL totals
+func (s *Service) GetPositions(ctx context.Context, userID int64) (*PositionsResponse, error) {
+	positions, err := s.repo.GetPositions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return Summarize(positions), nil
+}
+
+// GetSummary returns the PnL totals of the user's positions
+func (s *Service) GetSummary(ctx context.Context, userID int64) (*Summary, error) {
+	resp, err := s.GetPositions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Summary, nil
+}
+
+// MarkToMarket revalues every open position and holding at the latest price
+// the feed has for its symbol
+func (s *Service) MarkToMarket(ctx context.Context) error {
+	if s.feed == nil {
+		return nil
+	}
+
+	marks, err := s.repo.GetMarks(ctx)
+	if err != nil {
+		return err
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+
+	prices, err := s.feed.Prices(ctx, marks)
+	if err != nil {
+		return err
+	}
+
+	for symbol, price := range prices {
+		// marks holds one price per symbol, but rows in it may have been
+		// marked at others, so every held symbol is saved
+		if _, held := marks[symbol]; !held || price <= 0 {
+			continue
+		}
+		if err := s.repo.SavePrice(ctx, symbol, price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunMarkToMarket calls MarkToMarket every interval until ctx is cancelled
+func (s *Service) RunMarkToMarket(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.MarkToMarket(ctx); err != nil {
+				log.Printf("Error marking positions to market: %v", err)
+			}
+		}
+	}
+}
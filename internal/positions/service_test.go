@@ -0,0 +1,68 @@
+package positions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedFeed always returns the same prices
+type fixedFeed map[string]float64
+
+func (f fixedFeed) Prices(ctx context.Context, marks map[string]float64) (map[string]float64, error) {
+	return f, nil
+}
+
+func TestGetPositions(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("GetPositions", ctx, int64(1)).Return([]Position{
+		// stored PnL columns are ignored and worked out again
+		{Symbol: "AAPL", Quantity: 10, EntryPrice: 150, CurrentPrice: 160, RealizedPNL: 20, UnrealizedPNL: 999},
+		{Symbol: "MSFT", Quantity: -5, EntryPrice: 300, CurrentPrice: 290},
+		{Symbol: "TSLA", RealizedPNL: -50},
+	}, nil)
+
+	resp, err := service.GetPositions(ctx, 1)
+	assert.NoError(t, err)
+
+	aapl := resp.Positions[0]
+	assert.Equal(t, 1500.0, aapl.CostBasis)
+	assert.Equal(t, 1600.0, aapl.MarketValue)
+	assert.InDelta(t, 100.0, aapl.UnrealizedPNL, 1e-9)
+	assert.InDelta(t, 120.0, aapl.TotalPNL, 1e-9)
+	assert.InDelta(t, 100.0/15, aapl.PNLPercentage, 1e-9)
+
+	// a short gains as the price falls
+	msft := resp.Positions[1]
+	assert.InDelta(t, 50.0, msft.UnrealizedPNL, 1e-9)
+	assert.Equal(t, -1450.0, msft.MarketValue)
+
+	assert.Equal(t, Summary{
+		TotalCostBasis:     3000,
+		TotalMarketValue:   150,
+		TotalUnrealizedPNL: 150,
+		TotalRealizedPNL:   -30,
+		TotalPNL:           120,
+		PNLPercentage:      5,
+	}, resp.Summary)
+}
+
+func TestMarkToMarket(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, WithPriceFeed(fixedFeed{"AAPL": 155, "MSFT": 300, "TSLA": 700}))
+
+	ctx := context.Background()
+	mockRepo.On("GetMarks", ctx).Return(map[string]float64{"AAPL": 150, "MSFT": 300}, nil)
+	mockRepo.On("SavePrice", ctx, "AAPL", 155.0).Return(nil).Once()
+	// MSFT is saved too, as some of its rows may not be at 300 yet
+	mockRepo.On("SavePrice", ctx, "MSFT", 300.0).Return(nil).Once()
+
+	assert.NoError(t, service.MarkToMarket(ctx))
+	// nobody holds TSLA
+	mockRepo.AssertNumberOfCalls(t, "SavePrice", 2)
+	mockRepo.AssertExpectations(t)
+}
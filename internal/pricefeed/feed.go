@@ -0,0 +1,13 @@
+// Package pricefeed supplies market prices to value positions and holdings
+// with. Both feeds here run offline: one replays recorded prices from a file
+// and the other simulates them.
+package pricefeed
+
+import "context"
+
+// PriceFeed gives the latest price of the symbols it is asked about
+type PriceFeed interface {
+	// Prices is given the price each symbol was last marked at, zero if it
+	// never was, and returns a new price for those it has one for
+	Prices(ctx context.Context, marks map[string]float64) (map[string]float64, error)
+}
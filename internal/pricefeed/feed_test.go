@@ -0,0 +1,64 @@
+package pricefeed
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	feed, err := NewReplay(strings.NewReader(`time,symbol,price
+2024-02-20T14:30:00Z,AAPL,150
+2024-02-20T14:30:00Z,MSFT,300
+2024-02-20T14:31:00Z,aapl,151
+`))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	marks := map[string]float64{"AAPL": 149, "MSFT": 0, "TSLA": 700}
+
+	prices, err := feed.Prices(ctx, marks)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"AAPL": 150, "MSFT": 300}, prices)
+
+	// MSFT keeps its last replayed price
+	prices, _ = feed.Prices(ctx, marks)
+	assert.Equal(t, map[string]float64{"AAPL": 151, "MSFT": 300}, prices)
+
+	// and the file starts again
+	prices, _ = feed.Prices(ctx, marks)
+	assert.Equal(t, 150.0, prices["AAPL"])
+}
+
+func TestReplayRejectsInvalidFiles(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"time,symbol,price\n",
+		"2024-02-20T14:30:00Z,AAPL\n",
+		"2024-02-20T14:30:00Z,AAPL,0\n",
+		"2024-02-20T14:31:00Z,AAPL,150\n2024-02-20T14:30:00Z,AAPL,151\n",
+	} {
+		_, err := NewReplay(strings.NewReader(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+	ctx := context.Background()
+	marks := map[string]float64{"AAPL": 150, "MSFT": 300, "NEW": 0}
+
+	prices, err := NewRandomWalk(1, 42).Prices(ctx, marks)
+	assert.NoError(t, err)
+	assert.Len(t, prices, 2)
+	for symbol, price := range prices {
+		assert.InDelta(t, marks[symbol], price, marks[symbol]*0.1, symbol)
+		assert.InDelta(t, math.Round(price*100), price*100, 1e-6)
+	}
+
+	// the same seed walks the same way
+	again, _ := NewRandomWalk(1, 42).Prices(ctx, marks)
+	assert.Equal(t, prices, again)
+}
@@ -0,0 +1,51 @@
+package pricefeed
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// RandomWalk simulates prices by moving each symbol's last mark a random
+// step every call. Steps are lognormal, so prices stay positive.
+type RandomWalk struct {
+	mu         sync.Mutex
+	rng        *rand.Rand
+	volatility float64
+}
+
+// NewRandomWalk returns a feed whose steps have a standard deviation of
+// volatility percent. The same seed gives the same prices.
+func NewRandomWalk(volatility float64, seed int64) *RandomWalk {
+	return &RandomWalk{
+		rng:        rand.New(rand.NewSource(seed)),
+		volatility: volatility / 100,
+	}
+}
+
+// Prices steps every symbol that has a mark to start from
+func (f *RandomWalk) Prices(ctx context.Context, marks map[string]float64) (map[string]float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// in a fixed order, so a seed always moves a symbol the same way
+	symbols := make([]string, 0, len(marks))
+	for symbol := range marks {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	prices := make(map[string]float64)
+	for _, symbol := range symbols {
+		mark := marks[symbol]
+		if mark <= 0 {
+			continue
+		}
+		price := mark * math.Exp(f.volatility*f.rng.NormFloat64())
+		// quoted in cents, never below one
+		prices[symbol] = math.Max(math.Round(price*100)/100, 0.01)
+	}
+	return prices, nil
+}
@@ -0,0 +1,103 @@
+package pricefeed
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replay plays back prices recorded in a CSV file of time,symbol,price rows,
+// one point in time per call. Rows at the same time are played together and
+// the file starts again from the top once it runs out.
+type Replay struct {
+	mu     sync.Mutex
+	ticks  [][]quote
+	next   int
+	latest map[string]float64
+}
+
+type quote struct {
+	symbol string
+	price  float64
+}
+
+// LoadReplay reads the prices to replay from a CSV file
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplay(f)
+}
+
+// NewReplay reads the prices to replay from CSV rows of an RFC 3339 time, a
+// symbol and a price, in time order. A first row that does not start with a
+// time is taken as a header.
+func NewReplay(r io.Reader) (*Replay, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &Replay{latest: make(map[string]float64)}
+	var last time.Time
+	for i, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("line %d: expected time,symbol,price", i+1)
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(row[0]))
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("line %d: invalid price %q", i+1, row[2])
+		}
+		if at.Before(last) {
+			return nil, fmt.Errorf("line %d: rows must be in time order", i+1)
+		}
+
+		q := quote{symbol: strings.ToUpper(strings.TrimSpace(row[1])), price: price}
+		if len(feed.ticks) == 0 || at.After(last) {
+			feed.ticks = append(feed.ticks, nil)
+		}
+		tick := &feed.ticks[len(feed.ticks)-1]
+		*tick = append(*tick, q)
+		last = at
+	}
+
+	if len(feed.ticks) == 0 {
+		return nil, fmt.Errorf("no prices to replay")
+	}
+	return feed, nil
+}
+
+// Prices plays the next point in time and returns the latest replayed price
+// of each symbol asked about
+func (f *Replay) Prices(ctx context.Context, marks map[string]float64) (map[string]float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, q := range f.ticks[f.next] {
+		f.latest[q.symbol] = q.price
+	}
+	f.next = (f.next + 1) % len(f.ticks)
+
+	prices := make(map[string]float64)
+	for symbol := range marks {
+		if price, ok := f.latest[symbol]; ok {
+			prices[symbol] = price
+		}
+	}
+	return prices, nil
+}
@@ -0,0 +1,138 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// BuyingPower rejects buy orders worth more than the user's cash less what
+// their other open buy orders have already committed, and buy orders that
+// cannot be valued because the symbol has neither quotes nor trades
+func BuyingPower(repo Repository) Check {
+	return CheckFunc(func(ctx context.Context, o *Order) (*Reason, error) {
+		if o.Side != "buy" {
+			return nil, nil
+		}
+
+		cash, err := repo.GetCash(ctx, o.UserID)
+		if err != nil {
+			return nil, err
+		}
+		committed, err := repo.GetOpenBuyNotional(ctx, o.UserID, o.ReplacesOrderIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		available := cash - committed
+		if o.ReferencePrice == 0 {
+			return &Reason{
+				Check:   CheckBuyingPower,
+				Message: fmt.Sprintf("order cannot be valued against available buying power %.2f: %s has no quotes or trades", available, o.Symbol),
+				Limit:   available,
+			}, nil
+		}
+		if o.Notional() <= available {
+			return nil, nil
+		}
+		return &Reason{
+			Check:   CheckBuyingPower,
+			Message: fmt.Sprintf("order value %.2f exceeds available buying power %.2f", o.Notional(), available),
+			Limit:   available,
+			Value:   o.Notional(),
+		}, nil
+	})
+}
+
+// SellQuantity rejects sell orders for more shares than the user holds less
+// what their other open sell orders in the symbol are already selling, unless
+// their account may sell short. A short sale also needs a locate: there must be
+// enough shares left to borrow for everything the user's open sell orders,
+// this one included, would sell short.
+func SellQuantity(repo Repository) Check {
+	return CheckFunc(func(ctx context.Context, o *Order) (*Reason, error) {
+		if o.Side != "sell" {
+			return nil, nil
+		}
+
+		held, err := repo.GetSellableQuantity(ctx, o.UserID, o.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		selling, err := repo.GetOpenSellQuantity(ctx, o.UserID, o.Symbol, o.ReplacesOrderIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		available := held - selling
+		if o.Quantity <= available {
+			return nil, nil
+		}
+
+		shorting, err := repo.IsShortingEnabled(ctx, o.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !shorting {
+			return &Reason{
+				Check:   CheckSellQuantity,
+				Message: fmt.Sprintf("sell quantity %d exceeds the %d %s shares available to sell and the account is not enabled for short selling", o.Quantity, available, o.Symbol),
+				Limit:   float64(available),
+				Value:   float64(o.Quantity),
+			}, nil
+		}
+
+		// the shares of a short position already held were borrowed when it
+		// was opened
+		short := selling + o.Quantity - max(held, 0)
+		borrowable, err := repo.GetBorrowAvailable(ctx, o.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if short <= borrowable {
+			return nil, nil
+		}
+		return &Reason{
+			Check:   CheckLocate,
+			Message: fmt.Sprintf("selling %d %s shares short needs them to be borrowed but only %d are available", short, o.Symbol, borrowable),
+			Limit:   float64(borrowable),
+			Value:   float64(short),
+		}, nil
+	})
+}
+
+// MaxNotional rejects orders worth more than limit
+func MaxNotional(limit float64) Check {
+	return CheckFunc(func(ctx context.Context, o *Order) (*Reason, error) {
+		if o.Notional() <= limit {
+			return nil, nil
+		}
+		return &Reason{
+			Check:   CheckMaxNotional,
+			Message: fmt.Sprintf("order value %.2f exceeds the maximum of %.2f per order", o.Notional(), limit),
+			Limit:   limit,
+			Value:   o.Notional(),
+		}, nil
+	})
+}
+
+// PriceBand rejects limit prices more than percent away from the last traded
+// price. Orders without a limit price and symbols that have not traded yet pass.
+func PriceBand(percent float64) Check {
+	return CheckFunc(func(ctx context.Context, o *Order) (*Reason, error) {
+		if o.Price == 0 || o.LastPrice == 0 {
+			return nil, nil
+		}
+
+		deviation := math.Abs(o.Price-o.LastPrice) / o.LastPrice * 100
+		if deviation <= percent {
+			return nil, nil
+		}
+		return &Reason{
+			Check:   CheckPriceBand,
+			Message: fmt.Sprintf("price %.2f is %.1f%% away from the last price %.2f, more than the %.1f%% allowed", o.Price, deviation, o.LastPrice, percent),
+			Limit:   percent,
+			Value:   deviation,
+		}, nil
+	})
+}
@@ -0,0 +1,41 @@
+package risk
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) GetCash(ctx context.Context, userID int64) (float64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *MockRepository) GetOpenBuyNotional(ctx context.Context, userID int64, excludeOrderIDs []int64) (float64, error) {
+	args := m.Called(ctx, userID, excludeOrderIDs)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *MockRepository) GetSellableQuantity(ctx context.Context, userID int64, symbol string) (int, error) {
+	args := m.Called(ctx, userID, symbol)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) GetOpenSellQuantity(ctx context.Context, userID int64, symbol string, excludeOrderIDs []int64) (int, error) {
+	args := m.Called(ctx, userID, symbol, excludeOrderIDs)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) IsShortingEnabled(ctx context.Context, userID int64) (bool, error) {
+	args := m.Called(ctx, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) GetBorrowAvailable(ctx context.Context, symbol string) (int, error) {
+	args := m.Called(ctx, symbol)
+	return args.Int(0), args.Error(1)
+}
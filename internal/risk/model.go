@@ -0,0 +1,66 @@
+package risk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the built-in checks, reported on each rejection reason
+const (
+	CheckBuyingPower  = "buying_power"
+	CheckSellQuantity = "sell_quantity"
+	CheckLocate       = "locate"
+	CheckMaxNotional  = "max_notional"
+	CheckPriceBand    = "price_band"
+)
+
+// Order is what the risk checks see of an order that is about to be placed
+type Order struct {
+	UserID    int64
+	Symbol    string
+	Side      string // "buy" or "sell"
+	Type      string
+	Price     float64 // limit price, zero for market and stop orders
+	StopPrice float64
+	Quantity  int
+
+	// ReferencePrice values the order: the limit price if it has one, else
+	// the stop price, else the average price of the opposite orders it would
+	// trade against or the last trade. Zero if none of these is known.
+	ReferencePrice float64
+
+	// LastPrice is the last traded price of the symbol, zero if it has not traded
+	LastPrice float64
+
+	// ReplacesOrderIDs are the user's open orders this one takes the place of,
+	// such as the order it amends, whose exposure is then not counted against
+	// the new one
+	ReplacesOrderIDs []int64
+}
+
+// Notional is the value of the order at its reference price
+func (o *Order) Notional() float64 {
+	return o.ReferencePrice * float64(o.Quantity)
+}
+
+// Reason explains why a check rejected an order. Limit is the most the check
+// allows and Value is what the order would have needed.
+type Reason struct {
+	Check   string  `json:"check"`
+	Message string  `json:"message"`
+	Limit   float64 `json:"limit"`
+	Value   float64 `json:"value"`
+}
+
+// RejectionError is returned when one or more checks rejected an order
+type RejectionError struct {
+	Reasons []Reason
+}
+
+func (e *RejectionError) Error() string {
+	messages := make([]string, 0, len(e.Reasons))
+	for _, r := range e.Reasons {
+		messages = append(messages, r.Message)
+	}
+	return fmt.Sprintf("order rejected by risk checks: %s", strings.Join(messages, "; "))
+}
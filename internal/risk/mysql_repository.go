@@ -0,0 +1,152 @@
+package risk
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"brokerapp/internal/db"
+)
+
+type MySQLRepository struct {
+	db *db.MySQL
+}
+
+func NewMySQLRepository(db *db.MySQL) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// countedOnce leaves out every open order o of a bracket or OCO group but the
+// first, since only one of them can trade. When an order being replaced is in
+// a group, the whole group is left out, as its replacement stands in for it.
+// excluded is the list of placeholders for the IDs of those orders.
+func countedOnce(excluded string) string {
+	return `
+	NOT EXISTS (
+		SELECT 1 FROM orders leg
+		WHERE o.group_type IS NOT NULL AND leg.group_type IS NOT NULL
+		  AND COALESCE(leg.parent_order_id, leg.id) = COALESCE(o.parent_order_id, o.id)
+		  AND leg.side = o.side AND leg.status IN ('queued', 'accepted', 'partially_filled')
+		  AND (leg.id < o.id OR leg.id IN (` + excluded + `))
+	)
+`
+}
+
+// excludeList returns placeholders for ids and their arguments. An empty list
+// excludes nothing, since no order has ID zero.
+func excludeList(ids []int64) (string, []interface{}) {
+	if len(ids) == 0 {
+		ids = []int64{0}
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "?" + strings.Repeat(", ?", len(ids)-1), args
+}
+
+// GetCash returns the user's cash balance, zero if they have no account yet
+func (r *MySQLRepository) GetCash(ctx context.Context, userID int64) (float64, error) {
+	var cash float64
+	err := r.db.QueryRow(ctx, `SELECT cash_balance FROM accounts WHERE user_id = ?`, userID).Scan(&cash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return cash, nil
+}
+
+// GetOpenBuyNotional returns the value of the unfilled part of the user's open
+// buy orders other than excludeOrderIDs, valuing stop orders at their stop
+// price. A bracket or OCO group is counted once.
+func (r *MySQLRepository) GetOpenBuyNotional(ctx context.Context, userID int64, excludeOrderIDs []int64) (float64, error) {
+	excluded, ids := excludeList(excludeOrderIDs)
+	query := `
+		SELECT COALESCE(SUM((o.quantity - o.filled_quantity) * CASE WHEN o.price > 0 THEN o.price ELSE COALESCE(o.stop_price, 0) END), 0)
+		FROM orders o
+		WHERE o.user_id = ? AND o.side = 'buy' AND o.status IN ('queued', 'accepted', 'partially_filled') AND o.id NOT IN (` + excluded + `)
+		  AND ` + countedOnce(excluded)
+
+	args := append([]interface{}{userID}, ids...)
+	args = append(args, ids...)
+
+	var notional float64
+	if err := r.db.QueryRow(ctx, query, args...).Scan(&notional); err != nil {
+		return 0, err
+	}
+
+	return notional, nil
+}
+
+// GetSellableQuantity returns the user's position in symbol, falling back to
+// their holdings when they have no position
+func (r *MySQLRepository) GetSellableQuantity(ctx context.Context, userID int64, symbol string) (int, error) {
+	query := `
+		SELECT COALESCE(
+			(SELECT SUM(quantity) FROM positions WHERE user_id = ? AND symbol = ?),
+			(SELECT SUM(quantity) FROM holdings WHERE user_id = ? AND symbol = ?),
+			0
+		)
+	`
+
+	var quantity int
+	if err := r.db.QueryRow(ctx, query, userID, symbol, userID, symbol).Scan(&quantity); err != nil {
+		return 0, err
+	}
+
+	return quantity, nil
+}
+
+// GetOpenSellQuantity returns the unfilled quantity of the user's open sell
+// orders in symbol other than excludeOrderIDs. A bracket or OCO group is
+// counted once.
+func (r *MySQLRepository) GetOpenSellQuantity(ctx context.Context, userID int64, symbol string, excludeOrderIDs []int64) (int, error) {
+	excluded, ids := excludeList(excludeOrderIDs)
+	query := `
+		SELECT COALESCE(SUM(o.quantity - o.filled_quantity), 0)
+		FROM orders o
+		WHERE o.user_id = ? AND o.symbol = ? AND o.side = 'sell' AND o.status IN ('queued', 'accepted', 'partially_filled') AND o.id NOT IN (` + excluded + `)
+		  AND ` + countedOnce(excluded)
+
+	args := append([]interface{}{userID, symbol}, ids...)
+	args = append(args, ids...)
+
+	var quantity int
+	if err := r.db.QueryRow(ctx, query, args...).Scan(&quantity); err != nil {
+		return 0, err
+	}
+
+	return quantity, nil
+}
+
+// IsShortingEnabled reports whether the user's account may sell short
+func (r *MySQLRepository) IsShortingEnabled(ctx context.Context, userID int64) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow(ctx, `SELECT shorting_enabled FROM accounts WHERE user_id = ?`, userID).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// GetBorrowAvailable returns how many shares of symbol are left to borrow for
+// short sales, zero if none have been located
+func (r *MySQLRepository) GetBorrowAvailable(ctx context.Context, symbol string) (int, error) {
+	var available int
+	err := r.db.QueryRow(ctx, `SELECT available - borrowed FROM borrow_availability WHERE symbol = ?`, symbol).Scan(&available)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return max(available, 0), nil
+}
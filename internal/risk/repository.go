@@ -0,0 +1,12 @@
+package risk
+
+import "context"
+
+type Repository interface {
+	GetCash(ctx context.Context, userID int64) (float64, error)
+	GetOpenBuyNotional(ctx context.Context, userID int64, excludeOrderIDs []int64) (float64, error)
+	GetSellableQuantity(ctx context.Context, userID int64, symbol string) (int, error)
+	GetOpenSellQuantity(ctx context.Context, userID int64, symbol string, excludeOrderIDs []int64) (int, error)
+	IsShortingEnabled(ctx context.Context, userID int64) (bool, error)
+	GetBorrowAvailable(ctx context.Context, symbol string) (int, error)
+}
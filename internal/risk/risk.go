@@ -0,0 +1,45 @@
+package risk
+
+import "context"
+
+// Check is a single pre-trade rule. It returns a reason if the order must be
+// rejected and nil if the order passes.
+type Check interface {
+	Check(ctx context.Context, o *Order) (*Reason, error)
+}
+
+// CheckFunc adapts a plain function to a Check
+type CheckFunc func(ctx context.Context, o *Order) (*Reason, error)
+
+func (f CheckFunc) Check(ctx context.Context, o *Order) (*Reason, error) {
+	return f(ctx, o)
+}
+
+// Pipeline runs a list of checks against every order before it is accepted
+type Pipeline struct {
+	checks []Check
+}
+
+func NewPipeline(checks ...Check) *Pipeline {
+	return &Pipeline{checks: checks}
+}
+
+// Evaluate runs every check and returns a *RejectionError listing all the
+// reasons the order was rejected for, or nil if it passed them all
+func (p *Pipeline) Evaluate(ctx context.Context, o *Order) error {
+	var reasons []Reason
+	for _, c := range p.checks {
+		reason, err := c.Check(ctx, o)
+		if err != nil {
+			return err
+		}
+		if reason != nil {
+			reasons = append(reasons, *reason)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &RejectionError{Reasons: reasons}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuyingPower(t *testing.T) {
+	mockRepo := new(MockRepository)
+	check := BuyingPower(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("GetCash", ctx, int64(1)).Return(10000.0, nil)
+	mockRepo.On("GetOpenBuyNotional", ctx, int64(1), []int64(nil)).Return(4000.0, nil)
+
+	reason, err := check.Check(ctx, &Order{UserID: 1, Side: "buy", Quantity: 40, ReferencePrice: 150})
+	assert.NoError(t, err)
+	assert.Nil(t, reason)
+
+	reason, err = check.Check(ctx, &Order{UserID: 1, Side: "buy", Quantity: 41, ReferencePrice: 150})
+	assert.NoError(t, err)
+	assert.Equal(t, CheckBuyingPower, reason.Check)
+	assert.Equal(t, 6000.0, reason.Limit)
+	assert.Equal(t, 6150.0, reason.Value)
+
+	// sells are not checked
+	reason, err = check.Check(ctx, &Order{UserID: 2, Side: "sell", Quantity: 1000, ReferencePrice: 150})
+	assert.NoError(t, err)
+	assert.Nil(t, reason)
+
+	// buys that cannot be valued are rejected however small
+	reason, err = check.Check(ctx, &Order{UserID: 1, Symbol: "AAPL", Side: "buy", Quantity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, CheckBuyingPower, reason.Check)
+	assert.Equal(t, 6000.0, reason.Limit)
+}
+
+func TestSellQuantity(t *testing.T) {
+	mockRepo := new(MockRepository)
+	check := SellQuantity(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("GetSellableQuantity", ctx, int64(1), "AAPL").Return(100, nil)
+	mockRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64(nil)).Return(50, nil)
+
+	reason, err := check.Check(ctx, &Order{UserID: 1, Symbol: "AAPL", Side: "sell", Quantity: 50})
+	assert.NoError(t, err)
+	assert.Nil(t, reason)
+
+	mockRepo.On("IsShortingEnabled", ctx, int64(1)).Return(false, nil)
+	reason, err = check.Check(ctx, &Order{UserID: 1, Symbol: "AAPL", Side: "sell", Quantity: 51})
+	assert.NoError(t, err)
+	assert.Equal(t, CheckSellQuantity, reason.Check)
+	assert.Equal(t, 50.0, reason.Limit)
+}
+
+func TestSellQuantityShortSale(t *testing.T) {
+	mockRepo := new(MockRepository)
+	check := SellQuantity(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.On("IsShortingEnabled", ctx, int64(1)).Return(true, nil)
+	mockRepo.On("GetBorrowAvailable", ctx, "AAPL").Return(100, nil)
+
+	// 20 held and 10 already being sold, so 70 of 80 would be short
+	mockRepo.On("GetSellableQuantity", ctx, int64(1), "AAPL").Return(20, nil).Once()
+	mockRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64(nil)).Return(10, nil).Once()
+	reason, err := check.Check(ctx, &Order{UserID: 1, Symbol: "AAPL", Side: "sell", Quantity: 80})
+	assert.NoError(t, err)
+	assert.Nil(t, reason)
+
+	// already short 50, whose shares are borrowed, and selling 101 more
+	mockRepo.On("GetSellableQuantity", ctx, int64(1), "AAPL").Return(-50, nil).Once()
+	mockRepo.On("GetOpenSellQuantity", ctx, int64(1), "AAPL", []int64(nil)).Return(0, nil).Once()
+	reason, err = check.Check(ctx, &Order{UserID: 1, Symbol: "AAPL", Side: "sell", Quantity: 101})
+	assert.NoError(t, err)
+	assert.Equal(t, CheckLocate, reason.Check)
+	assert.Equal(t, 100.0, reason.Limit)
+	assert.Equal(t, 101.0, reason.Value)
+}
+
+func TestMaxNotionalAndPriceBand(t *testing.T) {
+	ctx := context.Background()
+
+	reason, _ := MaxNotional(1000).Check(ctx, &Order{Quantity: 10, ReferencePrice: 100})
+	assert.Nil(t, reason)
+	reason, _ = MaxNotional(1000).Check(ctx, &Order{Quantity: 11, ReferencePrice: 100})
+	assert.Equal(t, CheckMaxNotional, reason.Check)
+
+	reason, _ = PriceBand(10).Check(ctx, &Order{Price: 110, LastPrice: 100})
+	assert.Nil(t, reason)
+	reason, _ = PriceBand(10).Check(ctx, &Order{Price: 89, LastPrice: 100})
+	assert.Equal(t, CheckPriceBand, reason.Check)
+	assert.InDelta(t, 11.0, reason.Value, 1e-9)
+
+	// nothing to compare against before the first trade
+	reason, _ = PriceBand(10).Check(ctx, &Order{Price: 500})
+	assert.Nil(t, reason)
+}
+
+func TestPipelineCollectsAllReasons(t *testing.T) {
+	ctx := context.Background()
+	pipeline := NewPipeline(MaxNotional(1000), PriceBand(5))
+
+	err := pipeline.Evaluate(ctx, &Order{Price: 200, Quantity: 10, ReferencePrice: 200, LastPrice: 100})
+	var rejection *RejectionError
+	assert.True(t, errors.As(err, &rejection))
+	assert.Len(t, rejection.Reasons, 2)
+	assert.Equal(t, CheckMaxNotional, rejection.Reasons[0].Check)
+	assert.Equal(t, CheckPriceBand, rejection.Reasons[1].Check)
+
+	assert.NoError(t, pipeline.Evaluate(ctx, &Order{Price: 101, Quantity: 1, ReferencePrice: 101, LastPrice: 100}))
+}
+
+func TestPipelineStopsOnError(t *testing.T) {
+	failure := errors.New("database down")
+	pipeline := NewPipeline(CheckFunc(func(ctx context.Context, o *Order) (*Reason, error) {
+		return nil, failure
+	}), MaxNotional(0))
+
+	err := pipeline.Evaluate(context.Background(), &Order{Quantity: 1, ReferencePrice: 1})
+	assert.Equal(t, failure, err)
+}
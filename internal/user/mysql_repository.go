@@ -11,28 +11,36 @@ import (
 
 type MySQLRepository struct {
 	db *db.MySQL
+
+	// openingBalance is the cash a new user's account starts with
+	openingBalance float64
 }
 
-func NewMySQLRepository(db *db.MySQL) *MySQLRepository {
-	return &MySQLRepository{db: db}
+func NewMySQLRepository(db *db.MySQL, openingBalance float64) *MySQLRepository {
+	return &MySQLRepository{db: db, openingBalance: openingBalance}
 }
 
+// CreateUser stores a new user together with their cash account
 func (r *MySQLRepository) CreateUser(ctx context.Context, user *User) error {
-	query := `
-		INSERT INTO users (email, password, created_at)
-		VALUES (?, ?, ?)
-	`
+	return r.db.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO users (email, password, created_at)
+			VALUES (?, ?, ?)
+		`, user.Email, user.Password, time.Now())
+		if err != nil {
+			return err
+		}
 
-	_, err := r.db.Exec(ctx, query,
-		user.Email,
-		user.Password,
-		time.Now(),
-	)
-	if err != nil {
-		return err
-	}
+		userID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-	return nil
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO accounts (user_id, cash_balance) VALUES (?, ?)
+		`, userID, r.openingBalance)
+		return err
+	})
 }
 
 func (r *MySQLRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
@@ -0,0 +1,17 @@
+-- Create trades table
+CREATE TABLE IF NOT EXISTS trades (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    symbol VARCHAR(50) NOT NULL,
+    price DECIMAL(20,8) NOT NULL,
+    quantity INT NOT NULL,
+    buy_order_id BIGINT NOT NULL,
+    sell_order_id BIGINT NOT NULL,
+    taker_side ENUM('buy', 'sell') NOT NULL,
+    executed_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (buy_order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (sell_order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+-- Create indexes
+CREATE INDEX idx_orders_symbol_status ON orders(symbol, status);
+CREATE INDEX idx_trades_symbol ON trades(symbol);
@@ -0,0 +1,5 @@
+-- Add order types and stop triggers to orders
+ALTER TABLE orders
+    ADD COLUMN type ENUM('market', 'limit', 'stop', 'stop_limit') NOT NULL DEFAULT 'limit' AFTER side,
+    ADD COLUMN stop_price DECIMAL(20,8) NULL AFTER price,
+    ADD COLUMN triggered_at TIMESTAMP NULL AFTER status;
@@ -0,0 +1,8 @@
+-- Add time in force and expiry to orders. Existing orders keep resting until
+-- they are filled or cancelled.
+ALTER TABLE orders
+    ADD COLUMN time_in_force ENUM('DAY', 'GTC', 'IOC', 'FOK', 'GTD') NOT NULL DEFAULT 'GTC' AFTER quantity,
+    ADD COLUMN expires_at TIMESTAMP NULL AFTER time_in_force,
+    MODIFY COLUMN status ENUM('pending', 'filled', 'cancelled', 'expired') NOT NULL;
+
+CREATE INDEX idx_orders_status_expires_at ON orders(status, expires_at);
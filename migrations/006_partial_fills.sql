@@ -0,0 +1,29 @@
+-- Track partial fills on orders
+ALTER TABLE orders
+    ADD COLUMN filled_quantity INT NOT NULL DEFAULT 0 AFTER quantity,
+    ADD COLUMN avg_fill_price DECIMAL(20,8) NULL AFTER filled_quantity,
+    MODIFY COLUMN status ENUM('pending', 'partially_filled', 'filled', 'cancelled', 'expired') NOT NULL;
+
+-- Orders filled before fills were tracked were filled in full at their limit price
+UPDATE orders
+SET filled_quantity = quantity, avg_fill_price = price
+WHERE status = 'filled';
+
+-- Record both users on each trade so fills can be listed per user
+ALTER TABLE trades
+    ADD COLUMN buy_user_id BIGINT NULL AFTER sell_order_id,
+    ADD COLUMN sell_user_id BIGINT NULL AFTER buy_user_id;
+
+UPDATE trades t
+JOIN orders b ON b.id = t.buy_order_id
+JOIN orders s ON s.id = t.sell_order_id
+SET t.buy_user_id = b.user_id, t.sell_user_id = s.user_id;
+
+ALTER TABLE trades
+    MODIFY COLUMN buy_user_id BIGINT NOT NULL,
+    MODIFY COLUMN sell_user_id BIGINT NOT NULL,
+    ADD FOREIGN KEY (buy_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    ADD FOREIGN KEY (sell_user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_trades_buy_user_executed_at ON trades(buy_user_id, executed_at);
+CREATE INDEX idx_trades_sell_user_executed_at ON trades(sell_user_id, executed_at);
@@ -0,0 +1,12 @@
+-- Client supplied order IDs make order submission idempotent
+ALTER TABLE orders
+    ADD COLUMN client_order_id VARCHAR(64) NULL AFTER user_id,
+    ADD UNIQUE KEY uq_orders_user_client_order_id (user_id, client_order_id);
+
+-- Response sent for an order placed with a client order ID, replayed on retries
+CREATE TABLE IF NOT EXISTS order_responses (
+    order_id BIGINT PRIMARY KEY,
+    response JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+-- Cash balance per user, used for buying power checks and settled by trades
+CREATE TABLE IF NOT EXISTS accounts (
+    user_id BIGINT PRIMARY KEY,
+    cash_balance DECIMAL(20,8) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Open orders are summed per user and side by the risk checks
+CREATE INDEX idx_orders_user_side_status ON orders(user_id, side, status);
@@ -0,0 +1,6 @@
+-- Bracket and OCO order groups: children point at the order they belong to
+ALTER TABLE orders
+    ADD COLUMN parent_order_id BIGINT NULL AFTER client_order_id,
+    ADD COLUMN group_type ENUM('bracket', 'oco') NULL AFTER parent_order_id,
+    MODIFY COLUMN status ENUM('new', 'pending', 'partially_filled', 'filled', 'cancelled', 'expired') NOT NULL,
+    ADD FOREIGN KEY (parent_order_id) REFERENCES orders(id) ON DELETE CASCADE;
@@ -0,0 +1,25 @@
+-- Order state machine: pending becomes accepted and rejected is added
+ALTER TABLE orders
+    MODIFY COLUMN status ENUM('new', 'pending', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NOT NULL;
+
+UPDATE orders SET status = 'accepted' WHERE status = 'pending';
+
+ALTER TABLE orders
+    MODIFY COLUMN status ENUM('new', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NOT NULL;
+
+-- Every status change of an order; from_status is NULL when the order is created
+CREATE TABLE IF NOT EXISTS order_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    order_id BIGINT NOT NULL,
+    from_status ENUM('new', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NULL,
+    to_status ENUM('new', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NOT NULL,
+    filled_quantity INT NOT NULL DEFAULT 0,
+    reason VARCHAR(255) NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    INDEX idx_order_events_order (order_id)
+);
+
+-- Orders placed before this migration start their history in their current status
+INSERT INTO order_events (order_id, to_status, filled_quantity, created_at)
+SELECT id, status, filled_quantity, created_at FROM orders;
@@ -0,0 +1,2 @@
+-- Keyset pagination of a user's orders by creation time
+CREATE INDEX idx_orders_user_created ON orders(user_id, created_at, id);
@@ -0,0 +1,16 @@
+-- Time priority of working orders, so the book can be rebuilt after a restart
+ALTER TABLE orders ADD COLUMN book_seq BIGINT UNSIGNED NULL AFTER triggered_at;
+
+-- Orders placed before this migration keep the order they were placed in
+UPDATE orders SET book_seq = id WHERE status IN ('accepted', 'partially_filled');
+
+CREATE INDEX idx_orders_status_book_seq ON orders(status, book_seq);
+
+-- Copies of the in-memory books; only the latest one is kept
+CREATE TABLE IF NOT EXISTS book_snapshots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    event_id BIGINT NOT NULL,
+    trade_id BIGINT NOT NULL,
+    books JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
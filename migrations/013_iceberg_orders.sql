@@ -0,0 +1,2 @@
+-- Iceberg orders show only display_quantity of their size on the book
+ALTER TABLE orders ADD COLUMN display_quantity INT NULL AFTER quantity;
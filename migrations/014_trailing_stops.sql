@@ -0,0 +1,4 @@
+-- Trailing stop orders keep their stop price at this distance from the market
+ALTER TABLE orders
+    ADD COLUMN trail_amount DECIMAL(20,8) NULL AFTER stop_price,
+    ADD COLUMN trail_percent DECIMAL(10,4) NULL AFTER trail_amount;
@@ -0,0 +1,8 @@
+-- What happens when a user's order would trade against their own resting order
+ALTER TABLE accounts
+    ADD COLUMN self_trade_prevention ENUM('none', 'cancel_newest', 'cancel_oldest', 'cancel_both', 'decrement') NOT NULL DEFAULT 'cancel_newest';
+
+-- The account's mode when the order was placed; orders placed before this
+-- migration may trade with their own user
+ALTER TABLE orders
+    ADD COLUMN self_trade_prevention ENUM('none', 'cancel_newest', 'cancel_oldest', 'cancel_both', 'decrement') NULL AFTER time_in_force;
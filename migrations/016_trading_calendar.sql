@@ -0,0 +1,8 @@
+-- Orders placed while their market is closed wait in 'queued' until it opens
+ALTER TABLE orders
+    MODIFY COLUMN status ENUM('new', 'queued', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NOT NULL,
+    ADD COLUMN extended_hours BOOLEAN NOT NULL DEFAULT FALSE AFTER time_in_force;
+
+ALTER TABLE order_events
+    MODIFY COLUMN from_status ENUM('new', 'queued', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NULL,
+    MODIFY COLUMN to_status ENUM('new', 'queued', 'accepted', 'partially_filled', 'filled', 'cancelled', 'rejected', 'expired') NOT NULL;
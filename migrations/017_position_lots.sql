@@ -0,0 +1,22 @@
+-- Positions are maintained from trades, one row per user and symbol
+ALTER TABLE positions ADD UNIQUE KEY uq_positions_user_symbol (user_id, symbol);
+
+-- Open lots of each position; closing fills take from them in the order the
+-- cost basis method picks. Short lots have a negative quantity.
+CREATE TABLE IF NOT EXISTS position_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    symbol VARCHAR(50) NOT NULL,
+    quantity INT NOT NULL,
+    price DECIMAL(20,8) NOT NULL,
+    opened_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_position_lots_user_symbol ON position_lots(user_id, symbol, id);
+
+-- Existing positions start from a single lot at their entry price
+INSERT INTO position_lots (user_id, symbol, quantity, price, opened_at)
+SELECT user_id, symbol, quantity, entry_price, created_at
+FROM positions
+WHERE quantity <> 0;
@@ -0,0 +1,12 @@
+-- Accounts may only sell more than they hold once enabled for short selling
+ALTER TABLE accounts
+    ADD COLUMN shorting_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Shares that can be borrowed to sell short, per symbol. available is what
+-- has been located; borrowed is what open short positions have taken of it.
+CREATE TABLE IF NOT EXISTS borrow_availability (
+    symbol VARCHAR(50) PRIMARY KEY,
+    available INT NOT NULL DEFAULT 0,
+    borrowed INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
@@ -0,0 +1,18 @@
+-- What every account was worth at the end of each trading day, written by
+-- the end of day snapshot job
+CREATE TABLE IF NOT EXISTS pnl_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    date DATE NOT NULL,
+    cash_balance DECIMAL(20,8) NOT NULL,
+    holdings_value DECIMAL(20,8) NOT NULL,
+    positions_value DECIMAL(20,8) NOT NULL,
+    total_value DECIMAL(20,8) NOT NULL,
+    unrealized_pnl DECIMAL(20,8) NOT NULL,
+    realized_pnl DECIMAL(20,8) NOT NULL,
+    total_pnl DECIMAL(20,8) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_pnl_history_user_date (user_id, date),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
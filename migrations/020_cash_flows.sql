@@ -0,0 +1,18 @@
+-- Money and securities moved into or out of each account. Performance
+-- analytics take them out of the change in account value so that a deposit
+-- is not counted as a return. amount is negative for withdrawals.
+CREATE TABLE IF NOT EXISTS cash_flows (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    type ENUM('deposit', 'withdrawal', 'transfer') NOT NULL,
+    amount DECIMAL(20,8) NOT NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_cash_flows_user_created ON cash_flows(user_id, created_at);
+
+-- Cash flows are matched to the snapshot that first includes them by time, so
+-- snapshots need the same precision
+ALTER TABLE pnl_history
+    MODIFY updated_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6);
@@ -0,0 +1,5 @@
+-- When the balances behind a snapshot were read. updated_at is only set once
+-- the snapshot is saved, so a cash flow committed in between would be counted
+-- in the wrong day. Snapshots saved before this fall back to updated_at.
+ALTER TABLE pnl_history
+    ADD COLUMN taken_at TIMESTAMP(6) NULL AFTER total_pnl;
@@ -24,6 +24,7 @@ type Settings struct {
 	Timeout       time.Duration
 	ReadyToTrip   func(counts gobreaker.Counts) bool
 	OnStateChange func(name string, from gobreaker.State, to gobreaker.State)
+	IsSuccessful  func(err error) bool
 }
 
 // New creates a new circuit breaker with the given name and settings
@@ -62,6 +63,7 @@ func New(name string, settings ...Setting) *CircuitBreaker {
 		Timeout:       config.Timeout,
 		ReadyToTrip:   config.ReadyToTrip,
 		OnStateChange: config.OnStateChange,
+		IsSuccessful:  config.IsSuccessful,
 	})
 
 	return &CircuitBreaker{cb: cb}
@@ -105,6 +107,14 @@ func WithOnStateChange(onStateChange func(name string, from gobreaker.State, to
 	}
 }
 
+// WithIsSuccessful sets the function that decides whether an error still
+// counts as a success, so that it does not move the breaker towards tripping
+func WithIsSuccessful(isSuccessful func(err error) bool) Setting {
+	return func(s *Settings) {
+		s.IsSuccessful = isSuccessful
+	}
+}
+
 // Execute runs the given function with circuit breaker protection
 func (c *CircuitBreaker) Execute(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
 	// Create a channel to receive the result
@@ -147,3 +157,19 @@ func (c *CircuitBreaker) ExecuteWithBreaker(ctx context.Context, fn func() (inte
 
 	return result, nil
 }
+
+// ExecuteSync runs the given function with circuit breaker protection on the
+// calling goroutine. Unlike ExecuteWithBreaker it always waits for fn, so the
+// error it returns is fn's own, e.g. whether a transaction committed.
+func (c *CircuitBreaker) ExecuteSync(fn func() (interface{}, error)) (interface{}, error) {
+	result, err := c.cb.Execute(fn)
+
+	if err != nil {
+		if err == gobreaker.ErrOpenState {
+			return nil, ErrCircuitOpen
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -23,8 +23,12 @@ INSERT INTO orders (user_id, symbol, side, price, quantity, status, created_at,
 (5, 'MSFT', 'buy', 300.50, 75, 'filled', NOW(), NOW()),
 (5, 'AMZN', 'buy', 3500.00, 25, 'filled', NOW(), NOW()),
 (5, 'TSLA', 'buy', 700.25, 10, 'filled', NOW(), NOW()),
-(5, 'AAPL', 'sell', 155.00, 50, 'pending', NOW(), NOW()),
-(5, 'MSFT', 'sell', 305.00, 25, 'pending', NOW(), NOW());
+(5, 'AAPL', 'sell', 155.00, 50, 'accepted', NOW(), NOW()),
+(5, 'MSFT', 'sell', 305.00, 25, 'accepted', NOW(), NOW());
+
+-- History for the sample orders
+INSERT INTO order_events (order_id, to_status, filled_quantity, created_at)
+SELECT id, status, filled_quantity, created_at FROM orders WHERE user_id = 5;
 
 -- Sample positions
 INSERT INTO positions (user_id, symbol, quantity, entry_price, current_price, unrealized_pnl, realized_pnl, total_pnl, pnl_percentage, created_at, updated_at) VALUES
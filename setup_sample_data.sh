@@ -3,15 +3,32 @@
 # Connect to MySQL and execute commands
 docker exec -i brokerapp-mysql mysql -u root -ppassword brokerapp << EOF
 
--- Delete existing data in correct order to handle foreign key constraints
+-- Delete existing data in correct order to handle foreign key constraints.
+-- Book snapshots go too, so recovery does not restore orders whose IDs now
+-- belong to the sample orders.
+DELETE FROM book_snapshots;
+DELETE FROM pnl_history;
+DELETE FROM cash_flows;
+DELETE FROM position_lots;
 DELETE FROM positions;
+DELETE FROM borrow_availability;
+DELETE FROM accounts;
+DELETE FROM trades;
+DELETE FROM order_responses;
+DELETE FROM order_events;
 DELETE FROM orders;
 DELETE FROM holdings;
 DELETE FROM refresh_tokens;
 DELETE FROM users;
 
 -- Reset auto-increment counters
+ALTER TABLE book_snapshots AUTO_INCREMENT = 1;
+ALTER TABLE pnl_history AUTO_INCREMENT = 1;
+ALTER TABLE cash_flows AUTO_INCREMENT = 1;
+ALTER TABLE position_lots AUTO_INCREMENT = 1;
 ALTER TABLE positions AUTO_INCREMENT = 1;
+ALTER TABLE trades AUTO_INCREMENT = 1;
+ALTER TABLE order_events AUTO_INCREMENT = 1;
 ALTER TABLE orders AUTO_INCREMENT = 1;
 ALTER TABLE holdings AUTO_INCREMENT = 1;
 ALTER TABLE refresh_tokens AUTO_INCREMENT = 1;
@@ -28,6 +45,19 @@ INSERT INTO users (email, password, created_at, updated_at) VALUES
 -- Wait for users to be created
 SELECT SLEEP(1);
 
+-- Sample cash balances
+INSERT INTO accounts (user_id, cash_balance) VALUES
+(1, 100000.00),
+(2, 100000.00),
+(3, 100000.00);
+
+-- Sample locates for short selling
+INSERT INTO borrow_availability (symbol, available) VALUES
+('AAPL', 10000),
+('MSFT', 5000),
+('GOOGL', 1000),
+('TSLA', 2000);
+
 -- Sample holdings
 INSERT INTO holdings (user_id, symbol, quantity, price, value, created_at, updated_at) VALUES
 (1, 'AAPL', 100, 150.25, 15025.00, NOW(), NOW()),
@@ -45,8 +75,12 @@ INSERT INTO orders (user_id, symbol, side, price, quantity, status, created_at,
 (2, 'AMZN', 'buy', 3500.00, 25, 'filled', NOW(), NOW()),
 (3, 'TSLA', 'buy', 700.25, 10, 'filled', NOW(), NOW()),
 (3, 'NVDA', 'buy', 800.75, 30, 'filled', NOW(), NOW()),
-(1, 'AAPL', 'sell', 155.00, 50, 'pending', NOW(), NOW()),
-(2, 'MSFT', 'sell', 305.00, 25, 'pending', NOW(), NOW());
+(1, 'AAPL', 'sell', 155.00, 50, 'accepted', NOW(), NOW()),
+(2, 'MSFT', 'sell', 305.00, 25, 'accepted', NOW(), NOW());
+
+-- History for the sample orders
+INSERT INTO order_events (order_id, to_status, filled_quantity, created_at)
+SELECT id, status, filled_quantity, created_at FROM orders;
 
 -- Sample positions
 INSERT INTO positions (user_id, symbol, quantity, entry_price, current_price, unrealized_pnl, realized_pnl, total_pnl, pnl_percentage, created_at, updated_at) VALUES